@@ -2,28 +2,19 @@ package app
 
 import (
 	"context"
-	"os"
 	"os/signal"
 	"syscall"
 	"time"
 )
 
 // ContextWithSignal sets up a signal listener, which will cancel the returned context when
-// an interrupt (SIGINT OR SIGTERM) is received.
-func ContextWithSignal(ctx context.Context) context.Context {
+// an interrupt (SIGINT OR SIGTERM) is received. The returned stop func releases the listener and cancels the
+// context, it should be called once the context is no longer needed.
+func ContextWithSignal(ctx context.Context) (context.Context, context.CancelFunc) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	quitCh := make(chan os.Signal, 1)
-	signal.Notify(quitCh, syscall.SIGINT, syscall.SIGTERM)
-
-	ctx, cancel := context.WithCancel(ctx)
-	go func() {
-		defer cancel()
-		<-quitCh
-	}()
-	return ctx
-
+	return signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 }
 
 // SleepContext sleeps for the given duration or until the context is cancelled.
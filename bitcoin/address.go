@@ -0,0 +1,100 @@
+package bitcoin
+
+import (
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/cockroachdb/errors"
+	secp2561k1 "github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// AddressType is the kind of script a bitcoin address pays to.
+type AddressType string
+
+const (
+	AddressTypeP2PKH      AddressType = "p2pkh"
+	AddressTypeP2SH       AddressType = "p2sh"
+	AddressTypeP2SHP2WPKH AddressType = "p2sh-p2wpkh"
+	AddressTypeP2WPKH     AddressType = "p2wpkh"
+	AddressTypeP2WSH      AddressType = "p2wsh"
+	AddressTypeP2TR       AddressType = "p2tr"
+	AddressTypeUnknown    AddressType = "unknown"
+)
+
+// Networks are the bitcoin networks an address is checked against when decoding, in order of preference.
+// Signet shares its address encoding with testnet3, so it will be reported as testnet3.
+var Networks = []*chaincfg.Params{
+	&chaincfg.MainNetParams,
+	&chaincfg.TestNet3Params,
+	&chaincfg.RegressionNetParams,
+}
+
+// DecodeAddress fully decodes a bitcoin address, verifying its Base58Check or bech32/bech32m checksum,
+// and returns the decoded address along with the network it belongs to.
+func DecodeAddress(address string) (btcutil.Address, *chaincfg.Params, error) {
+	var lastErr error
+	for _, params := range Networks {
+		addr, err := btcutil.DecodeAddress(address, params)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if _, ok := addr.(*btcutil.AddressPubKey); ok { // btcutil will happily accept a raw hex public key
+			return nil, nil, errors.Newf("invalid bitcoin address \"%s\", raw public keys are not addresses", address)
+		}
+		if !addr.IsForNet(params) {
+			continue
+		}
+		return addr, params, nil
+	}
+	if lastErr != nil {
+		return nil, nil, errors.Wrapf(lastErr, "invalid bitcoin address \"%s\"", address)
+	}
+	return nil, nil, errors.Newf("invalid bitcoin address \"%s\", unknown network", address)
+}
+
+// GetAddressType returns the type of a decoded address. P2SH addresses are always reported as
+// AddressTypeP2SH, since the redeem script cannot be known from the address alone.
+func GetAddressType(addr btcutil.Address) AddressType {
+	switch addr.(type) {
+	case *btcutil.AddressPubKeyHash:
+		return AddressTypeP2PKH
+	case *btcutil.AddressScriptHash:
+		return AddressTypeP2SH
+	case *btcutil.AddressWitnessPubKeyHash:
+		return AddressTypeP2WPKH
+	case *btcutil.AddressWitnessScriptHash:
+		return AddressTypeP2WSH
+	case *btcutil.AddressTaproot:
+		return AddressTypeP2TR
+	default:
+		return AddressTypeUnknown
+	}
+}
+
+// AddressFromPubKey derives the address of the given type for a public key on the given network.
+// compressed only matters for P2PKH, all segwit address types require a compressed key.
+func AddressFromPubKey(pubKey *secp2561k1.PublicKey, addrType AddressType, compressed bool, params *chaincfg.Params) (btcutil.Address, error) {
+	serialized := pubKey.SerializeCompressed()
+	switch addrType {
+	case AddressTypeP2PKH:
+		if !compressed {
+			serialized = pubKey.SerializeUncompressed()
+		}
+		return btcutil.NewAddressPubKeyHash(btcutil.Hash160(serialized), params)
+	case AddressTypeP2SHP2WPKH:
+		redeemScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(btcutil.Hash160(serialized)).Script()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not build redeem script")
+		}
+		return btcutil.NewAddressScriptHash(redeemScript, params)
+	case AddressTypeP2WPKH:
+		return btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(serialized), params)
+	case AddressTypeP2TR:
+		// BIP-86 key path only output key
+		return btcutil.NewAddressTaproot(schnorr.SerializePubKey(txscript.ComputeTaprootKeyNoScript(pubKey)), params)
+	default:
+		return nil, errors.Errorf("cannot derive %s address from a public key", addrType)
+	}
+}
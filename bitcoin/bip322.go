@@ -0,0 +1,155 @@
+package bitcoin
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/cockroachdb/errors"
+)
+
+// Based on https://github.com/bitcoin/bips/blob/master/bip-0322.mediawiki
+
+// ErrBIP322InvalidSignature is returned when a BIP-322 signature does not validate against the address and message.
+var ErrBIP322InvalidSignature = errors.New("invalid BIP-322 signature")
+
+// BuildToSpendTxBIP322 builds the virtual "to_spend" transaction, which commits to the message and the
+// scriptPubKey of the address that signed it.
+func BuildToSpendTxBIP322(pkScript []byte, message []byte) (*wire.MsgTx, error) {
+	messageHash := CreateMagicMessageBIP322(message)
+	scriptSig, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(messageHash[:]).Script()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not build to_spend scriptSig")
+	}
+
+	toSpend := wire.NewMsgTx(0)
+	toSpend.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{}, Index: 0xFFFFFFFF},
+		SignatureScript:  scriptSig,
+		Sequence:         0,
+	})
+	toSpend.AddTxOut(wire.NewTxOut(0, pkScript))
+	return toSpend, nil
+}
+
+// BuildToSignTxBIP322 builds the virtual "to_sign" transaction which spends the output of toSpend.
+// The witness (and scriptSig if needed) are the signature being verified.
+func BuildToSignTxBIP322(toSpend *wire.MsgTx, witness wire.TxWitness) *wire.MsgTx {
+	toSign := wire.NewMsgTx(0)
+	toSign.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: toSpend.TxHash(), Index: 0},
+		Witness:          witness,
+		Sequence:         0,
+	})
+	toSign.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	return toSign
+}
+
+// VerifyBIP322 verifies a BIP-322 "simple" or "full" signature for the given message against a
+// P2WPKH, P2SH-P2WPKH or P2TR address. The signature may be base64 or hex encoded.
+// Returns nil if the signature is valid.
+func VerifyBIP322(address, message, signature string) error {
+	addr, _, err := DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+	switch addr.(type) {
+	case *btcutil.AddressWitnessPubKeyHash, *btcutil.AddressScriptHash, *btcutil.AddressTaproot:
+	default:
+		return errors.Newf("unsupported address type for BIP-322: %T", addr)
+	}
+
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return errors.Wrap(err, "could not build scriptPubKey")
+	}
+
+	sigBytes, err := ParseSignature(signature)
+	if err != nil {
+		return errors.Wrap(err, "could not decode signature")
+	}
+
+	toSpend, err := BuildToSpendTxBIP322(pkScript, []byte(message))
+	if err != nil {
+		return err
+	}
+
+	toSign, err := parseToSignBIP322(addr, toSpend, sigBytes)
+	if err != nil {
+		return err
+	}
+
+	prevFetcher := txscript.NewCannedPrevOutputFetcher(pkScript, 0)
+	engine, err := txscript.NewEngine(pkScript, toSign, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(toSign, prevFetcher), 0, prevFetcher)
+	if err != nil {
+		return errors.Wrap(err, "could not create script engine")
+	}
+	if err := engine.Execute(); err != nil {
+		return errors.WithSecondaryError(ErrBIP322InvalidSignature, err)
+	}
+	return nil
+}
+
+// parseToSignBIP322 decodes the signature into the "to_sign" transaction, first trying the "simple" format
+// (a consensus encoded witness stack), and then falling back to the "full" format (a whole transaction).
+func parseToSignBIP322(addr btcutil.Address, toSpend *wire.MsgTx, sigBytes []byte) (*wire.MsgTx, error) {
+	witness, err := parseWitnessStack(sigBytes)
+	if err == nil {
+		toSign := BuildToSignTxBIP322(toSpend, witness)
+		if _, ok := addr.(*btcutil.AddressScriptHash); ok {
+			// P2SH-P2WPKH, the redeem script is not part of the witness so we derive it from the public key
+			if len(witness) != 2 {
+				return nil, errors.Newf("expected 2 witness items for P2SH-P2WPKH, got %d", len(witness))
+			}
+			redeemScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(btcutil.Hash160(witness[1])).Script()
+			if err != nil {
+				return nil, errors.Wrap(err, "could not build redeem script")
+			}
+			toSign.TxIn[0].SignatureScript, err = txscript.NewScriptBuilder().AddData(redeemScript).Script()
+			if err != nil {
+				return nil, errors.Wrap(err, "could not build scriptSig")
+			}
+		}
+		return toSign, nil
+	}
+
+	toSign := wire.NewMsgTx(0)
+	reader := bytes.NewReader(sigBytes)
+	if fullErr := toSign.Deserialize(reader); fullErr != nil || reader.Len() != 0 {
+		return nil, errors.Wrap(err, "signature is neither a simple nor a full BIP-322 signature")
+	}
+	if len(toSign.TxIn) != 1 || toSign.TxIn[0].PreviousOutPoint != (wire.OutPoint{Hash: toSpend.TxHash(), Index: 0}) {
+		return nil, errors.New("full BIP-322 signature does not spend the to_spend transaction")
+	}
+	if len(toSign.TxOut) != 1 || toSign.TxOut[0].Value != 0 || !bytes.Equal(toSign.TxOut[0].PkScript, []byte{txscript.OP_RETURN}) {
+		return nil, errors.New("full BIP-322 signature must have a single OP_RETURN output")
+	}
+	return toSign, nil
+}
+
+// parseWitnessStack decodes a consensus encoded witness stack, as used by BIP-322 "simple" signatures.
+func parseWitnessStack(sigBytes []byte) (wire.TxWitness, error) {
+	reader := bytes.NewReader(sigBytes)
+	count, err := wire.ReadVarInt(reader, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read witness count")
+	}
+	if count == 0 || count > uint64(len(sigBytes)) {
+		return nil, errors.Newf("invalid witness count: %d", count)
+	}
+
+	witness := make(wire.TxWitness, count)
+	for i := range witness {
+		witness[i], err = wire.ReadVarBytes(reader, 0, txscript.MaxScriptSize, "witness item")
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read witness item %d", i)
+		}
+	}
+	if reader.Len() != 0 {
+		return nil, errors.Newf("unexpected %d trailing bytes after witness", reader.Len())
+	}
+	return witness, nil
+}
@@ -0,0 +1,123 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CreateMagicMessageBIP322(t *testing.T) {
+	// Test vectors from https://github.com/bitcoin/bips/blob/master/bip-0322.mediawiki#test-vectors
+	empty := CreateMagicMessageBIP322([]byte(""))
+	require.Equal(t, "c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1", hex.EncodeToString(empty[:]))
+
+	helloWorld := CreateMagicMessageBIP322([]byte("Hello World"))
+	require.Equal(t, "f0eb03b1a75ac6d9847f55c624a99169b5dccba2a31f5b23bea77ba270de0a7a", hex.EncodeToString(helloWorld[:]))
+}
+
+func Test_VerifyBIP322(t *testing.T) {
+	tests := []struct {
+		name      string
+		address   string
+		message   string
+		signature string
+		valid     bool
+	}{
+		{
+			name:      "p2wpkh empty message",
+			address:   "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l",
+			message:   "",
+			signature: "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=",
+			valid:     true,
+		},
+		{
+			name:      "p2wpkh hello world",
+			address:   "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l",
+			message:   "Hello World",
+			signature: "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=",
+			valid:     true,
+		},
+		{
+			name:      "p2wpkh wrong message",
+			address:   "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l",
+			message:   "Hello World!",
+			signature: "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=",
+			valid:     false,
+		},
+		{
+			name:      "p2tr hello world",
+			address:   "bc1ppv609nr0vr25u07u95waq5lucwfm6tde4nydujnu8npg4q75mr5sxq8lt3",
+			message:   "Hello World",
+			signature: "AUHd69PrJQEv+oKTfZ8l+WROBHuy9HKrbFCJu7U1iK2iiEy1vMU5EfMtjc+VSHM7aU0SDbak5IUZRVno2P5mjSafAQ==",
+			valid:     true,
+		},
+		{
+			name:      "p2tr wrong message",
+			address:   "bc1ppv609nr0vr25u07u95waq5lucwfm6tde4nydujnu8npg4q75mr5sxq8lt3",
+			message:   "",
+			signature: "AUHd69PrJQEv+oKTfZ8l+WROBHuy9HKrbFCJu7U1iK2iiEy1vMU5EfMtjc+VSHM7aU0SDbak5IUZRVno2P5mjSafAQ==",
+			valid:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyBIP322(test.address, test.message, test.signature)
+			if test.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func Test_VerifyBIP322_P2SHP2WPKH(t *testing.T) {
+	privKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	pubKey := privKey.PubKey().SerializeCompressed()
+
+	witnessProg, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(btcutil.Hash160(pubKey)).Script()
+	require.NoError(t, err)
+	addr, err := btcutil.NewAddressScriptHash(witnessProg, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	pkScript, err := txscript.PayToAddrScript(addr)
+	require.NoError(t, err)
+
+	message := "link my wallet"
+	toSpend, err := BuildToSpendTxBIP322(pkScript, []byte(message))
+	require.NoError(t, err)
+	toSign := BuildToSignTxBIP322(toSpend, nil)
+
+	prevFetcher := txscript.NewCannedPrevOutputFetcher(pkScript, 0)
+	witness, err := txscript.WitnessSignature(toSign, txscript.NewTxSigHashes(toSign, prevFetcher), 0, 0,
+		witnessProg, txscript.SigHashAll, privKey, true)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, wire.WriteVarInt(&buf, 0, uint64(len(witness))))
+	for _, item := range witness {
+		require.NoError(t, wire.WriteVarBytes(&buf, 0, item))
+	}
+	simpleSig := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	require.NoError(t, VerifyBIP322(addr.EncodeAddress(), message, simpleSig))
+	require.Error(t, VerifyBIP322(addr.EncodeAddress(), message+"?", simpleSig))
+
+	// The same signature in the "full" format
+	toSign.TxIn[0].Witness = witness
+	toSign.TxIn[0].SignatureScript, err = txscript.NewScriptBuilder().AddData(witnessProg).Script()
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, toSign.Serialize(&buf))
+
+	require.NoError(t, VerifyBIP322(addr.EncodeAddress(), message, base64.StdEncoding.EncodeToString(buf.Bytes())))
+}
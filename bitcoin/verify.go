@@ -0,0 +1,85 @@
+package bitcoin
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/cockroachdb/errors"
+	secp2561k1 "github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/samber/lo"
+)
+
+// License/Source https://github.com/BitonicNL/verify-signed-message/blob/main/LICENSE
+
+// ErrAddressMismatch is returned when the public key recovered from a signed message does not derive to the given address.
+var ErrAddressMismatch = errors.New("recovered public key does not match address")
+
+// VerifyMessageResult explains how a legacy signed message was verified.
+type VerifyMessageResult struct {
+	// AddressType is the address type that was derived from the recovered public key and matched the address
+	AddressType AddressType
+	// RecoveryFlag is the recovery flag from the signature
+	RecoveryFlag int
+	// Compressed is true if the recovered public key was used in its compressed form
+	Compressed bool
+	// PublicKey is the public key recovered from the signature
+	PublicKey *secp2561k1.PublicKey
+	// Network is the network the address belongs to
+	Network *chaincfg.Params
+}
+
+// VerifyMessage verifies a legacy (Bitcoin Signed Message) signature against a P2PKH, P2SH-P2WPKH, P2WPKH or P2TR address.
+// The signature may be hex or base64 encoded. The recovery flag of the signature determines which address types are derived
+// from the recovered public key, Electrum signs segwit messages with the compressed flags, while Trezor has its own flags.
+func VerifyMessage(address, message, signature string) (VerifyMessageResult, error) {
+	addr, params, err := DecodeAddress(address)
+	if err != nil {
+		return VerifyMessageResult{}, err
+	}
+
+	signatureDecoded, err := ParseSignature(signature)
+	if err != nil {
+		return VerifyMessageResult{}, errors.Wrap(err, "could not decode signature")
+	}
+	if len(signatureDecoded) != ECDSASignatureLen {
+		return VerifyMessageResult{}, errors.Errorf("invalid signature length: %d instead of %d", len(signatureDecoded), ECDSASignatureLen)
+	}
+	recoveryFlag := int(signatureDecoded[0])
+
+	publicKey, err := RecoverPublicKey(message, signatureDecoded)
+	if err != nil {
+		return VerifyMessageResult{}, err
+	}
+
+	result := VerifyMessageResult{
+		RecoveryFlag: recoveryFlag,
+		Compressed:   ShouldBeCompressed(recoveryFlag),
+		PublicKey:    publicKey,
+		Network:      params,
+	}
+
+	for _, addrType := range addressTypesForFlag(recoveryFlag) {
+		derived, err := AddressFromPubKey(publicKey, addrType, result.Compressed, params)
+		if err != nil {
+			return VerifyMessageResult{}, err
+		}
+		if derived.EncodeAddress() == addr.EncodeAddress() {
+			result.AddressType = addrType
+			return result, nil
+		}
+	}
+
+	return result, errors.Wrapf(ErrAddressMismatch, "recovery flag %d, address %s", recoveryFlag, address)
+}
+
+// addressTypesForFlag returns the address types which could have produced a signature with the given recovery flag.
+func addressTypesForFlag(recoveryFlag int) []AddressType {
+	switch {
+	case lo.Contains(UncompressedFlags(), recoveryFlag):
+		return []AddressType{AddressTypeP2PKH}
+	case lo.Contains(TrezorP2SHAndP2WPKHFlags(), recoveryFlag):
+		return []AddressType{AddressTypeP2SHP2WPKH}
+	case lo.Contains(TrezorP2WPKHFlags(), recoveryFlag):
+		return []AddressType{AddressTypeP2WPKH}
+	default: // Compressed flags are used by Electrum and most other wallets for every address type
+		return []AddressType{AddressTypeP2PKH, AddressTypeP2SHP2WPKH, AddressTypeP2WPKH, AddressTypeP2TR}
+	}
+}
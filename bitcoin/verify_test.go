@@ -0,0 +1,66 @@
+package bitcoin
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+func signMessage(t *testing.T, privKey *btcec.PrivateKey, message string, compressed bool, flagOffset byte) string {
+	sig := ecdsa.SignCompact(privKey, chainhash.DoubleHashB([]byte(CreateMagicMessage(message))), compressed)
+	sig[0] += flagOffset
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func Test_VerifyMessage(t *testing.T) {
+	privKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	message := "link my wallet"
+
+	tests := []struct {
+		name       string
+		addrType   AddressType
+		compressed bool
+		flagOffset byte
+		params     *chaincfg.Params
+	}{
+		{name: "uncompressed p2pkh", addrType: AddressTypeP2PKH, compressed: false, params: &chaincfg.MainNetParams},
+		{name: "compressed p2pkh", addrType: AddressTypeP2PKH, compressed: true, params: &chaincfg.MainNetParams},
+		{name: "electrum p2sh-p2wpkh", addrType: AddressTypeP2SHP2WPKH, compressed: true, params: &chaincfg.MainNetParams},
+		{name: "electrum p2wpkh", addrType: AddressTypeP2WPKH, compressed: true, params: &chaincfg.MainNetParams},
+		{name: "p2tr", addrType: AddressTypeP2TR, compressed: true, params: &chaincfg.MainNetParams},
+		{name: "trezor p2sh-p2wpkh", addrType: AddressTypeP2SHP2WPKH, compressed: true, flagOffset: 4, params: &chaincfg.MainNetParams},
+		{name: "trezor p2wpkh", addrType: AddressTypeP2WPKH, compressed: true, flagOffset: 8, params: &chaincfg.MainNetParams},
+		{name: "testnet p2wpkh", addrType: AddressTypeP2WPKH, compressed: true, params: &chaincfg.TestNet3Params},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr, err := AddressFromPubKey(privKey.PubKey(), test.addrType, test.compressed, test.params)
+			require.NoError(t, err)
+			sig := signMessage(t, privKey, message, test.compressed, test.flagOffset)
+
+			res, err := VerifyMessage(addr.EncodeAddress(), message, sig)
+			require.NoError(t, err)
+			require.Equal(t, test.addrType, res.AddressType)
+			require.Equal(t, test.compressed, res.Compressed)
+			require.Equal(t, test.params.Name, res.Network.Name)
+			require.True(t, res.PublicKey.IsEqual(privKey.PubKey()))
+
+			_, err = VerifyMessage(addr.EncodeAddress(), message+"!", sig)
+			require.ErrorIs(t, err, ErrAddressMismatch)
+		})
+	}
+
+	t.Run("trezor p2wpkh flag does not match p2pkh", func(t *testing.T) {
+		addr, err := AddressFromPubKey(privKey.PubKey(), AddressTypeP2PKH, true, &chaincfg.MainNetParams)
+		require.NoError(t, err)
+		_, err = VerifyMessage(addr.EncodeAddress(), message, signMessage(t, privKey, message, true, 8))
+		require.ErrorIs(t, err, ErrAddressMismatch)
+	})
+}
@@ -0,0 +1,305 @@
+package dbutils
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/usecorn/common-lib/conversions"
+)
+
+// maxQueryParams is the maximum number of parameters Postgres allows in a single statement
+const maxQueryParams = 65535
+
+// ErrCopyUnsupported is returned by BulkWriter.Copy when the database does not use the pgx driver
+var ErrCopyUnsupported = errors.New("COPY requires the pgx driver")
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// OnConflict turns the inserts of a BulkWriter into upserts
+type OnConflict struct {
+	// Columns are the columns of the unique constraint
+	Columns []string
+	// Update are the columns which are set to the new values on conflict, the rows are skipped if it is empty
+	Update []string
+}
+
+// BulkConfig configures a BulkWriter
+type BulkConfig struct {
+	// Table is the table to write to, optionally with its schema
+	Table string
+	// BatchSize is the maximum number of rows in each INSERT statement, it defaults to as many as Postgres allows
+	BatchSize int
+	// OnConflict is nil for plain inserts
+	OnConflict *OnConflict
+}
+
+// BulkWriter writes many rows of T at once. The columns are the fields of T, named by their db tags like sqlx, and
+// *big.Int, *big.Rat and *big.Float fields are written as numerics, with *big.Rat rounded to 20 decimal places.
+type BulkWriter[T any] interface {
+	// Columns returns the columns which are written
+	Columns() []string
+	// Insert writes rows with multi-row INSERT statements, q may be a transaction. It returns the number of rows written,
+	// which excludes skipped conflicts.
+	Insert(ctx context.Context, q Querier, rows []T) (int64, error)
+	// Copy writes rows with COPY, which is faster for large numbers of rows, on a connection from db, which must use the
+	// pgx driver. With OnConflict, the rows are copied into a temporary table and then upserted from it, in one transaction.
+	Copy(ctx context.Context, db *sqlx.DB, rows []T) (int64, error)
+}
+
+type bulkWriter[T any] struct {
+	conf    BulkConfig
+	columns []string
+	fields  [][]int
+}
+
+// NewBulkWriter creates a BulkWriter for rows of T, which must be a struct
+func NewBulkWriter[T any](conf BulkConfig) (BulkWriter[T], error) {
+	rowType := reflect.TypeFor[T]()
+	if rowType.Kind() != reflect.Struct {
+		return nil, errors.Newf("bulk rows must be structs, not %s", rowType)
+	}
+	if !identifierRegex.MatchString(conf.Table) {
+		return nil, errors.Newf("invalid table name %q", conf.Table)
+	}
+	bw := &bulkWriter[T]{conf: conf}
+	bw.addFields(rowType, nil)
+	if len(bw.columns) == 0 {
+		return nil, errors.Newf("%s has no columns", rowType)
+	}
+
+	if conf.OnConflict != nil && len(conf.OnConflict.Columns) == 0 {
+		return nil, errors.New("on conflict must have columns")
+	}
+	for _, col := range slices.Concat(bw.columns, conf.OnConflict.columns()) {
+		if !identifierRegex.MatchString(col) || strings.Contains(col, ".") {
+			return nil, errors.Newf("invalid column name %q", col)
+		}
+	}
+
+	maxBatchSize := maxQueryParams / len(bw.columns)
+	if bw.conf.BatchSize <= 0 || bw.conf.BatchSize > maxBatchSize {
+		bw.conf.BatchSize = maxBatchSize
+	}
+	return bw, nil
+}
+
+// columns returns all the columns named by oc, which may be nil
+func (oc *OnConflict) columns() []string {
+	if oc == nil {
+		return nil
+	}
+	return slices.Concat(oc.Columns, oc.Update)
+}
+
+// addFields adds the columns for the exported fields of t, including those of embedded structs without a db tag
+func (bw *bulkWriter[T]) addFields(t reflect.Type, index []int) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			bw.addFields(field.Type, fieldIndex)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = sqlx.NameMapper(field.Name)
+		}
+		bw.columns = append(bw.columns, tag)
+		bw.fields = append(bw.fields, fieldIndex)
+	}
+}
+
+func (bw *bulkWriter[T]) Columns() []string {
+	return bw.columns
+}
+
+// values returns the values of the columns of row, converted to types the drivers can write
+func (bw *bulkWriter[T]) values(row T) ([]any, error) {
+	rowVal := reflect.ValueOf(row)
+	out := make([]any, len(bw.fields))
+	for i, index := range bw.fields {
+		val, err := bulkValue(rowVal.FieldByIndex(index).Interface())
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value for %s", bw.columns[i])
+		}
+		out[i] = val
+	}
+	return out, nil
+}
+
+func bulkValue(val any) (any, error) {
+	switch v := val.(type) {
+	case *big.Int:
+		if v == nil {
+			return nil, nil
+		}
+		return conversions.IntToNumeric(v)
+	case *big.Rat:
+		if v == nil {
+			return nil, nil
+		}
+		return conversions.RatToNumeric(v)
+	case *big.Float:
+		if v == nil {
+			return nil, nil
+		}
+		return conversions.FloatToNumeric(v)
+	case *time.Time:
+		if v == nil {
+			return nil, nil
+		}
+		return *v, nil
+	case uint64:
+		// database/sql does not accept uint64 values with the high bit set
+		if v > math.MaxInt64 {
+			return strconv.FormatUint(v, 10), nil
+		}
+		return int64(v), nil
+	case uint:
+		return bulkValue(uint64(v))
+	default:
+		return val, nil
+	}
+}
+
+// insertQuery returns the INSERT statement for n rows
+func (bw *bulkWriter[T]) insertQuery(n int) string {
+	var query strings.Builder
+	query.WriteString("INSERT INTO " + bw.conf.Table + " (" + strings.Join(bw.columns, ", ") + ") VALUES ")
+	param := 1
+	for i := range n {
+		if i != 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for j := range bw.columns {
+			if j != 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("$" + strconv.Itoa(param))
+			param++
+		}
+		query.WriteString(")")
+	}
+	query.WriteString(bw.onConflictClause())
+	return query.String()
+}
+
+func (bw *bulkWriter[T]) onConflictClause() string {
+	if bw.conf.OnConflict == nil {
+		return ""
+	}
+	clause := " ON CONFLICT (" + strings.Join(bw.conf.OnConflict.Columns, ", ") + ") DO "
+	if len(bw.conf.OnConflict.Update) == 0 {
+		return clause + "NOTHING"
+	}
+	sets := make([]string, len(bw.conf.OnConflict.Update))
+	for i, col := range bw.conf.OnConflict.Update {
+		sets[i] = col + " = EXCLUDED." + col
+	}
+	return clause + "UPDATE SET " + strings.Join(sets, ", ")
+}
+
+func (bw *bulkWriter[T]) Insert(ctx context.Context, q Querier, rows []T) (int64, error) {
+	var written int64
+	for start := 0; start < len(rows); start += bw.conf.BatchSize {
+		batch := rows[start:min(start+bw.conf.BatchSize, len(rows))]
+		args := make([]any, 0, len(batch)*len(bw.columns))
+		for i := range batch {
+			vals, err := bw.values(batch[i])
+			if err != nil {
+				return written, errors.Wrapf(err, "row %d", start+i)
+			}
+			args = append(args, vals...)
+		}
+
+		res, err := q.ExecContext(ctx, bw.insertQuery(len(batch)), args...)
+		if err != nil {
+			return written, errors.Wrapf(err, "failed to insert into %s", bw.conf.Table)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (bw *bulkWriter[T]) Copy(ctx context.Context, db *sqlx.DB, rows []T) (int64, error) {
+	values := make([][]any, len(rows))
+	for i := range rows {
+		vals, err := bw.values(rows[i])
+		if err != nil {
+			return 0, errors.Wrapf(err, "row %d", i)
+		}
+		values[i] = vals
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var written int64
+	err = conn.Raw(func(driverConn any) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.Wrapf(ErrCopyUnsupported, "got %T", driverConn)
+		}
+		pgConn := stdConn.Conn()
+		if bw.conf.OnConflict == nil {
+			written, err = pgConn.CopyFrom(ctx, pgx.Identifier(strings.Split(bw.conf.Table, ".")), bw.columns, pgx.CopyFromRows(values))
+			return err
+		}
+		written, err = bw.copyUpsert(ctx, pgConn, values)
+		return err
+	})
+	if err != nil {
+		return written, errors.Wrapf(err, "failed to copy into %s", bw.conf.Table)
+	}
+	return written, nil
+}
+
+// copyUpsert copies the rows into a temporary table, and then inserts them into the table with the on conflict clause
+func (bw *bulkWriter[T]) copyUpsert(ctx context.Context, conn *pgx.Conn, values [][]any) (int64, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	const tmpTable = "dbutils_bulk_copy"
+	_, err = tx.Exec(ctx, "CREATE TEMPORARY TABLE "+tmpTable+" (LIKE "+bw.conf.Table+" INCLUDING DEFAULTS) ON COMMIT DROP")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{tmpTable}, bw.columns, pgx.CopyFromRows(values)); err != nil {
+		return 0, err
+	}
+	cols := strings.Join(bw.columns, ", ")
+	tag, err := tx.Exec(ctx, "INSERT INTO "+bw.conf.Table+" ("+cols+") SELECT "+cols+" FROM "+tmpTable+bw.onConflictClause())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
@@ -0,0 +1,176 @@
+package dbutils
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usecorn/common-lib/conversions"
+)
+
+type bulkBase struct {
+	TXHash   string `db:"tx_hash"`
+	LogIndex uint   `db:"log_index"`
+}
+
+type bulkTransfer struct {
+	bulkBase
+	Value     *big.Int   `db:"value"`
+	Rate      *big.Rat   `db:"rate"`
+	Points    *big.Float `db:"points"`
+	Timestamp time.Time  `db:"timestamp"`
+	SentAt    *time.Time `db:"sent_at"`
+	Block     uint64
+	Ignored   string `db:"-"`
+	internal  string
+}
+
+func Test_BulkWriter(t *testing.T) {
+	bw, err := NewBulkWriter[bulkTransfer](BulkConfig{
+		Table:      "public.transfers",
+		OnConflict: &OnConflict{Columns: []string{"tx_hash", "log_index"}, Update: []string{"value", "rate"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"tx_hash", "log_index", "value", "rate", "points", "timestamp", "sent_at", "block"}, bw.Columns())
+
+	writer := bw.(*bulkWriter[bulkTransfer])
+	require.Equal(t, maxQueryParams/8, writer.conf.BatchSize)
+	require.Equal(t, "INSERT INTO public.transfers (tx_hash, log_index, value, rate, points, timestamp, sent_at, block) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8), ($9, $10, $11, $12, $13, $14, $15, $16) "+
+		"ON CONFLICT (tx_hash, log_index) DO UPDATE SET value = EXCLUDED.value, rate = EXCLUDED.rate", writer.insertQuery(2))
+
+	now := time.Now()
+	vals, err := writer.values(bulkTransfer{
+		bulkBase:  bulkBase{TXHash: "0x1", LogIndex: 3},
+		Value:     big.NewInt(1000),
+		Rate:      big.NewRat(1, 4),
+		Timestamp: now,
+		Block:     math.MaxUint64,
+		internal:  "x",
+	})
+	require.NoError(t, err)
+	require.Len(t, vals, 8)
+	require.Equal(t, "0x1", vals[0])
+	require.Equal(t, int64(3), vals[1])
+	require.Equal(t, conversions.MustIntToNumeric(big.NewInt(1000)), vals[2])
+	rate, err := conversions.NumericToRat(vals[3].(pgtype.Numeric))
+	require.NoError(t, err)
+	require.Equal(t, big.NewRat(1, 4), rate)
+	require.Nil(t, vals[4])
+	require.Equal(t, now, vals[5])
+	require.Nil(t, vals[6])
+	require.Equal(t, "18446744073709551615", vals[7])
+
+	t.Run("on conflict do nothing", func(t *testing.T) {
+		bw, err := NewBulkWriter[bulkBase](BulkConfig{Table: "t", BatchSize: 10, OnConflict: &OnConflict{Columns: []string{"tx_hash"}}})
+		require.NoError(t, err)
+		require.Equal(t, "INSERT INTO t (tx_hash, log_index) VALUES ($1, $2) ON CONFLICT (tx_hash) DO NOTHING",
+			bw.(*bulkWriter[bulkBase]).insertQuery(1))
+		require.Equal(t, 10, bw.(*bulkWriter[bulkBase]).conf.BatchSize)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewBulkWriter[bulkBase](BulkConfig{Table: "t; DROP TABLE t"})
+		require.Error(t, err)
+		_, err = NewBulkWriter[int](BulkConfig{Table: "t"})
+		require.Error(t, err)
+		_, err = NewBulkWriter[bulkBase](BulkConfig{Table: "t", OnConflict: &OnConflict{}})
+		require.Error(t, err)
+		_, err = NewBulkWriter[bulkBase](BulkConfig{Table: "t", OnConflict: &OnConflict{Columns: []string{"a b"}}})
+		require.Error(t, err)
+	})
+}
+
+func Test_BulkWriter_Postgres(t *testing.T) {
+	sdb := testPostgres(t)
+	ctx := context.Background()
+	_, err := sdb.Exec(`CREATE TABLE bulk_transfers (
+		tx_hash TEXT NOT NULL,
+		log_index BIGINT NOT NULL,
+		value NUMERIC,
+		rate NUMERIC,
+		points NUMERIC,
+		timestamp TIMESTAMPTZ NOT NULL,
+		sent_at TIMESTAMPTZ,
+		block NUMERIC(20) NOT NULL,
+		PRIMARY KEY (tx_hash, log_index)
+	)`)
+	require.NoError(t, err)
+	bigVal, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	row := func(txHash string, logIndex uint, value *big.Int) bulkTransfer {
+		return bulkTransfer{
+			bulkBase:  bulkBase{TXHash: txHash, LogIndex: logIndex},
+			Value:     value,
+			Rate:      big.NewRat(1, 3),
+			Timestamp: now,
+			Block:     math.MaxUint64,
+		}
+	}
+	value := func(txHash string) string {
+		var out string
+		require.NoError(t, sdb.Get(&out, "SELECT value::TEXT FROM bulk_transfers WHERE tx_hash = $1", txHash))
+		return out
+	}
+
+	t.Run("copy", func(t *testing.T) {
+		bw, err := NewBulkWriter[bulkTransfer](BulkConfig{Table: "bulk_transfers"})
+		require.NoError(t, err)
+		n, err := bw.Copy(ctx, sdb, []bulkTransfer{row("0x1", 0, bigVal), row("0x1", 1, big.NewInt(5))})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+		require.Equal(t, bigVal.String(), value("0x1"))
+
+		var stored struct {
+			Rate      string    `db:"rate"`
+			Timestamp time.Time `db:"timestamp"`
+			Block     string    `db:"block"`
+		}
+		require.NoError(t, sdb.Get(&stored, "SELECT rate::TEXT, timestamp, block::TEXT FROM bulk_transfers WHERE tx_hash = '0x1' AND log_index = 0"))
+		require.Equal(t, "0.33333333333333333333", stored.Rate)
+		require.True(t, now.Equal(stored.Timestamp))
+		require.Equal(t, "18446744073709551615", stored.Block)
+	})
+
+	t.Run("copy upsert", func(t *testing.T) {
+		bw, err := NewBulkWriter[bulkTransfer](BulkConfig{
+			Table:      "bulk_transfers",
+			OnConflict: &OnConflict{Columns: []string{"tx_hash", "log_index"}, Update: []string{"value"}},
+		})
+		require.NoError(t, err)
+		n, err := bw.Copy(ctx, sdb, []bulkTransfer{row("0x1", 0, big.NewInt(7)), row("0x2", 0, big.NewInt(8))})
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+		require.Equal(t, "7", value("0x1"))
+		require.Equal(t, "8", value("0x2"))
+	})
+
+	t.Run("insert in batches", func(t *testing.T) {
+		bw, err := NewBulkWriter[bulkTransfer](BulkConfig{
+			Table:      "bulk_transfers",
+			BatchSize:  2,
+			OnConflict: &OnConflict{Columns: []string{"tx_hash", "log_index"}},
+		})
+		require.NoError(t, err)
+		rows := make([]bulkTransfer, 5)
+		for i := range rows {
+			rows[i] = row("0x3", uint(i), big.NewInt(int64(i)))
+		}
+		err = WithTx(ctx, sdb, TxOptions{}, func(ctx context.Context, tx Tx) error {
+			n, err := bw.Insert(ctx, tx, rows)
+			require.Equal(t, int64(5), n)
+			return err
+		})
+		require.NoError(t, err)
+
+		// Conflicting rows are skipped without OnConflict.Update
+		n, err := bw.Insert(ctx, sdb, rows[:2])
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+}
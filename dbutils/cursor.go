@@ -0,0 +1,119 @@
+package dbutils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrCursorMoved is returned when a BlockCursor is not at the expected position, usually because another
+// scanner has moved it since it was loaded
+var ErrCursorMoved = errors.New("block cursor has moved")
+
+// BlockPosition is the progress of a block scanner
+type BlockPosition struct {
+	// NextBlock is the first block which has not been scanned
+	NextBlock uint64 `json:"next_block"`
+	// LastHash is the hash of the last scanned block, NextBlock - 1, if it is known. It allows a reorg of that block
+	// to be detected before scanning on from it.
+	LastHash string `json:"last_hash,omitempty"`
+}
+
+// BlockCursor tracks how far a scanner has got through the blocks of a network for a contract, such as when indexing
+// ERC20.TransferEvents. The position is stored in a MetaDB, and is moved in the transaction which writes the rows for
+// the scanned blocks, so that they are committed together.
+type BlockCursor interface {
+	// Load returns the current position, which is at the start block if nothing has been scanned
+	Load(ctx context.Context) (BlockPosition, error)
+	// Advance moves the cursor forward from from, the position returned by Load, to to. It is run in tx if it is not nil.
+	// ErrCursorMoved is returned if the cursor is no longer at from.
+	Advance(ctx context.Context, tx *sqlx.Tx, from, to BlockPosition) error
+	// Rewind moves the cursor back from from to to, so that the blocks from to.NextBlock are scanned again after a reorg.
+	// It is run in tx if it is not nil. ErrCursorMoved is returned if the cursor is no longer at from.
+	Rewind(ctx context.Context, tx *sqlx.Tx, from, to BlockPosition) error
+}
+
+type blockCursor struct {
+	meta       MetaDB
+	key        string
+	startBlock uint64
+}
+
+// NewBlockCursor creates a BlockCursor for contract on network, starting at startBlock. Cursors for different
+// contracts, or the same contract on different networks, are independent.
+func NewBlockCursor(meta MetaDB, network string, contract string, startBlock uint64) BlockCursor {
+	return &blockCursor{
+		meta:       meta,
+		key:        "block_cursor" + NamespaceSeparator + network + NamespaceSeparator + strings.ToLower(contract),
+		startBlock: startBlock,
+	}
+}
+
+// load returns the current position, along with the stored value, which is nil if nothing has been stored
+func (bc *blockCursor) load(ctx context.Context, meta MetaDB) (BlockPosition, any, error) {
+	raw, err := meta.GetString(ctx, bc.key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return BlockPosition{NextBlock: bc.startBlock}, nil, nil
+	}
+	if err != nil {
+		return BlockPosition{}, nil, errors.Wrapf(err, "failed to load block cursor %s", bc.key)
+	}
+	var pos BlockPosition
+	if err := json.Unmarshal([]byte(raw), &pos); err != nil {
+		return BlockPosition{}, nil, errors.Wrapf(err, "failed to unmarshal block cursor %s", bc.key)
+	}
+	return pos, raw, nil
+}
+
+func (bc *blockCursor) Load(ctx context.Context) (BlockPosition, error) {
+	pos, _, err := bc.load(ctx, bc.meta)
+	return pos, err
+}
+
+func (bc *blockCursor) Advance(ctx context.Context, tx *sqlx.Tx, from, to BlockPosition) error {
+	if to.NextBlock < from.NextBlock {
+		return errors.Newf("cannot advance block cursor %s from %d back to %d", bc.key, from.NextBlock, to.NextBlock)
+	}
+	return bc.move(ctx, tx, from, to)
+}
+
+func (bc *blockCursor) Rewind(ctx context.Context, tx *sqlx.Tx, from, to BlockPosition) error {
+	if to.NextBlock > from.NextBlock {
+		return errors.Newf("cannot rewind block cursor %s from %d forward to %d", bc.key, from.NextBlock, to.NextBlock)
+	}
+	if to.NextBlock < bc.startBlock {
+		to = BlockPosition{NextBlock: bc.startBlock}
+	}
+	return bc.move(ctx, tx, from, to)
+}
+
+func (bc *blockCursor) move(ctx context.Context, tx *sqlx.Tx, from, to BlockPosition) error {
+	meta := bc.meta
+	if tx != nil {
+		meta = meta.WithTx(tx)
+	}
+	current, stored, err := bc.load(ctx, meta)
+	if err != nil {
+		return err
+	}
+	if current != from {
+		return errors.Wrapf(ErrCursorMoved, "%s is at %d, not %d", bc.key, current.NextBlock, from.NextBlock)
+	}
+
+	raw, err := json.Marshal(to)
+	if err != nil {
+		return err
+	}
+	ok, err := meta.CompareAndSet(ctx, bc.key, stored, string(raw))
+	if err != nil {
+		return errors.Wrapf(err, "failed to store block cursor %s", bc.key)
+	}
+	if !ok {
+		return errors.Wrapf(ErrCursorMoved, "%s was moved concurrently", bc.key)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package dbutils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BlockCursor(t *testing.T) {
+	ctx := context.Background()
+	meta, err := NewMetaDBMemory()
+	require.NoError(t, err)
+
+	cursor := NewBlockCursor(meta, "ethereum", "0xABC", 100)
+	other := NewBlockCursor(meta, "bitlayer", "0xabc", 5)
+
+	pos, err := cursor.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, BlockPosition{NextBlock: 100}, pos)
+
+	next := BlockPosition{NextBlock: 150, LastHash: "0x149"}
+	require.NoError(t, cursor.Advance(ctx, nil, pos, next))
+	// A stale scanner cannot advance it again
+	require.ErrorIs(t, cursor.Advance(ctx, nil, pos, BlockPosition{NextBlock: 120}), ErrCursorMoved)
+	require.Error(t, cursor.Advance(ctx, nil, next, pos))
+
+	pos, err = cursor.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, next, pos)
+	val, err := meta.GetString(ctx, "block_cursor::ethereum::0xabc")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"next_block":150,"last_hash":"0x149"}`, val)
+
+	otherPos, err := other.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, BlockPosition{NextBlock: 5}, otherPos)
+
+	t.Run("rewind", func(t *testing.T) {
+		require.Error(t, cursor.Rewind(ctx, nil, pos, BlockPosition{NextBlock: 200}))
+		require.NoError(t, cursor.Rewind(ctx, nil, pos, BlockPosition{NextBlock: 140, LastHash: "0x139"}))
+		require.ErrorIs(t, cursor.Rewind(ctx, nil, pos, BlockPosition{NextBlock: 130}), ErrCursorMoved)
+
+		pos, err := cursor.Load(ctx)
+		require.NoError(t, err)
+		require.Equal(t, BlockPosition{NextBlock: 140, LastHash: "0x139"}, pos)
+
+		// It never rewinds before the start block
+		require.NoError(t, cursor.Rewind(ctx, nil, pos, BlockPosition{NextBlock: 10}))
+		pos, err = cursor.Load(ctx)
+		require.NoError(t, err)
+		require.Equal(t, BlockPosition{NextBlock: 100}, pos)
+	})
+}
@@ -2,57 +2,286 @@ package dbutils
 
 import (
 	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"math/big"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgtype"
 	"github.com/jmoiron/sqlx"
 )
 
+// NamespaceSeparator separates the namespace from the key in namespaced MetaDBs, as in "erc20::decimals"
+const NamespaceSeparator = "::"
+
+var ErrUnsupportedType = errors.New("unsupported type")
+
+// ErrMetaSchemaOutdated is returned by NewMetaDB when the meta table predates the expires_at column
+var ErrMetaSchemaOutdated = errors.New("meta table has no expires_at column, apply MetaSchema or BaseMigrations")
+
+// MetaSchema creates the meta table used by NewMetaDB, and adds the expires_at column to meta tables created before it existed.
+// It is the first of BaseMigrations, for services which use a Migrator.
+//
+//go:embed migrations/0001_meta.up.sql
+var MetaSchema string
+
+// metaPurgeInterval is the minimum time between deleting expired keys
+const metaPurgeInterval = time.Minute
+
 type MetaDB interface {
+	// Set stores val, which may be a string, bool, any integer type, float64, *big.Int or time.Time
 	Set(ctx context.Context, key string, val any) error
+	// SetWithTTL stores val like Set, but the key expires and is treated as missing once ttl has passed
+	SetWithTTL(ctx context.Context, key string, val any, ttl time.Duration) error
+	// PurgeExpired deletes the expired keys, which are otherwise kept until they are set again. It should be called
+	// periodically by services which use SetWithTTL, and purges the keys of all namespaces.
+	PurgeExpired(ctx context.Context) error
 	GetString(ctx context.Context, key string) (out string, err error)
 	GetInt64(ctx context.Context, key string) (int64, error)
 	GetUint64(ctx context.Context, key string) (uint64, error)
+	// Delete removes key, it is not an error if the key does not exist
+	Delete(ctx context.Context, key string) error
+	// ListPrefix returns all the keys starting with prefix, along with their values
+	ListPrefix(ctx context.Context, prefix string) (map[string]string, error)
+	// GetMany returns the values of those keys which exist, in a single query
+	GetMany(ctx context.Context, keys []string) (map[string]string, error)
+	// SetMany stores all of vals like Set, in a single statement so that either all or none of them are stored
+	SetMany(ctx context.Context, vals map[string]any) error
+	// CompareAndSet sets key to val only if its current value is expected, or if it does not exist when expected is nil.
+	// It returns whether the value was set.
+	CompareAndSet(ctx context.Context, key string, expected any, val any) (bool, error)
+	// Increment atomically adds delta to the integer value of key, starting from 0 if it does not exist,
+	// and returns the new value
+	Increment(ctx context.Context, key string, delta int64) (int64, error)
+	// WithTx returns a MetaDB which runs its queries in tx, so that changes are committed along with the
+	// rest of the transaction. It must not be used after tx is committed or rolled back.
+	WithTx(tx *sqlx.Tx) MetaDB
+}
+
+// formatMetaValue converts a value to the string representation stored in the meta table
+func formatMetaValue(val any) (string, error) {
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case *big.Int:
+		if v == nil {
+			return "", errors.Wrap(ErrUnsupportedType, "nil *big.Int")
+		}
+		return v.String(), nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), nil
+	default:
+		return "", errors.Wrapf(ErrUnsupportedType, "%T", val)
+	}
+}
+
+// GetBool gets a value stored as a bool
+func GetBool(ctx context.Context, meta MetaDB, key string) (bool, error) {
+	rawVal, err := meta.GetString(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(rawVal)
+}
+
+// GetBigInt gets a value stored as a *big.Int
+func GetBigInt(ctx context.Context, meta MetaDB, key string) (*big.Int, error) {
+	rawVal, err := meta.GetString(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	out, ok := new(big.Int).SetString(rawVal, 10)
+	if !ok {
+		return nil, errors.Newf("invalid integer %q for %s", rawVal, key)
+	}
+	return out, nil
+}
+
+// GetTime gets a value stored as a time.Time
+func GetTime(ctx context.Context, meta MetaDB, key string) (time.Time, error) {
+	rawVal, err := meta.GetString(ctx, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, rawVal)
+}
+
+// SetJSON stores val as JSON
+func SetJSON[T any](ctx context.Context, meta MetaDB, key string, val T) error {
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s", key)
+	}
+	return meta.Set(ctx, key, string(raw))
+}
+
+// GetJSON gets a value stored with SetJSON
+func GetJSON[T any](ctx context.Context, meta MetaDB, key string) (T, error) {
+	var out T
+	rawVal, err := meta.GetString(ctx, key)
+	if err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal([]byte(rawVal), &out); err != nil {
+		return out, errors.Wrapf(err, "failed to unmarshal %s", key)
+	}
+	return out, nil
 }
 
 type metaDB struct {
-	db      *sqlx.DB
-	getMeta *sqlx.Stmt
-	setMeta *sqlx.Stmt
+	db            *sqlx.DB
+	getMeta       *sqlx.Stmt
+	setMeta       *sqlx.Stmt
+	deleteMeta    *sqlx.Stmt
+	listMeta      *sqlx.Stmt
+	getManyMeta   *sqlx.Stmt
+	setManyMeta   *sqlx.Stmt
+	insertMeta    *sqlx.Stmt
+	swapMeta      *sqlx.Stmt
+	incrementMeta *sqlx.Stmt
+	setMetaTTL    *sqlx.Stmt
+	purgeMeta     *sqlx.Stmt
 }
 
+// NewMetaDB creates a MetaDB backed by the meta table, created by MetaSchema or BaseMigrations. Meta tables created
+// before keys could expire must be migrated first, otherwise ErrMetaSchemaOutdated is returned.
 func NewMetaDB(sdb *sqlx.DB) (MetaDB, error) {
-	getMeta, err := sdb.Preparex("SELECT value FROM meta WHERE key = $1")
+	var hasExpiry bool
+	err := sdb.Get(&hasExpiry, `SELECT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = ANY (current_schemas(false)) AND table_name = 'meta' AND column_name = 'expires_at')`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check the meta table")
+	}
+	if !hasExpiry {
+		return nil, ErrMetaSchemaOutdated
+	}
+	getMeta, err := sdb.Preparex("SELECT value FROM meta WHERE key = $1 AND (expires_at IS NULL OR expires_at > now())")
+	if err != nil {
+		return nil, err
+	}
+	setMeta, err := sdb.Preparex("INSERT INTO meta (key,value) VALUES($1,$2) ON CONFLICT (key) DO UPDATE SET value = $2, expires_at = NULL")
+	if err != nil {
+		return nil, err
+	}
+	setMetaTTL, err := sdb.Preparex(`INSERT INTO meta (key,value,expires_at) VALUES($1,$2,now() + make_interval(secs => $3))
+		ON CONFLICT (key) DO UPDATE SET value = $2, expires_at = EXCLUDED.expires_at`)
+	if err != nil {
+		return nil, err
+	}
+	purgeMeta, err := sdb.Preparex("DELETE FROM meta WHERE expires_at <= now()")
+	if err != nil {
+		return nil, err
+	}
+	deleteMeta, err := sdb.Preparex("DELETE FROM meta WHERE key = $1")
 	if err != nil {
 		return nil, err
 	}
-	setMeta, err := sdb.Preparex("INSERT INTO meta (key,value) VALUES($1,$2) ON CONFLICT (key) DO UPDATE SET value = $2")
+	listMeta, err := sdb.Preparex(`SELECT key, value FROM meta WHERE key LIKE $1 ESCAPE '\' AND (expires_at IS NULL OR expires_at > now())`)
+	if err != nil {
+		return nil, err
+	}
+	getManyMeta, err := sdb.Preparex("SELECT key, value FROM meta WHERE key = ANY($1::TEXT[]) AND (expires_at IS NULL OR expires_at > now())")
+	if err != nil {
+		return nil, err
+	}
+	setManyMeta, err := sdb.Preparex(`INSERT INTO meta (key,value) SELECT * FROM unnest($1::TEXT[], $2::TEXT[])
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = NULL`)
+	if err != nil {
+		return nil, err
+	}
+	// Expired keys are treated as missing, so they can be replaced
+	insertMeta, err := sdb.Preparex(`INSERT INTO meta (key,value) VALUES($1,$2)
+		ON CONFLICT (key) DO UPDATE SET value = $2, expires_at = NULL WHERE meta.expires_at <= now()`)
+	if err != nil {
+		return nil, err
+	}
+	swapMeta, err := sdb.Preparex("UPDATE meta SET value = $3 WHERE key = $1 AND value = $2 AND (expires_at IS NULL OR expires_at > now())")
+	if err != nil {
+		return nil, err
+	}
+	incrementMeta, err := sdb.Preparex(`INSERT INTO meta (key,value) VALUES($1,$2)
+		ON CONFLICT (key) DO UPDATE SET
+			value = CASE WHEN meta.expires_at <= now() THEN $2 ELSE (meta.value::BIGINT + $2::BIGINT)::TEXT END,
+			expires_at = CASE WHEN meta.expires_at <= now() THEN NULL ELSE meta.expires_at END
+		RETURNING value`)
 	if err != nil {
 		return nil, err
 	}
 
 	return &metaDB{
-		db:      sdb,
-		getMeta: getMeta,
-		setMeta: setMeta,
+		db:            sdb,
+		getMeta:       getMeta,
+		setMeta:       setMeta,
+		deleteMeta:    deleteMeta,
+		listMeta:      listMeta,
+		getManyMeta:   getManyMeta,
+		setManyMeta:   setManyMeta,
+		insertMeta:    insertMeta,
+		swapMeta:      swapMeta,
+		incrementMeta: incrementMeta,
+		setMetaTTL:    setMetaTTL,
+		purgeMeta:     purgeMeta,
 	}, nil
 }
 
+func (meta *metaDB) WithTx(tx *sqlx.Tx) MetaDB {
+	return &metaDB{
+		db:            meta.db,
+		getMeta:       tx.Stmtx(meta.getMeta),
+		setMeta:       tx.Stmtx(meta.setMeta),
+		deleteMeta:    tx.Stmtx(meta.deleteMeta),
+		listMeta:      tx.Stmtx(meta.listMeta),
+		getManyMeta:   tx.Stmtx(meta.getManyMeta),
+		setManyMeta:   tx.Stmtx(meta.setManyMeta),
+		insertMeta:    tx.Stmtx(meta.insertMeta),
+		swapMeta:      tx.Stmtx(meta.swapMeta),
+		incrementMeta: tx.Stmtx(meta.incrementMeta),
+		setMetaTTL:    tx.Stmtx(meta.setMetaTTL),
+		purgeMeta:     tx.Stmtx(meta.purgeMeta),
+	}
+}
+
 func (meta *metaDB) Set(ctx context.Context, key string, val any) error {
-	var err error
-	switch v := val.(type) {
-	case string:
-		_, err = meta.setMeta.ExecContext(ctx, key, v)
-	case int:
-		_, err = meta.setMeta.ExecContext(ctx, key, strconv.Itoa(v))
-	case int64:
-		_, err = meta.setMeta.ExecContext(ctx, key, strconv.FormatInt(v, 10))
-	case uint64:
-		_, err = meta.setMeta.ExecContext(ctx, key, strconv.FormatUint(v, 10))
-	default:
-		err = errors.New("unsupported type")
+	strVal, err := formatMetaValue(val)
+	if err != nil {
+		return err
+	}
+	_, err = meta.setMeta.ExecContext(ctx, key, strVal)
+	return err
+}
+
+func (meta *metaDB) SetWithTTL(ctx context.Context, key string, val any, ttl time.Duration) error {
+	strVal, err := formatMetaValue(val)
+	if err != nil {
+		return err
 	}
+	_, err = meta.setMetaTTL.ExecContext(ctx, key, strVal, ttl.Seconds())
+	return err
+}
 
+func (meta *metaDB) PurgeExpired(ctx context.Context) error {
+	_, err := meta.purgeMeta.ExecContext(ctx)
 	return err
 }
 
@@ -75,3 +304,105 @@ func (meta *metaDB) GetUint64(ctx context.Context, key string) (uint64, error) {
 	}
 	return strconv.ParseUint(rawVal, 10, 64)
 }
+
+func (meta *metaDB) Delete(ctx context.Context, key string) error {
+	_, err := meta.deleteMeta.ExecContext(ctx, key)
+	return err
+}
+
+func (meta *metaDB) ListPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	var rows []struct {
+		Key   string `db:"key"`
+		Value string `db:"value"`
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	if err := meta.listMeta.SelectContext(ctx, &rows, escaper.Replace(prefix)+"%"); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(rows))
+	for _, row := range rows {
+		out[row.Key] = row.Value
+	}
+	return out, nil
+}
+
+func (meta *metaDB) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	out := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return out, nil
+	}
+	var keyArr pgtype.TextArray
+	if err := keyArr.Set(keys); err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Key   string `db:"key"`
+		Value string `db:"value"`
+	}
+	if err := meta.getManyMeta.SelectContext(ctx, &rows, &keyArr); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		out[row.Key] = row.Value
+	}
+	return out, nil
+}
+
+func (meta *metaDB) SetMany(ctx context.Context, vals map[string]any) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	// Keys are written in order, so that concurrent SetMany calls lock the rows in the same order
+	keys := make([]string, 0, len(vals))
+	for key := range vals {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	strVals := make([]string, len(keys))
+	for i, key := range keys {
+		strVal, err := formatMetaValue(vals[key])
+		if err != nil {
+			return errors.Wrapf(err, "invalid value of %s", key)
+		}
+		strVals[i] = strVal
+	}
+	var keyArr, valArr pgtype.TextArray
+	if err := keyArr.Set(keys); err != nil {
+		return err
+	}
+	if err := valArr.Set(strVals); err != nil {
+		return err
+	}
+	_, err := meta.setManyMeta.ExecContext(ctx, &keyArr, &valArr)
+	return err
+}
+
+func (meta *metaDB) CompareAndSet(ctx context.Context, key string, expected any, val any) (bool, error) {
+	strVal, err := formatMetaValue(val)
+	if err != nil {
+		return false, err
+	}
+	var res sql.Result
+	if expected == nil {
+		res, err = meta.insertMeta.ExecContext(ctx, key, strVal)
+	} else {
+		strExpected, fmtErr := formatMetaValue(expected)
+		if fmtErr != nil {
+			return false, fmtErr
+		}
+		res, err = meta.swapMeta.ExecContext(ctx, key, strExpected, strVal)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (meta *metaDB) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	var rawVal string
+	if err := meta.incrementMeta.GetContext(ctx, &rawVal, key, strconv.FormatInt(delta, 10)); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(rawVal, 10, 64)
+}
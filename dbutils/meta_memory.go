@@ -4,42 +4,102 @@ import (
 	"context"
 	"database/sql"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/jmoiron/sqlx"
 )
 
+type metaMemoryValue struct {
+	val string
+	// expiresAt is zero if the key never expires
+	expiresAt time.Time
+}
+
 type metaDBMemory struct {
-	data map[string]string
+	mux        sync.Mutex
+	data       map[string]metaMemoryValue
+	lastPurged time.Time
+	now        func() time.Time
 }
 
-// NewMetaDBMemory creates a new MetaDB instance with in-memory storage.
+// NewMetaDBMemory creates a new MetaDB instance with in-memory storage, which is safe for concurrent use.
 // This is useful for testing purposes or when you don't need persistent storage.
 func NewMetaDBMemory() (MetaDB, error) {
 
 	return &metaDBMemory{
-		data: map[string]string{},
+		data: map[string]metaMemoryValue{},
+		now:  time.Now,
 	}, nil
 }
 
+// get returns the value of key if it exists and has not expired, the lock must be held
+func (meta *metaDBMemory) get(key string) (string, bool) {
+	val, ok := meta.data[key]
+	if !ok {
+		return "", false
+	}
+	if !val.expiresAt.IsZero() && !val.expiresAt.After(meta.now()) {
+		delete(meta.data, key)
+		return "", false
+	}
+	return val.val, true
+}
+
+// set stores the value of key and occasionally deletes expired keys, the lock must be held
+func (meta *metaDBMemory) set(key string, val string, expiresAt time.Time) {
+	meta.data[key] = metaMemoryValue{val: val, expiresAt: expiresAt}
+
+	if now := meta.now(); now.Sub(meta.lastPurged) >= metaPurgeInterval {
+		meta.purge(now)
+	}
+}
+
+// purge deletes the keys which have expired by now, the lock must be held
+func (meta *metaDBMemory) purge(now time.Time) {
+	meta.lastPurged = now
+	for k, v := range meta.data {
+		if !v.expiresAt.IsZero() && !v.expiresAt.After(now) {
+			delete(meta.data, k)
+		}
+	}
+}
+
 func (meta *metaDBMemory) Set(ctx context.Context, key string, val any) error {
-	switch v := val.(type) {
-	case string:
-		meta.data[key] = v
-	case int:
-		meta.data[key] = strconv.Itoa(v)
-	case int64:
-		meta.data[key] = strconv.FormatInt(v, 10)
-	case uint64:
-		meta.data[key] = strconv.FormatUint(v, 10)
-	default:
-		return errors.New("unsupported type")
+	strVal, err := formatMetaValue(val)
+	if err != nil {
+		return err
+	}
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	meta.set(key, strVal, time.Time{})
+	return nil
+}
+
+func (meta *metaDBMemory) SetWithTTL(ctx context.Context, key string, val any, ttl time.Duration) error {
+	strVal, err := formatMetaValue(val)
+	if err != nil {
+		return err
 	}
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	meta.set(key, strVal, meta.now().Add(ttl))
+	return nil
+}
 
+func (meta *metaDBMemory) PurgeExpired(ctx context.Context) error {
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	meta.purge(meta.now())
 	return nil
 }
 
 func (meta *metaDBMemory) GetString(ctx context.Context, key string) (out string, err error) {
-	val, ok := meta.data[key]
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	val, ok := meta.get(key)
 	if !ok {
 		return "", sql.ErrNoRows
 	}
@@ -61,3 +121,105 @@ func (meta *metaDBMemory) GetUint64(ctx context.Context, key string) (uint64, er
 	}
 	return strconv.ParseUint(rawVal, 10, 64)
 }
+
+func (meta *metaDBMemory) Delete(ctx context.Context, key string) error {
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	delete(meta.data, key)
+	return nil
+}
+
+func (meta *metaDBMemory) ListPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	out := map[string]string{}
+	for key := range meta.data {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if val, ok := meta.get(key); ok {
+			out[key] = val
+		}
+	}
+	return out, nil
+}
+
+func (meta *metaDBMemory) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	out := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if val, ok := meta.get(key); ok {
+			out[key] = val
+		}
+	}
+	return out, nil
+}
+
+func (meta *metaDBMemory) SetMany(ctx context.Context, vals map[string]any) error {
+	strVals := make(map[string]string, len(vals))
+	for key, val := range vals {
+		strVal, err := formatMetaValue(val)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value of %s", key)
+		}
+		strVals[key] = strVal
+	}
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	for key, strVal := range strVals {
+		meta.set(key, strVal, time.Time{})
+	}
+	return nil
+}
+
+func (meta *metaDBMemory) CompareAndSet(ctx context.Context, key string, expected any, val any) (bool, error) {
+	strVal, err := formatMetaValue(val)
+	if err != nil {
+		return false, err
+	}
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	current, ok := meta.get(key)
+	if expected == nil {
+		if ok {
+			return false, nil
+		}
+	} else {
+		strExpected, err := formatMetaValue(expected)
+		if err != nil {
+			return false, err
+		}
+		if !ok || current != strExpected {
+			return false, nil
+		}
+	}
+	if expected == nil {
+		meta.set(key, strVal, time.Time{})
+	} else { // Keep the expiry of the current value
+		meta.set(key, strVal, meta.data[key].expiresAt)
+	}
+	return true, nil
+}
+
+func (meta *metaDBMemory) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	meta.mux.Lock()
+	defer meta.mux.Unlock()
+	var current int64
+	rawVal, ok := meta.get(key)
+	if ok {
+		var err error
+		current, err = strconv.ParseInt(rawVal, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	current += delta
+	meta.set(key, strconv.FormatInt(current, 10), meta.data[key].expiresAt)
+	return current, nil
+}
+
+// WithTx returns the same MetaDB, as the in-memory storage is not transactional
+func (meta *metaDBMemory) WithTx(_ *sqlx.Tx) MetaDB {
+	return meta
+}
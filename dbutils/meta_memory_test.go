@@ -0,0 +1,208 @@
+package dbutils
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MetaDBMemory(t *testing.T) {
+	ctx := context.Background()
+	meta, err := NewMetaDBMemory()
+	require.NoError(t, err)
+
+	t.Run("types", func(t *testing.T) {
+		require.NoError(t, meta.Set(ctx, "bool", true))
+		b, err := GetBool(ctx, meta, "bool")
+		require.NoError(t, err)
+		require.True(t, b)
+
+		bigVal, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+		require.NoError(t, meta.Set(ctx, "big", bigVal))
+		gotBig, err := GetBigInt(ctx, meta, "big")
+		require.NoError(t, err)
+		require.Equal(t, 0, bigVal.Cmp(gotBig))
+
+		now := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+		require.NoError(t, meta.Set(ctx, "time", now))
+		gotTime, err := GetTime(ctx, meta, "time")
+		require.NoError(t, err)
+		require.True(t, now.Equal(gotTime))
+
+		type cursor struct {
+			Block int64  `json:"block"`
+			Hash  string `json:"hash"`
+		}
+		require.NoError(t, SetJSON(ctx, meta, "json", cursor{Block: 10, Hash: "0xabc"}))
+		gotCursor, err := GetJSON[cursor](ctx, meta, "json")
+		require.NoError(t, err)
+		require.Equal(t, cursor{Block: 10, Hash: "0xabc"}, gotCursor)
+
+		require.ErrorIs(t, meta.Set(ctx, "unsupported", struct{}{}), ErrUnsupportedType)
+	})
+
+	t.Run("delete and list", func(t *testing.T) {
+		require.NoError(t, meta.Set(ctx, "list::a", 1))
+		require.NoError(t, meta.Set(ctx, "list::b", 2))
+		require.NoError(t, meta.Set(ctx, "lists", 3))
+		vals, err := meta.ListPrefix(ctx, "list::")
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"list::a": "1", "list::b": "2"}, vals)
+
+		require.NoError(t, meta.Delete(ctx, "list::a"))
+		require.NoError(t, meta.Delete(ctx, "list::missing"))
+		_, err = meta.GetString(ctx, "list::a")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("get and set many", func(t *testing.T) {
+		require.NoError(t, meta.SetMany(ctx, map[string]any{"many::a": 1, "many::b": "two"}))
+		vals, err := meta.GetMany(ctx, []string{"many::a", "many::b", "many::missing"})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"many::a": "1", "many::b": "two"}, vals)
+
+		require.ErrorIs(t, meta.SetMany(ctx, map[string]any{"many::c": 3, "many::d": struct{}{}}), ErrUnsupportedType)
+		_, err = meta.GetString(ctx, "many::c")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("compare and set", func(t *testing.T) {
+		ok, err := meta.CompareAndSet(ctx, "cas", nil, int64(1))
+		require.NoError(t, err)
+		require.True(t, ok)
+		ok, err = meta.CompareAndSet(ctx, "cas", nil, int64(2))
+		require.NoError(t, err)
+		require.False(t, ok)
+		ok, err = meta.CompareAndSet(ctx, "cas", int64(2), int64(3))
+		require.NoError(t, err)
+		require.False(t, ok)
+		ok, err = meta.CompareAndSet(ctx, "cas", int64(1), int64(3))
+		require.NoError(t, err)
+		require.True(t, ok)
+		val, err := meta.GetInt64(ctx, "cas")
+		require.NoError(t, err)
+		require.Equal(t, int64(3), val)
+	})
+
+	t.Run("increment", func(t *testing.T) {
+		wg := sync.WaitGroup{}
+		for range 100 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := meta.Increment(ctx, "counter", 2)
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		val, err := meta.Increment(ctx, "counter", -1)
+		require.NoError(t, err)
+		require.Equal(t, int64(199), val)
+	})
+}
+
+func Test_WithNamespace(t *testing.T) {
+	ctx := context.Background()
+	meta, err := NewMetaDBMemory()
+	require.NoError(t, err)
+	erc20 := WithNamespace(meta, "erc20")
+	scanner := WithNamespace(WithNamespace(meta, "scanner"), "ethereum")
+
+	require.NoError(t, erc20.Set(ctx, "cursor", 10))
+	require.NoError(t, scanner.Set(ctx, "cursor", 20))
+
+	val, err := meta.GetInt64(ctx, "erc20::cursor")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), val)
+	val, err = scanner.GetInt64(ctx, "cursor")
+	require.NoError(t, err)
+	require.Equal(t, int64(20), val)
+
+	vals, err := scanner.ListPrefix(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"cursor": "20"}, vals)
+
+	require.NoError(t, scanner.SetMany(ctx, map[string]any{"head": 30}))
+	vals, err = scanner.GetMany(ctx, []string{"cursor", "head"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"cursor": "20", "head": "30"}, vals)
+
+	n, err := erc20.Increment(ctx, "cursor", 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(15), n)
+}
+
+func Test_MetaDBMemory_TTL(t *testing.T) {
+	ctx := context.Background()
+	meta, err := NewMetaDBMemory()
+	require.NoError(t, err)
+	now := time.Now()
+	meta.(*metaDBMemory).now = func() time.Time { return now }
+
+	require.NoError(t, meta.SetWithTTL(ctx, "ttl::a", "a", time.Minute))
+	require.NoError(t, meta.SetWithTTL(ctx, "ttl::counter", 1, time.Minute))
+	require.NoError(t, meta.Set(ctx, "ttl::forever", "b"))
+
+	n, err := meta.Increment(ctx, "ttl::counter", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	now = now.Add(2 * time.Minute)
+	_, err = meta.GetString(ctx, "ttl::a")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	vals, err := meta.ListPrefix(ctx, "ttl::")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"ttl::forever": "b"}, vals)
+
+	// Expired keys are treated as missing
+	ok, err := meta.CompareAndSet(ctx, "ttl::a", nil, "c")
+	require.NoError(t, err)
+	require.True(t, ok)
+	n, err = meta.Increment(ctx, "ttl::counter", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	// Set clears the expiry
+	require.NoError(t, meta.SetWithTTL(ctx, "ttl::b", "b", time.Minute))
+	require.NoError(t, meta.Set(ctx, "ttl::b", "b"))
+	now = now.Add(time.Hour)
+	val, err := meta.GetString(ctx, "ttl::b")
+	require.NoError(t, err)
+	require.Equal(t, "b", val)
+
+	require.NoError(t, meta.SetWithTTL(ctx, "ttl::c", "c", time.Minute))
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, meta.PurgeExpired(ctx))
+	_, ok = meta.(*metaDBMemory).data["ttl::c"]
+	require.False(t, ok)
+}
+
+func Test_MetaDBMemory_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	meta, err := NewMetaDBMemory()
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := "erc20::decimals::" + strconv.Itoa(i%5)
+			if _, err := meta.GetInt64(ctx, key); err != nil {
+				require.NoError(t, meta.SetWithTTL(ctx, key, 18, time.Hour))
+			}
+			_, err := meta.ListPrefix(ctx, "erc20::")
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	vals, err := meta.ListPrefix(ctx, "erc20::decimals::")
+	require.NoError(t, err)
+	require.Len(t, vals, 5)
+}
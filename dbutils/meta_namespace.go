@@ -0,0 +1,99 @@
+package dbutils
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type namespacedMetaDB struct {
+	meta   MetaDB
+	prefix string
+}
+
+// WithNamespace returns a MetaDB which stores all its keys under namespace, so that different
+// components sharing the meta table cannot clash. Namespaces can be nested.
+func WithNamespace(meta MetaDB, namespace string) MetaDB {
+	return &namespacedMetaDB{meta: meta, prefix: namespace + NamespaceSeparator}
+}
+
+func (nm *namespacedMetaDB) Set(ctx context.Context, key string, val any) error {
+	return nm.meta.Set(ctx, nm.prefix+key, val)
+}
+
+func (nm *namespacedMetaDB) SetWithTTL(ctx context.Context, key string, val any, ttl time.Duration) error {
+	return nm.meta.SetWithTTL(ctx, nm.prefix+key, val, ttl)
+}
+
+// PurgeExpired purges the expired keys of all namespaces
+func (nm *namespacedMetaDB) PurgeExpired(ctx context.Context) error {
+	return nm.meta.PurgeExpired(ctx)
+}
+
+func (nm *namespacedMetaDB) GetString(ctx context.Context, key string) (string, error) {
+	return nm.meta.GetString(ctx, nm.prefix+key)
+}
+
+func (nm *namespacedMetaDB) GetInt64(ctx context.Context, key string) (int64, error) {
+	return nm.meta.GetInt64(ctx, nm.prefix+key)
+}
+
+func (nm *namespacedMetaDB) GetUint64(ctx context.Context, key string) (uint64, error) {
+	return nm.meta.GetUint64(ctx, nm.prefix+key)
+}
+
+func (nm *namespacedMetaDB) Delete(ctx context.Context, key string) error {
+	return nm.meta.Delete(ctx, nm.prefix+key)
+}
+
+// ListPrefix returns the keys without the namespace
+func (nm *namespacedMetaDB) ListPrefix(ctx context.Context, prefix string) (map[string]string, error) {
+	vals, err := nm.meta.ListPrefix(ctx, nm.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(vals))
+	for key, val := range vals {
+		out[strings.TrimPrefix(key, nm.prefix)] = val
+	}
+	return out, nil
+}
+
+// GetMany returns the keys without the namespace
+func (nm *namespacedMetaDB) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = nm.prefix + key
+	}
+	vals, err := nm.meta.GetMany(ctx, prefixed)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(vals))
+	for key, val := range vals {
+		out[strings.TrimPrefix(key, nm.prefix)] = val
+	}
+	return out, nil
+}
+
+func (nm *namespacedMetaDB) SetMany(ctx context.Context, vals map[string]any) error {
+	prefixed := make(map[string]any, len(vals))
+	for key, val := range vals {
+		prefixed[nm.prefix+key] = val
+	}
+	return nm.meta.SetMany(ctx, prefixed)
+}
+
+func (nm *namespacedMetaDB) CompareAndSet(ctx context.Context, key string, expected any, val any) (bool, error) {
+	return nm.meta.CompareAndSet(ctx, nm.prefix+key, expected, val)
+}
+
+func (nm *namespacedMetaDB) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	return nm.meta.Increment(ctx, nm.prefix+key, delta)
+}
+
+func (nm *namespacedMetaDB) WithTx(tx *sqlx.Tx) MetaDB {
+	return &namespacedMetaDB{meta: nm.meta.WithTx(tx), prefix: nm.prefix}
+}
@@ -0,0 +1,221 @@
+package dbutils
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+// testPostgres connects to the database in TEST_DATABASE_URL, skipping the test if it is not set. The connections
+// use a new schema, which is dropped when the test ends, so that tests do not share the meta table.
+func testPostgres(t *testing.T) *sqlx.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	admin, err := sqlx.Connect("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Close() })
+	schema := "dbutils_test_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	conf, err := pgx.ParseConfig(dsn)
+	require.NoError(t, err)
+	conf.RuntimeParams["search_path"] = schema
+	sdb := sqlx.NewDb(stdlib.OpenDB(*conf), "pgx")
+	t.Cleanup(func() { sdb.Close() })
+	return sdb
+}
+
+// testMetaDB creates the meta table in a new schema, see testPostgres
+func testMetaDB(t *testing.T) (*sqlx.DB, MetaDB) {
+	sdb := testPostgres(t)
+	_, err := sdb.Exec(MetaSchema)
+	require.NoError(t, err)
+	meta, err := NewMetaDB(sdb)
+	require.NoError(t, err)
+	return sdb, meta
+}
+
+func Test_MetaDB_Postgres(t *testing.T) {
+	ctx := context.Background()
+	_, meta := testMetaDB(t)
+
+	t.Run("types", func(t *testing.T) {
+		require.NoError(t, meta.Set(ctx, "uint", uint64(1<<63)))
+		u, err := meta.GetUint64(ctx, "uint")
+		require.NoError(t, err)
+		require.Equal(t, uint64(1<<63), u)
+
+		bigVal, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+		require.NoError(t, meta.Set(ctx, "big", bigVal))
+		gotBig, err := GetBigInt(ctx, meta, "big")
+		require.NoError(t, err)
+		require.Equal(t, 0, bigVal.Cmp(gotBig))
+
+		require.NoError(t, SetJSON(ctx, meta, "json", map[string]int{"block": 10}))
+		gotJSON, err := GetJSON[map[string]int](ctx, meta, "json")
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"block": 10}, gotJSON)
+
+		_, err = meta.GetString(ctx, "missing")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("delete and list", func(t *testing.T) {
+		require.NoError(t, meta.Set(ctx, "list_a::a", 1))
+		require.NoError(t, meta.Set(ctx, "list_a::b", 2))
+		// _ is escaped, rather than matching any character
+		require.NoError(t, meta.Set(ctx, "listXa::c", 3))
+		vals, err := meta.ListPrefix(ctx, "list_a::")
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"list_a::a": "1", "list_a::b": "2"}, vals)
+
+		require.NoError(t, meta.Delete(ctx, "list_a::a"))
+		require.NoError(t, meta.Delete(ctx, "list_a::missing"))
+		_, err = meta.GetString(ctx, "list_a::a")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("compare and set", func(t *testing.T) {
+		ok, err := meta.CompareAndSet(ctx, "cas", nil, int64(1))
+		require.NoError(t, err)
+		require.True(t, ok)
+		ok, err = meta.CompareAndSet(ctx, "cas", nil, int64(2))
+		require.NoError(t, err)
+		require.False(t, ok)
+		ok, err = meta.CompareAndSet(ctx, "cas", int64(2), int64(3))
+		require.NoError(t, err)
+		require.False(t, ok)
+		ok, err = meta.CompareAndSet(ctx, "cas", int64(1), int64(3))
+		require.NoError(t, err)
+		require.True(t, ok)
+		val, err := meta.GetInt64(ctx, "cas")
+		require.NoError(t, err)
+		require.Equal(t, int64(3), val)
+	})
+
+	t.Run("increment", func(t *testing.T) {
+		wg := sync.WaitGroup{}
+		errs := make(chan error, 20)
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := meta.Increment(ctx, "counter", 2)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+		val, err := meta.Increment(ctx, "counter", -1)
+		require.NoError(t, err)
+		require.Equal(t, int64(39), val)
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		scanner := WithNamespace(WithNamespace(meta, "scanner"), "ethereum")
+		require.NoError(t, scanner.Set(ctx, "cursor", 20))
+		val, err := meta.GetInt64(ctx, "scanner::ethereum::cursor")
+		require.NoError(t, err)
+		require.Equal(t, int64(20), val)
+		vals, err := scanner.ListPrefix(ctx, "")
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"cursor": "20"}, vals)
+	})
+}
+
+func Test_MetaDB_Postgres_TTL(t *testing.T) {
+	ctx := context.Background()
+	sdb, meta := testMetaDB(t)
+
+	// A negative ttl has already expired
+	require.NoError(t, meta.SetWithTTL(ctx, "ttl::expired", "a", -time.Minute))
+	require.NoError(t, meta.SetWithTTL(ctx, "ttl::live", "b", time.Hour))
+	require.NoError(t, meta.SetWithTTL(ctx, "ttl::counter", 5, -time.Minute))
+
+	_, err := meta.GetString(ctx, "ttl::expired")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	vals, err := meta.ListPrefix(ctx, "ttl::")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"ttl::live": "b"}, vals)
+
+	// Expired keys are treated as missing
+	ok, err := meta.CompareAndSet(ctx, "ttl::expired", "a", "c")
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = meta.CompareAndSet(ctx, "ttl::expired", nil, "c")
+	require.NoError(t, err)
+	require.True(t, ok)
+	n, err := meta.Increment(ctx, "ttl::counter", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	// Set clears the expiry
+	require.NoError(t, meta.SetWithTTL(ctx, "ttl::set", "d", -time.Minute))
+	require.NoError(t, meta.Set(ctx, "ttl::set", "d"))
+	val, err := meta.GetString(ctx, "ttl::set")
+	require.NoError(t, err)
+	require.Equal(t, "d", val)
+
+	require.NoError(t, meta.SetWithTTL(ctx, "ttl::purged", "e", -time.Minute))
+	require.NoError(t, meta.PurgeExpired(ctx))
+	var rows int
+	require.NoError(t, sdb.Get(&rows, "SELECT count(*) FROM meta WHERE key LIKE 'ttl::%'"))
+	require.Equal(t, 4, rows)
+}
+
+func Test_NewMetaDB_SchemaOutdated(t *testing.T) {
+	sdb := testPostgres(t)
+	// The meta table from before keys could expire
+	_, err := sdb.Exec("CREATE TABLE meta (key TEXT PRIMARY KEY, value TEXT NOT NULL)")
+	require.NoError(t, err)
+	_, err = NewMetaDB(sdb)
+	require.ErrorIs(t, err, ErrMetaSchemaOutdated)
+
+	_, err = sdb.Exec(MetaSchema)
+	require.NoError(t, err)
+	_, err = NewMetaDB(sdb)
+	require.NoError(t, err)
+}
+
+func Test_MetaDB_Postgres_Many(t *testing.T) {
+	ctx := context.Background()
+	_, meta := testMetaDB(t)
+
+	require.NoError(t, meta.SetMany(ctx, map[string]any{"many::a": 1, "many::b": "two"}))
+	require.NoError(t, meta.SetWithTTL(ctx, "many::expired", 3, -time.Minute))
+	vals, err := meta.GetMany(ctx, []string{"many::a", "many::b", "many::expired", "many::missing"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"many::a": "1", "many::b": "two"}, vals)
+
+	// Either all or none of the values are stored
+	require.ErrorIs(t, meta.SetMany(ctx, map[string]any{"many::c": 3, "many::d": struct{}{}}), ErrUnsupportedType)
+	_, err = meta.GetString(ctx, "many::c")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// SetMany clears the expiry
+	require.NoError(t, meta.SetMany(ctx, map[string]any{"many::expired": 4}))
+	val, err := meta.GetInt64(ctx, "many::expired")
+	require.NoError(t, err)
+	require.Equal(t, int64(4), val)
+
+	vals, err = WithNamespace(meta, "many").GetMany(ctx, []string{"a", "b"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "1", "b": "two"}, vals)
+}
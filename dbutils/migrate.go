@@ -0,0 +1,271 @@
+package dbutils
+
+import (
+	"cmp"
+	"context"
+	"embed"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// BaseMigrations are the migrations for the tables used by this library, starting with the meta table as version 1.
+// Services should pass it to NewMigrator along with their own migrations, numbered from 2.
+//
+//go:embed migrations/*.sql
+var BaseMigrations embed.FS
+
+var (
+	ErrInvalidMigration = errors.New("invalid migration")
+	ErrMissingDown      = errors.New("migration has no down migration")
+)
+
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_\-]+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change, loaded from a pair of files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, the down migration is optional.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// LoadMigrations loads the migrations from the .sql files in the root and the migrations directory of each fs,
+// sorted by version. It returns an error if a version is defined twice or has no up migration.
+func LoadMigrations(sources ...fs.FS) ([]Migration, error) {
+	byVersion := map[int64]*Migration{}
+	for _, fsys := range sources {
+		for _, dir := range []string{".", "migrations"} {
+			entries, err := fs.ReadDir(fsys, dir)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if entry.IsDir() {
+					continue
+				}
+				matches := migrationFileRegex.FindStringSubmatch(entry.Name())
+				if matches == nil {
+					continue
+				}
+				version, err := strconv.ParseInt(matches[1], 10, 64)
+				if err != nil {
+					return nil, errors.Wrapf(ErrInvalidMigration, "invalid version in %s", entry.Name())
+				}
+				content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+				if err != nil {
+					return nil, err
+				}
+
+				migration, ok := byVersion[version]
+				if !ok {
+					migration = &Migration{Version: version, Name: matches[2]}
+					byVersion[version] = migration
+				} else if migration.Name != matches[2] {
+					return nil, errors.Wrapf(ErrInvalidMigration, "version %d is used by both %s and %s", version, migration.Name, matches[2])
+				}
+				target := &migration.Up
+				if matches[3] == "down" {
+					target = &migration.Down
+				}
+				if *target != "" {
+					return nil, errors.Wrapf(ErrInvalidMigration, "%s is defined more than once", entry.Name())
+				}
+				*target = string(content)
+			}
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, errors.Wrapf(ErrInvalidMigration, "version %d (%s) has no up migration", migration.Version, migration.Name)
+		}
+		out = append(out, *migration)
+	}
+	slices.SortFunc(out, func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+	return out, nil
+}
+
+// MigratorConfig configures a Migrator
+type MigratorConfig struct {
+	// Table records the applied migration versions
+	Table string `env:"MIGRATIONS_TABLE" env-default:"schema_migrations"`
+	// LockID is the key of the Postgres advisory lock held while migrating, so that only one instance migrates at a time
+	LockID int64 `env:"MIGRATIONS_LOCK_ID" env-default:"7236773925390167666"`
+	// DryRun logs the migrations which would be applied without running them
+	DryRun bool `env:"MIGRATIONS_DRY_RUN" env-default:"false"`
+}
+
+// Migrator applies and rolls back migrations
+type Migrator interface {
+	// Up applies all pending migrations in order, returning the ones which were applied
+	Up(ctx context.Context) ([]Migration, error)
+	// Down rolls back the last steps applied migrations, returning the ones which were rolled back
+	Down(ctx context.Context, steps int) ([]Migration, error)
+	// Pending returns the migrations which have not been applied
+	Pending(ctx context.Context) ([]Migration, error)
+}
+
+type migrator struct {
+	log        logrus.Ext1FieldLogger
+	sdb        *sqlx.DB
+	conf       MigratorConfig
+	migrations []Migration
+}
+
+var migrationTableRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewMigrator creates a Migrator for the migrations in sources, usually BaseMigrations followed by an embed.FS
+// of the service's own migrations.
+func NewMigrator(log logrus.Ext1FieldLogger, sdb *sqlx.DB, conf MigratorConfig, sources ...fs.FS) (Migrator, error) {
+	if !migrationTableRegex.MatchString(conf.Table) {
+		return nil, errors.Newf("invalid migrations table name %q", conf.Table)
+	}
+	migrations, err := LoadMigrations(sources...)
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{log: log, sdb: sdb, conf: conf, migrations: migrations}, nil
+}
+
+// withLock runs fn on a single connection which holds the advisory lock, after making sure the migrations table exists
+func (m *migrator) withLock(ctx context.Context, fn func(conn *sqlx.Conn, applied map[int64]bool) error) error {
+	conn, err := m.sdb.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", m.conf.LockID); err != nil {
+		return errors.Wrap(err, "failed to take the migrations lock")
+	}
+	defer func() {
+		// The lock is also released when the connection is closed
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", m.conf.LockID); err != nil {
+			m.log.WithError(err).Warn("failed to release the migrations lock")
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+m.conf.Table+` (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the migrations table")
+	}
+
+	var versions []int64
+	if err := conn.SelectContext(ctx, &versions, "SELECT version FROM "+m.conf.Table); err != nil {
+		return errors.Wrap(err, "failed to get the applied migrations")
+	}
+	applied := make(map[int64]bool, len(versions))
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return fn(conn, applied)
+}
+
+func (m *migrator) Pending(ctx context.Context) ([]Migration, error) {
+	var out []Migration
+	err := m.withLock(ctx, func(_ *sqlx.Conn, applied map[int64]bool) error {
+		out = pendingMigrations(m.migrations, applied)
+		return nil
+	})
+	return out, err
+}
+
+func pendingMigrations(migrations []Migration, applied map[int64]bool) []Migration {
+	var out []Migration
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			out = append(out, migration)
+		}
+	}
+	return out
+}
+
+func (m *migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn, applied map[int64]bool) error {
+		for _, migration := range pendingMigrations(m.migrations, applied) {
+			log := m.log.WithField("version", migration.Version).WithField("name", migration.Name)
+			if m.conf.DryRun {
+				log.WithField("sql", migration.Up).Info("dry run: would apply migration")
+				done = append(done, migration)
+				continue
+			}
+			err := m.runInTx(ctx, conn, migration.Up, "INSERT INTO "+m.conf.Table+" (version, name) VALUES ($1, $2)",
+				migration.Version, migration.Name)
+			if err != nil {
+				return errors.Wrapf(err, "failed to apply migration %d (%s)", migration.Version, migration.Name)
+			}
+			log.Info("applied migration")
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn, applied map[int64]bool) error {
+		var toRollBack []Migration
+		for i := len(m.migrations) - 1; i >= 0 && len(toRollBack) < steps; i-- {
+			if applied[m.migrations[i].Version] {
+				toRollBack = append(toRollBack, m.migrations[i])
+			}
+		}
+		// Check every migration can be rolled back before changing anything
+		for _, migration := range toRollBack {
+			if migration.Down == "" {
+				return errors.Wrapf(ErrMissingDown, "version %d (%s)", migration.Version, migration.Name)
+			}
+		}
+
+		for _, migration := range toRollBack {
+			log := m.log.WithField("version", migration.Version).WithField("name", migration.Name)
+			if m.conf.DryRun {
+				log.WithField("sql", migration.Down).Info("dry run: would roll back migration")
+				done = append(done, migration)
+				continue
+			}
+			err := m.runInTx(ctx, conn, migration.Down, "DELETE FROM "+m.conf.Table+" WHERE version = $1", migration.Version)
+			if err != nil {
+				return errors.Wrapf(err, "failed to roll back migration %d (%s)", migration.Version, migration.Name)
+			}
+			log.Info("rolled back migration")
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// runInTx runs the migration sql and then the record query in one transaction
+func (m *migrator) runInTx(ctx context.Context, conn *sqlx.Conn, migrationSQL string, recordQuery string, args ...any) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, migrationSQL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, recordQuery, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
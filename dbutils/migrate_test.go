@@ -0,0 +1,144 @@
+package dbutils
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadMigrations(t *testing.T) {
+	t.Run("base", func(t *testing.T) {
+		migrations, err := LoadMigrations(BaseMigrations)
+		require.NoError(t, err)
+		require.Len(t, migrations, 1)
+		require.Equal(t, int64(1), migrations[0].Version)
+		require.Equal(t, "meta", migrations[0].Name)
+		require.Equal(t, MetaSchema, migrations[0].Up)
+		require.NotEmpty(t, migrations[0].Down)
+	})
+
+	t.Run("merged and sorted", func(t *testing.T) {
+		service := fstest.MapFS{
+			"0003_balances.up.sql":           {Data: []byte("CREATE TABLE balances ()")},
+			"0002_users.up.sql":              {Data: []byte("CREATE TABLE users ()")},
+			"0002_users.down.sql":            {Data: []byte("DROP TABLE users")},
+			"README.md":                      {Data: []byte("not a migration")},
+			"migrations/0004_indexes.up.sql": {Data: []byte("CREATE INDEX")},
+		}
+		migrations, err := LoadMigrations(BaseMigrations, service)
+		require.NoError(t, err)
+		require.Len(t, migrations, 4)
+		for i, name := range []string{"meta", "users", "balances", "indexes"} {
+			require.Equal(t, int64(i+1), migrations[i].Version)
+			require.Equal(t, name, migrations[i].Name)
+		}
+		require.Equal(t, "DROP TABLE users", migrations[1].Down)
+		require.Empty(t, migrations[2].Down)
+	})
+
+	t.Run("duplicate version", func(t *testing.T) {
+		_, err := LoadMigrations(BaseMigrations, fstest.MapFS{"0001_users.up.sql": {Data: []byte("SELECT 1")}})
+		require.ErrorIs(t, err, ErrInvalidMigration)
+	})
+
+	t.Run("duplicate file", func(t *testing.T) {
+		_, err := LoadMigrations(
+			fstest.MapFS{"0002_users.up.sql": {Data: []byte("SELECT 1")}},
+			fstest.MapFS{"migrations/0002_users.up.sql": {Data: []byte("SELECT 2")}},
+		)
+		require.ErrorIs(t, err, ErrInvalidMigration)
+	})
+
+	t.Run("missing up", func(t *testing.T) {
+		_, err := LoadMigrations(fstest.MapFS{"0002_users.down.sql": {Data: []byte("DROP TABLE users")}})
+		require.ErrorIs(t, err, ErrInvalidMigration)
+	})
+}
+
+func Test_pendingMigrations(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+	pending := pendingMigrations(migrations, map[int64]bool{1: true, 3: true})
+	require.Equal(t, []Migration{{Version: 2}}, pending)
+	require.Empty(t, pendingMigrations(migrations, map[int64]bool{1: true, 2: true, 3: true}))
+}
+
+func Test_Migrator_Postgres(t *testing.T) {
+	sdb := testPostgres(t)
+	ctx := context.Background()
+	service := fstest.MapFS{
+		"0002_users.up.sql":      {Data: []byte("CREATE TABLE users (id BIGINT PRIMARY KEY)")},
+		"0002_users.down.sql":    {Data: []byte("DROP TABLE users")},
+		"0003_balances.up.sql":   {Data: []byte("CREATE TABLE balances (id BIGINT PRIMARY KEY)")},
+		"0003_balances.down.sql": {Data: []byte("DROP TABLE balances")},
+	}
+	// A lock id of its own, so that tests running against the same database do not wait for each other
+	conf := MigratorConfig{Table: "schema_migrations", LockID: time.Now().UnixNano()}
+
+	t.Run("waits for the lock", func(t *testing.T) {
+		conn, err := sdb.Connx(ctx)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", conf.LockID)
+		require.NoError(t, err)
+
+		m, err := NewMigrator(logrus.New(), sdb, conf, BaseMigrations, service)
+		require.NoError(t, err)
+		timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		_, err = m.Up(timeoutCtx)
+		require.Error(t, err)
+
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", conf.LockID)
+		require.NoError(t, err)
+	})
+
+	t.Run("concurrent up", func(t *testing.T) {
+		// Only one of the migrators applies each migration, the others find nothing pending once they get the lock
+		applied := make(chan []Migration, 5)
+		wg := sync.WaitGroup{}
+		for range 5 {
+			m, err := NewMigrator(logrus.New(), sdb, conf, BaseMigrations, service)
+			require.NoError(t, err)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				done, err := m.Up(ctx)
+				assert.NoError(t, err)
+				applied <- done
+			}()
+		}
+		wg.Wait()
+		close(applied)
+		var versions []int64
+		for done := range applied {
+			for _, migration := range done {
+				versions = append(versions, migration.Version)
+			}
+		}
+		slices.Sort(versions)
+		require.Equal(t, []int64{1, 2, 3}, versions)
+	})
+
+	t.Run("down", func(t *testing.T) {
+		m, err := NewMigrator(logrus.New(), sdb, conf, BaseMigrations, service)
+		require.NoError(t, err)
+		done, err := m.Down(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, done, 1)
+		require.Equal(t, int64(3), done[0].Version)
+
+		pending, err := m.Pending(ctx)
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		var exists bool
+		require.NoError(t, sdb.Get(&exists, "SELECT to_regclass('balances') IS NOT NULL"))
+		require.False(t, exists)
+	})
+}
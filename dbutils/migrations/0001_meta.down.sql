@@ -0,0 +1,2 @@
+-- The meta table is shared by every service which uses this library, and holds their cursors and caches, so rolling
+-- back this migration does not drop it. Drop it by hand if it is really no longer used.
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS meta (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+ALTER TABLE meta ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
@@ -0,0 +1,185 @@
+package dbutils
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ErrInvalidCursor is returned when a keyset pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page is the standard JSON envelope for a page of results
+type Page[T any] struct {
+	Items []T `json:"items"`
+	// Page is the zero-based page number, it is only set for offset pagination
+	Page     int64 `json:"page"`
+	PageSize int64 `json:"pageSize"`
+	// Total is the number of rows across all pages, it is nil if it was not counted
+	Total   *int64 `json:"total,omitempty"`
+	HasMore bool   `json:"hasMore"`
+	// NextCursor is passed back to get the next page with keyset pagination, it is empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// nextParam returns the placeholder for the parameter after args
+func nextParam(args []any, offset int) string {
+	return "$" + strconv.Itoa(len(args)+offset)
+}
+
+// SelectPage gets page page of pageSize rows of query, like those from server.GetPagenation, along with the total
+// number of rows. query must order its rows, but not have a LIMIT or OFFSET, and uses $n placeholders for args.
+func SelectPage[T any](ctx context.Context, q Querier, page, pageSize int64, query string, args ...any) (Page[T], error) {
+	if page < 0 || pageSize <= 0 {
+		return Page[T]{}, errors.Newf("invalid page %d of size %d", page, pageSize)
+	}
+	out := Page[T]{Items: []T{}, Page: page, PageSize: pageSize}
+
+	var total int64
+	if err := q.GetContext(ctx, &total, "SELECT count(*) FROM ("+query+") AS page_query", args...); err != nil {
+		return Page[T]{}, errors.Wrap(err, "failed to count rows")
+	}
+	out.Total = &total
+
+	pageQuery := query + " LIMIT " + nextParam(args, 1) + " OFFSET " + nextParam(args, 2)
+	if err := q.SelectContext(ctx, &out.Items, pageQuery, append(slices.Clone(args), pageSize, page*pageSize)...); err != nil {
+		return Page[T]{}, err
+	}
+	out.HasMore = (page+1)*pageSize < total
+	return out, nil
+}
+
+// KeysetQuery is a query paginated by the values of its order columns, which is faster than an offset for large
+// tables, as the rows before the page do not have to be read
+type KeysetQuery struct {
+	// Query selects the rows, it may have a WHERE clause but not an ORDER BY or LIMIT, and uses $n placeholders for Args
+	Query string
+	Args  []any
+	// Columns are the columns the rows are ordered by, the last of which must be unique, such as id, or
+	// block_number, log_index and tx_hash
+	Columns []string
+	// Desc orders the rows in descending order
+	Desc bool
+	// CountTotal also counts the total number of rows, which is slow for large tables
+	CountTotal bool
+}
+
+// SelectKeysetPage gets pageSize rows of kq after cursor, which is empty for the first page. keyFn returns the values
+// of kq.Columns for a row, which are encoded into the cursor for the next page.
+func SelectKeysetPage[T any](ctx context.Context, q Querier, kq KeysetQuery, cursor string, pageSize int64, keyFn func(T) []any) (Page[T], error) {
+	if pageSize <= 0 {
+		return Page[T]{}, errors.Newf("invalid page size %d", pageSize)
+	}
+	if len(kq.Columns) == 0 {
+		return Page[T]{}, errors.New("keyset query must have columns")
+	}
+	// The query is wrapped in a subquery, so the columns cannot be qualified by table
+	for _, col := range kq.Columns {
+		if !identifierRegex.MatchString(col) || strings.Contains(col, ".") {
+			return Page[T]{}, errors.Newf("invalid column name %q", col)
+		}
+	}
+	out := Page[T]{Items: []T{}, PageSize: pageSize}
+
+	if kq.CountTotal {
+		var total int64
+		if err := q.GetContext(ctx, &total, "SELECT count(*) FROM ("+kq.Query+") AS page_query", kq.Args...); err != nil {
+			return Page[T]{}, errors.Wrap(err, "failed to count rows")
+		}
+		out.Total = &total
+	}
+
+	query, args, err := kq.pageQuery(cursor, pageSize)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	if err := q.SelectContext(ctx, &out.Items, query, args...); err != nil {
+		return Page[T]{}, err
+	}
+
+	// One extra row is selected to tell whether there is another page
+	if int64(len(out.Items)) > pageSize {
+		out.Items = out.Items[:pageSize]
+		out.HasMore = true
+		out.NextCursor, err = EncodeCursor(keyFn(out.Items[pageSize-1])...)
+		if err != nil {
+			return Page[T]{}, err
+		}
+	}
+	return out, nil
+}
+
+// pageQuery returns the query for the page after cursor, with one extra row
+func (kq KeysetQuery) pageQuery(cursor string, pageSize int64) (string, []any, error) {
+	args := slices.Clone(kq.Args)
+	query := "SELECT * FROM (" + kq.Query + ") AS page_query"
+	if cursor != "" {
+		keys, err := DecodeCursor(cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(keys) != len(kq.Columns) {
+			return "", nil, errors.Wrapf(ErrInvalidCursor, "expected %d values, got %d", len(kq.Columns), len(keys))
+		}
+		params := make([]string, len(keys))
+		for i := range keys {
+			params[i] = nextParam(args, 1)
+			args = append(args, keys[i])
+		}
+		op := " > "
+		if kq.Desc {
+			op = " < "
+		}
+		query += " WHERE (" + strings.Join(kq.Columns, ", ") + ")" + op + "(" + strings.Join(params, ", ") + ")"
+	}
+
+	order := make([]string, len(kq.Columns))
+	for i, col := range kq.Columns {
+		order[i] = col
+		if kq.Desc {
+			order[i] += " DESC"
+		}
+	}
+	query += " ORDER BY " + strings.Join(order, ", ") + " LIMIT " + nextParam(args, 1)
+	return query, append(args, pageSize+1), nil
+}
+
+// EncodeCursor encodes the key values of the last row of a page into an opaque cursor
+func EncodeCursor(keys ...any) (string, error) {
+	raw, err := json.Marshal(keys)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode cursor")
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// DecodeCursor decodes a cursor from EncodeCursor. Numbers are returned as strings, so that large integers keep
+// their precision, and are converted by Postgres to the column types.
+func DecodeCursor(cursor string) ([]any, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCursor, err.Error())
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var keys []any
+	if err := dec.Decode(&keys); err != nil {
+		return nil, errors.Wrap(ErrInvalidCursor, err.Error())
+	}
+	for i, key := range keys {
+		switch k := key.(type) {
+		case json.Number:
+			keys[i] = k.String()
+		case string, bool:
+		default:
+			return nil, errors.Wrapf(ErrInvalidCursor, "unsupported value %v", key)
+		}
+	}
+	return keys, nil
+}
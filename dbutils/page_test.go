@@ -0,0 +1,63 @@
+package dbutils
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Cursor(t *testing.T) {
+	large, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	cursor, err := EncodeCursor(uint64(18446744073709551615), "0xabc", large, true)
+	require.NoError(t, err)
+
+	keys, err := DecodeCursor(cursor)
+	require.NoError(t, err)
+	require.Equal(t, []any{"18446744073709551615", "0xabc", "123456789012345678901234567890", true}, keys)
+
+	_, err = DecodeCursor("not a cursor!")
+	require.ErrorIs(t, err, ErrInvalidCursor)
+	nested, err := EncodeCursor(map[string]int{"a": 1})
+	require.NoError(t, err)
+	_, err = DecodeCursor(nested)
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func Test_KeysetQuery_pageQuery(t *testing.T) {
+	kq := KeysetQuery{
+		Query:   "SELECT block_number, log_index FROM transfers WHERE token = $1",
+		Args:    []any{"0xabc"},
+		Columns: []string{"block_number", "log_index"},
+	}
+
+	query, args, err := kq.pageQuery("", 10)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT * FROM (SELECT block_number, log_index FROM transfers WHERE token = $1) AS page_query "+
+		"ORDER BY block_number, log_index LIMIT $2", query)
+	require.Equal(t, []any{"0xabc", int64(11)}, args)
+
+	cursor, err := EncodeCursor(100, 2)
+	require.NoError(t, err)
+	kq.Desc = true
+	query, args, err = kq.pageQuery(cursor, 10)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT * FROM (SELECT block_number, log_index FROM transfers WHERE token = $1) AS page_query "+
+		"WHERE (block_number, log_index) < ($2, $3) ORDER BY block_number DESC, log_index DESC LIMIT $4", query)
+	require.Equal(t, []any{"0xabc", "100", "2", int64(11)}, args)
+	require.Len(t, kq.Args, 1)
+
+	short, err := EncodeCursor(100)
+	require.NoError(t, err)
+	_, _, err = kq.pageQuery(short, 10)
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func Test_SelectKeysetPage_InvalidColumns(t *testing.T) {
+	keyFn := func(int) []any { return nil }
+	for _, col := range []string{"t.id", "id; DROP TABLE meta", ""} {
+		_, err := SelectKeysetPage(context.Background(), nil, KeysetQuery{Query: "SELECT 1", Columns: []string{col}}, "", 10, keyFn)
+		require.ErrorContains(t, err, "invalid column name")
+	}
+}
@@ -0,0 +1,177 @@
+package dbutils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/usecorn/common-lib/app"
+)
+
+// Postgres error codes for transactions which failed because of concurrent transactions, and can be retried
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// Querier runs queries, it is implemented by *sqlx.DB, *sqlx.Tx and Tx, so that repositories can run their
+// queries either inside or outside a transaction
+type Querier interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+// Tx is the transaction passed to the function run by WithTx
+type Tx interface {
+	Querier
+	// SQLTx returns the underlying transaction, for APIs which take a *sqlx.Tx
+	SQLTx() *sqlx.Tx
+	// Meta returns meta bound to the transaction
+	Meta(meta MetaDB) MetaDB
+}
+
+// TxOptions configures the transactions run by WithTx
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxAttempts is the maximum number of times the transaction is run when it fails with a serialization
+	// failure or deadlock, 0 defaults to 5
+	MaxAttempts int
+	// InitialBackoff is the backoff after the first failed attempt, doubling up to MaxBackoff. It defaults to 10ms.
+	InitialBackoff time.Duration
+	// MaxBackoff defaults to 1s
+	MaxBackoff time.Duration
+}
+
+func (opts TxOptions) withDefaults() TxOptions {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = 10 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Second
+	}
+	return opts
+}
+
+// backoff returns how long to wait after the given number of failed attempts
+func (opts TxOptions) backoff(attempts int) time.Duration {
+	backoff := opts.InitialBackoff
+	for i := 1; i < attempts && backoff < opts.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, opts.MaxBackoff)
+}
+
+type sqlTx struct {
+	*sqlx.Tx
+	db *sqlx.DB
+	// savepoints is the number of savepoints which have been created, used to name the next one
+	savepoints int
+}
+
+func (tx *sqlTx) SQLTx() *sqlx.Tx {
+	return tx.Tx
+}
+
+func (tx *sqlTx) Meta(meta MetaDB) MetaDB {
+	return meta.WithTx(tx.Tx)
+}
+
+type txContextKey struct{}
+
+// TxFromContext returns the transaction of the WithTx call which ctx was passed from, if there is one
+func TxFromContext(ctx context.Context) (Tx, bool) {
+	tx, ok := ctx.Value(txContextKey{}).(*sqlTx)
+	return tx, ok
+}
+
+// IsRetryableTxError returns true if err is a Postgres serialization failure or deadlock, after which the
+// transaction can be retried. It relies on the driver's error having a SQLState method, like pgx's *pgconn.PgError.
+func IsRetryableTxError(err error) bool {
+	var stateErr interface{ SQLState() string }
+	if !errors.As(err, &stateErr) {
+		return false
+	}
+	switch stateErr.SQLState() {
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+		return true
+	default:
+		return false
+	}
+}
+
+// WithTx runs fn in a transaction on db, which is committed if fn returns nil and rolled back otherwise.
+// The transaction is retried with backoff when it fails with a serialization failure or deadlock, so fn must be safe
+// to run more than once.
+//
+// The ctx passed to fn carries the transaction, so when WithTx is called again with it for the same db, fn runs in a
+// savepoint of the outer transaction instead. If the nested fn returns an error, only its changes are rolled back,
+// and the error is returned to the outer fn. Nested calls do not retry, as the whole transaction has to be run again.
+func WithTx(ctx context.Context, db *sqlx.DB, opts TxOptions, fn func(ctx context.Context, tx Tx) error) error {
+	if outer, ok := ctx.Value(txContextKey{}).(*sqlTx); ok && outer.db == db {
+		return withSavepoint(ctx, outer, fn)
+	}
+
+	opts = opts.withDefaults()
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, db, opts, fn)
+		if err == nil || !IsRetryableTxError(err) || attempt >= opts.MaxAttempts {
+			return err
+		}
+		if app.SleepContext(ctx, opts.backoff(attempt)) != nil {
+			return err
+		}
+	}
+}
+
+func runTx(ctx context.Context, db *sqlx.DB, opts TxOptions, fn func(ctx context.Context, tx Tx) error) (err error) {
+	sqlxTx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	tx := &sqlTx{Tx: sqlxTx, db: db}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = sqlxTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txContextKey{}, tx), tx); err != nil {
+		if rbErr := sqlxTx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return errors.CombineErrors(err, errors.Wrap(rbErr, "failed to roll back transaction"))
+		}
+		return err
+	}
+	if err := sqlxTx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+func withSavepoint(ctx context.Context, tx *sqlTx, fn func(ctx context.Context, tx Tx) error) error {
+	tx.savepoints++
+	name := fmt.Sprintf("dbutils_savepoint_%d", tx.savepoints)
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return errors.Wrap(err, "failed to create savepoint")
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return errors.CombineErrors(err, errors.Wrap(rbErr, "failed to roll back to savepoint"))
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return errors.Wrap(err, "failed to release savepoint")
+	}
+	return nil
+}
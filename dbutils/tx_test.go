@@ -0,0 +1,118 @@
+package dbutils
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "pg error " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func Test_IsRetryableTxError(t *testing.T) {
+	require.True(t, IsRetryableTxError(sqlStateError("40001")))
+	require.True(t, IsRetryableTxError(errors.Wrap(sqlStateError("40P01"), "failed to commit transaction")))
+	require.False(t, IsRetryableTxError(sqlStateError("23505")))
+	require.False(t, IsRetryableTxError(errors.New("40001")))
+	require.False(t, IsRetryableTxError(nil))
+}
+
+func Test_TxOptions(t *testing.T) {
+	opts := TxOptions{}.withDefaults()
+	require.Equal(t, 5, opts.MaxAttempts)
+	require.Equal(t, 10*time.Millisecond, opts.backoff(1))
+	require.Equal(t, 20*time.Millisecond, opts.backoff(2))
+	require.Equal(t, 80*time.Millisecond, opts.backoff(4))
+	require.Equal(t, time.Second, opts.backoff(20))
+
+	_, ok := TxFromContext(context.Background())
+	require.False(t, ok)
+}
+
+func Test_WithTx_Postgres(t *testing.T) {
+	sdb, meta := testMetaDB(t)
+	ctx := context.Background()
+	_, err := sdb.Exec("CREATE TABLE tx_items (id BIGINT PRIMARY KEY)")
+	require.NoError(t, err)
+	insert := func(ctx context.Context, tx Tx, id int) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO tx_items (id) VALUES ($1)", id)
+		return err
+	}
+	items := func() []int {
+		var ids []int
+		require.NoError(t, sdb.Select(&ids, "SELECT id FROM tx_items ORDER BY id"))
+		return ids
+	}
+	errNested := errors.New("nested")
+
+	t.Run("savepoints", func(t *testing.T) {
+		err := WithTx(ctx, sdb, TxOptions{}, func(ctx context.Context, tx Tx) error {
+			require.NoError(t, insert(ctx, tx, 1))
+			inner, ok := TxFromContext(ctx)
+			require.True(t, ok)
+			require.Same(t, tx, inner)
+
+			// Only the changes of the failed nested call are rolled back
+			err := WithTx(ctx, sdb, TxOptions{}, func(ctx context.Context, tx Tx) error {
+				require.NoError(t, insert(ctx, tx, 2))
+				require.NoError(t, tx.Meta(meta).Set(ctx, "nested", 2))
+				return errNested
+			})
+			require.ErrorIs(t, err, errNested)
+			return WithTx(ctx, sdb, TxOptions{}, func(ctx context.Context, tx Tx) error {
+				return insert(ctx, tx, 3)
+			})
+		})
+		require.NoError(t, err)
+		require.Equal(t, []int{1, 3}, items())
+		_, err = meta.GetString(ctx, "nested")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		err := WithTx(ctx, sdb, TxOptions{}, func(ctx context.Context, tx Tx) error {
+			require.NoError(t, insert(ctx, tx, 4))
+			require.NoError(t, tx.Meta(meta).Set(ctx, "rolled_back", 4))
+			return errNested
+		})
+		require.ErrorIs(t, err, errNested)
+		require.Equal(t, []int{1, 3}, items())
+		_, err = meta.GetString(ctx, "rolled_back")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("serialization failure is retried", func(t *testing.T) {
+		// Both transactions read the items before either inserts, so one of them fails to commit and is run again
+		var attempts atomic.Int32
+		read := sync.WaitGroup{}
+		read.Add(2)
+		errs := make(chan error, 2)
+		for _, id := range []int{10, 20} {
+			go func() {
+				errs <- WithTx(ctx, sdb, TxOptions{Isolation: sql.LevelSerializable}, func(ctx context.Context, tx Tx) error {
+					var sum int
+					if err := tx.GetContext(ctx, &sum, "SELECT COALESCE(sum(id), 0) FROM tx_items"); err != nil {
+						return err
+					}
+					if attempts.Add(1) <= 2 {
+						read.Done()
+						read.Wait()
+					}
+					return insert(ctx, tx, sum+id)
+				})
+			}()
+		}
+		require.NoError(t, <-errs)
+		require.NoError(t, <-errs)
+		require.Greater(t, attempts.Load(), int32(2))
+		require.Len(t, items(), 4)
+	})
+}
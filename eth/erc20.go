@@ -9,11 +9,12 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/sirupsen/logrus"
 
 	"github.com/usecorn/common-lib/dbutils"
 	"github.com/usecorn/common-lib/eth/contracts"
+	"github.com/usecorn/common-lib/server/config"
 )
 
 type E20Cache dbutils.MetaDB
@@ -22,10 +23,27 @@ type erc20 struct {
 	log       logrus.Ext1FieldLogger
 	erc20     *contracts.ERC20
 	token     string
-	ethClient *ethclient.Client
+	ethClient bind.ContractBackend
 	metaDB    E20Cache
 	decimals  int
 	network   string
+	opts      ERC20Options
+}
+
+// ERC20Options configures an ERC20
+type ERC20Options struct {
+	// MaxLogRange is the largest block range TransferEvents requests at once, 0 is unlimited, such as from
+	// config.Chain.GetMaxLogRange. Ranges the RPC provider rejects are split further.
+	MaxLogRange uint64
+	// Timestamps resolves the timestamps of the transfers' blocks in batches, such as a resolver shared by the ERC20s
+	// on the same network. If it is nil and Chain is set, a resolver configured by NewTimestampResolverConfig(Chain) is
+	// created on the ethClient, as long as it can send batch requests. Otherwise the headers are fetched one at a time.
+	Timestamps BlockTimestampResolver
+	// Chain configures the default Timestamps resolver
+	Chain *config.Chain
+	// PersistTimestamps makes the default Timestamps resolver cache the timestamps in the ERC20's MetaDB, so that
+	// they persist across restarts. Each block with transfers adds a key which never expires.
+	PersistTimestamps bool
 }
 
 type ERC20 interface {
@@ -35,11 +53,37 @@ type ERC20 interface {
 	TotalSupply(ctx context.Context) (*big.Int, error)
 }
 
-func NewERC20(log logrus.Ext1FieldLogger, metaDB E20Cache, ethClient *ethclient.Client, addr common.Address, network string) (ERC20, error) {
+// NewERC20 creates an ERC20 for the token at addr, ethClient may be an *ethclient.Client or a MultiClient
+func NewERC20(log logrus.Ext1FieldLogger, metaDB E20Cache, ethClient bind.ContractBackend, addr common.Address, network string) (ERC20, error) {
+	return NewERC20WithOptions(log, metaDB, ethClient, addr, network, ERC20Options{})
+}
+
+// NewERC20WithOptions creates an ERC20 like NewERC20, with the given options
+func NewERC20WithOptions(log logrus.Ext1FieldLogger, metaDB E20Cache, ethClient bind.ContractBackend, addr common.Address, network string,
+	opts ERC20Options) (ERC20, error) {
 	erc20Contract, err := contracts.NewERC20(addr, ethClient)
 	if err != nil {
 		return nil, err
 	}
+	if opts.Timestamps == nil && opts.Chain != nil {
+		var batchCaller BatchCaller
+		switch client := ethClient.(type) {
+		case BatchCaller:
+			batchCaller = client
+		case interface{ Client() *rpc.Client }:
+			batchCaller = client.Client()
+		}
+		if batchCaller != nil {
+			var meta dbutils.MetaDB
+			if opts.PersistTimestamps {
+				meta = metaDB
+			}
+			opts.Timestamps, err = NewBlockTimestampResolver(batchCaller, meta, network, NewTimestampResolverConfig(opts.Chain))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	return &erc20{
 		log:       log,
 		erc20:     erc20Contract,
@@ -48,6 +92,7 @@ func NewERC20(log logrus.Ext1FieldLogger, metaDB E20Cache, ethClient *ethclient.
 		decimals:  -1,
 		ethClient: ethClient,
 		network:   network,
+		opts:      opts,
 	}, nil
 }
 
@@ -56,6 +101,14 @@ func (et *erc20) BalanceOf(ctx context.Context, addr common.Address) (*big.Int,
 }
 
 func (et *erc20) TransferEvents(ctx context.Context, start, end uint64) ([]ERC20Transfer, error) {
+	out, err := FetchLogRange(ctx, start, end, et.opts.MaxLogRange, et.filterTransfers)
+	if err != nil {
+		return nil, err
+	}
+	return out, et.fillTimestamps(ctx, out)
+}
+
+func (et *erc20) filterTransfers(ctx context.Context, start, end uint64) ([]ERC20Transfer, error) {
 	iter, err := et.erc20.FilterTransfer(&bind.FilterOpts{
 		Start:   start,
 		End:     &end,
@@ -81,21 +134,23 @@ func (et *erc20) TransferEvents(ctx context.Context, start, end uint64) ([]ERC20
 
 	}
 
-	return out, et.fillTimestamps(ctx, out)
+	return out, iter.Error()
 }
 
 func (et *erc20) fillTimestamps(ctx context.Context, events []ERC20Transfer) error {
-	blockTimestamps := make(map[uint64]uint64)
-	for _, event := range events {
-		blockTimestamps[event.BlockNumber] = 0
+	blocks := make([]uint64, len(events))
+	for i, event := range events {
+		blocks[i] = event.BlockNumber
 	}
-
-	for blockNum := range blockTimestamps {
-		header, err := et.ethClient.HeaderByNumber(ctx, new(big.Int).SetUint64(blockNum))
-		if err != nil {
-			return errors.Wrapf(err, "failed to get block %d by number", blockNum)
-		}
-		blockTimestamps[blockNum] = header.Time
+	var blockTimestamps map[uint64]uint64
+	var err error
+	if et.opts.Timestamps != nil {
+		blockTimestamps, err = et.opts.Timestamps.Timestamps(ctx, blocks)
+	} else {
+		blockTimestamps, err = et.headerTimestamps(ctx, blocks)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get block timestamps")
 	}
 
 	for i, event := range events {
@@ -104,17 +159,33 @@ func (et *erc20) fillTimestamps(ctx context.Context, events []ERC20Transfer) err
 	return nil
 }
 
+// headerTimestamps gets the timestamps of blocks from their headers, one block at a time
+func (et *erc20) headerTimestamps(ctx context.Context, blocks []uint64) (map[uint64]uint64, error) {
+	out := make(map[uint64]uint64, len(blocks))
+	for _, block := range blocks {
+		if _, ok := out[block]; ok {
+			continue
+		}
+		header, err := et.ethClient.HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get header of block %d", block)
+		}
+		out[block] = header.Time
+	}
+	return out, nil
+}
+
 func (et *erc20) Decimals(ctx context.Context) (int, error) {
 
 	if et.decimals != -1 {
 		return et.decimals, nil
 	}
-	var decimalsKey string
-	if et.network == EthereumNetwork {
-		decimalsKey = "erc20::" + et.token + "::decimals"
-	} else {
-		decimalsKey = "erc20::" + et.network + "::" + et.token + "::decimals"
+	network, err := Networks.Get(et.network)
+	if err != nil {
+		// Networks which are not registered are namespaced like new networks
+		network = Network{Name: et.network}
 	}
+	decimalsKey := network.CacheKey("erc20", et.token, "decimals")
 
 	decimals, err := et.metaDB.GetInt64(ctx, decimalsKey)
 	if err == nil {
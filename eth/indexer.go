@@ -0,0 +1,315 @@
+package eth
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"github.com/usecorn/common-lib/app"
+	"github.com/usecorn/common-lib/dbutils"
+	"github.com/usecorn/common-lib/server/config"
+)
+
+// ErrReorgTooDeep is returned when a reorg forked before the oldest block hash kept by the indexer, so the
+// transfers cannot be rolled back automatically
+var ErrReorgTooDeep = errors.New("reorg is deeper than the stored block hashes")
+
+// IndexerClient gets the blocks the indexer scans
+type IndexerClient interface {
+	EthClient
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// TransferSource gets the transfers in a range of blocks, it is implemented by ERC20
+type TransferSource interface {
+	TransferEvents(ctx context.Context, start, end uint64) ([]ERC20Transfer, error)
+}
+
+// TransferHandler stores the transfers found by a TransferIndexer. The tx passed to it also commits the indexer's
+// progress, so that the transfers are stored exactly once, and is nil if the indexer has no database.
+type TransferHandler interface {
+	// HandleTransfers stores the transfers in the blocks from start to end, there may be none
+	HandleTransfers(ctx context.Context, tx dbutils.Tx, start, end uint64, transfers []ERC20Transfer) error
+	// Rollback removes the transfers from fromBlock onwards, as those blocks have been reorged out of the chain
+	Rollback(ctx context.Context, tx dbutils.Tx, fromBlock uint64) error
+}
+
+// IndexerConfig configures a TransferIndexer
+type IndexerConfig struct {
+	// StartBlock is the first block which is scanned
+	StartBlock uint64 `env:"INDEXER_START_BLOCK" env-default:"0"`
+	// MaxReorgDepth is how many blocks back the hashes are kept, to find the latest scanned window before a reorg forked
+	// from the indexed chain
+	MaxReorgDepth uint64 `env:"INDEXER_MAX_REORG_DEPTH" env-default:"1024"`
+	// PollInterval is how long to wait for new blocks once the indexer has caught up with the chain. If it is 0, the
+	// block time of the network is used, or defaultPollInterval if it is unknown.
+	PollInterval time.Duration `env:"INDEXER_POLL_INTERVAL" env-default:"5s"`
+}
+
+// defaultPollInterval is the PollInterval of networks whose block time is unknown
+const defaultPollInterval = 5 * time.Second
+
+// TransferIndexer scans for the transfers of a token, and rolls them back when the blocks they were in are reorged.
+// Only the hash of the last block of each scanned window is kept, so a reorg is rolled back to the end of the latest
+// window which is still in the chain, and up to MaxScanBlocks blocks before the fork are rolled back and scanned again.
+type TransferIndexer interface {
+	// Run scans until ctx is cancelled
+	Run(ctx context.Context) error
+	// ScanOnce scans the next window of up to MaxScanBlocks blocks, or rolls back a reorg, returning how many blocks
+	// were scanned or rolled back. It returns 0 once the indexer has caught up with the chain.
+	ScanOnce(ctx context.Context) (uint64, error)
+}
+
+// blockCheckpoint is the hash of the last block of a scanned window
+type blockCheckpoint struct {
+	Number uint64 `json:"number"`
+	Hash   string `json:"hash"`
+}
+
+// blockCheckpoints are the checkpoints of the scanned windows, oldest first
+type blockCheckpoints struct {
+	Blocks []blockCheckpoint `json:"blocks"`
+	// Pruned is set once old checkpoints have been dropped, after which a reorg before the oldest one
+	// cannot be rolled back
+	Pruned bool `json:"pruned"`
+}
+
+type transferIndexer struct {
+	log            logrus.Ext1FieldLogger
+	sdb            *sqlx.DB
+	meta           dbutils.MetaDB
+	cursor         dbutils.BlockCursor
+	checkpointsKey string
+	client         IndexerClient
+	source         TransferSource
+	handler        TransferHandler
+	chain          *config.Chain
+	conf           IndexerConfig
+}
+
+// NewTransferIndexer creates a TransferIndexer for the transfers of contract on network from source, which are passed
+// to handler. It scans in windows of chain.MaxScanBlocks up to chain.LagBlocks behind the head, with the overrides of
+// network in Networks applied, and stores its progress and the hashes of the scanned blocks in meta, in a transaction
+// on sdb. If sdb is nil, such as in tests with the in-memory MetaDB, the progress is written without a transaction.
+func NewTransferIndexer(log logrus.Ext1FieldLogger, sdb *sqlx.DB, meta dbutils.MetaDB, client IndexerClient, source TransferSource,
+	handler TransferHandler, network string, contract string, chain *config.Chain, conf IndexerConfig) (TransferIndexer, error) {
+	if chain.MaxScanBlocks == 0 {
+		return nil, errors.New("max scan blocks must be positive")
+	}
+	if conf.MaxReorgDepth == 0 {
+		return nil, errors.New("max reorg depth must be positive")
+	}
+	if n, err := Networks.Get(network); err == nil {
+		chain = n.Chain(*chain)
+		if conf.PollInterval == 0 {
+			conf.PollInterval = n.BlockTime
+		}
+	}
+	if conf.PollInterval == 0 {
+		conf.PollInterval = defaultPollInterval
+	}
+	contract = strings.ToLower(contract)
+	// The cursor is stored under erc20_indexer::block_cursor::<network>::<contract>, next to the checkpoints under
+	// erc20_indexer::block_checkpoints::<network>::<contract>
+	meta = dbutils.WithNamespace(meta, "erc20_indexer")
+	return &transferIndexer{
+		log:            log.WithField("network", network).WithField("contract", contract),
+		sdb:            sdb,
+		meta:           meta,
+		cursor:         dbutils.NewBlockCursor(meta, network, contract, conf.StartBlock),
+		checkpointsKey: "block_checkpoints" + dbutils.NamespaceSeparator + network + dbutils.NamespaceSeparator + contract,
+		client:         client,
+		source:         source,
+		handler:        handler,
+		chain:          chain,
+		conf:           conf,
+	}, nil
+}
+
+func (ti *transferIndexer) Run(ctx context.Context) error {
+	for {
+		n, err := ti.ScanOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, ErrReorgTooDeep) {
+			return err
+		}
+		if err != nil {
+			ti.log.WithError(err).Error("failed to scan for transfers")
+		}
+		if n != 0 && err == nil {
+			continue
+		}
+		if app.SleepContext(ctx, ti.conf.PollInterval) != nil {
+			return nil
+		}
+	}
+}
+
+// withTx runs fn in a transaction if the indexer has a database
+func (ti *transferIndexer) withTx(ctx context.Context, fn func(ctx context.Context, tx dbutils.Tx) error) error {
+	if ti.sdb == nil {
+		return fn(ctx, nil)
+	}
+	return dbutils.WithTx(ctx, ti.sdb, dbutils.TxOptions{}, fn)
+}
+
+// sqlTx returns the transaction for the cursor, which is nil without a database
+func sqlTx(tx dbutils.Tx) *sqlx.Tx {
+	if tx == nil {
+		return nil
+	}
+	return tx.SQLTx()
+}
+
+// txMeta returns the MetaDB bound to tx, if there is one
+func (ti *transferIndexer) txMeta(tx dbutils.Tx) dbutils.MetaDB {
+	if tx == nil {
+		return ti.meta
+	}
+	return tx.Meta(ti.meta)
+}
+
+func (ti *transferIndexer) header(ctx context.Context, number uint64) (*types.Header, error) {
+	header, err := ti.client.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get header %d", number)
+	}
+	return header, nil
+}
+
+func (ti *transferIndexer) ScanOnce(ctx context.Context) (uint64, error) {
+	pos, err := ti.cursor.Load(ctx)
+	if err != nil {
+		return 0, err
+	}
+	head, err := CurrentSafeBlockHead(ctx, ti.chain, ti.client)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get the block head")
+	}
+	if pos.NextBlock > head {
+		return 0, nil
+	}
+
+	start := pos.NextBlock
+	startHeader, err := ti.header(ctx, start)
+	if err != nil {
+		return 0, err
+	}
+	if pos.LastHash != "" && !strings.EqualFold(startHeader.ParentHash.Hex(), pos.LastHash) {
+		return ti.rollback(ctx, pos)
+	}
+
+	end := min(start+ti.chain.MaxScanBlocks-1, head)
+	// The end hash is fetched before the transfers, so that if the chain is reorged in between, the next scan detects it
+	endHeader := startHeader
+	if end != start {
+		if endHeader, err = ti.header(ctx, end); err != nil {
+			return 0, err
+		}
+	}
+	transfers, err := ti.source.TransferEvents(ctx, start, end)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get transfers from %d to %d", start, end)
+	}
+
+	next := dbutils.BlockPosition{NextBlock: end + 1, LastHash: strings.ToLower(endHeader.Hash().Hex())}
+	err = ti.withTx(ctx, func(ctx context.Context, tx dbutils.Tx) error {
+		if err := ti.handler.HandleTransfers(ctx, tx, start, end, transfers); err != nil {
+			return errors.Wrap(err, "failed to handle transfers")
+		}
+		if err := ti.cursor.Advance(ctx, sqlTx(tx), pos, next); err != nil {
+			return err
+		}
+		meta := ti.txMeta(tx)
+		checkpoints, err := ti.checkpoints(ctx, meta)
+		if err != nil {
+			return err
+		}
+		checkpoints.Blocks = append(checkpoints.Blocks, blockCheckpoint{Number: end, Hash: next.LastHash})
+		checkpoints.prune(ti.conf.MaxReorgDepth)
+		return dbutils.SetJSON(ctx, meta, ti.checkpointsKey, checkpoints)
+	})
+	if err != nil {
+		return 0, err
+	}
+	ti.log.WithField("start", start).WithField("end", end).WithField("transfers", len(transfers)).Debug("scanned blocks")
+	return end - start + 1, nil
+}
+
+func (ti *transferIndexer) checkpoints(ctx context.Context, meta dbutils.MetaDB) (blockCheckpoints, error) {
+	checkpoints, err := dbutils.GetJSON[blockCheckpoints](ctx, meta, ti.checkpointsKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return blockCheckpoints{}, nil
+	}
+	if err != nil {
+		return blockCheckpoints{}, errors.Wrap(err, "failed to get block checkpoints")
+	}
+	return checkpoints, nil
+}
+
+// prune drops the checkpoints which are more than depth blocks behind the latest, keeping one at or beyond
+// depth, so that a reorg of up to depth blocks still has a checkpoint before it
+func (bc *blockCheckpoints) prune(depth uint64) {
+	if len(bc.Blocks) == 0 {
+		return
+	}
+	latest := bc.Blocks[len(bc.Blocks)-1].Number
+	for len(bc.Blocks) > 1 && bc.Blocks[1].Number+depth <= latest {
+		bc.Blocks = bc.Blocks[1:]
+		bc.Pruned = true
+	}
+}
+
+// rollback finds the latest checkpoint which is still in the chain, and rolls back the transfers after it
+func (ti *transferIndexer) rollback(ctx context.Context, pos dbutils.BlockPosition) (uint64, error) {
+	checkpoints, err := ti.checkpoints(ctx, ti.meta)
+	if err != nil {
+		return 0, err
+	}
+	ancestor := -1
+	for i := len(checkpoints.Blocks) - 1; i >= 0; i-- {
+		header, err := ti.header(ctx, checkpoints.Blocks[i].Number)
+		if err != nil {
+			return 0, err
+		}
+		if strings.EqualFold(header.Hash().Hex(), checkpoints.Blocks[i].Hash) {
+			ancestor = i
+			break
+		}
+	}
+
+	// Without a matching checkpoint, everything from the start block is rolled back
+	to := dbutils.BlockPosition{NextBlock: ti.conf.StartBlock}
+	if ancestor != -1 {
+		to = dbutils.BlockPosition{NextBlock: checkpoints.Blocks[ancestor].Number + 1, LastHash: checkpoints.Blocks[ancestor].Hash}
+	} else if checkpoints.Pruned {
+		return 0, errors.Wrapf(ErrReorgTooDeep, "no stored block hash from %d to %d is in the chain",
+			checkpoints.Blocks[0].Number, pos.NextBlock-1)
+	}
+	checkpoints.Blocks = checkpoints.Blocks[:ancestor+1]
+
+	err = ti.withTx(ctx, func(ctx context.Context, tx dbutils.Tx) error {
+		if err := ti.handler.Rollback(ctx, tx, to.NextBlock); err != nil {
+			return errors.Wrap(err, "failed to roll back transfers")
+		}
+		if err := ti.cursor.Rewind(ctx, sqlTx(tx), pos, to); err != nil {
+			return err
+		}
+		return dbutils.SetJSON(ctx, ti.txMeta(tx), ti.checkpointsKey, checkpoints)
+	})
+	if err != nil {
+		return 0, err
+	}
+	rolledBack := pos.NextBlock - to.NextBlock
+	ti.log.WithField("from", to.NextBlock).WithField("blocks", rolledBack).Warn("rolled back reorged blocks")
+	return rolledBack, nil
+}
@@ -0,0 +1,210 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usecorn/common-lib/dbutils"
+	"github.com/usecorn/common-lib/server/config"
+)
+
+// blockTransferSource returns a transfer in every block, with the block hash as the tx hash
+type blockTransferSource struct {
+	client simulated.Client
+}
+
+func (bts blockTransferSource) TransferEvents(ctx context.Context, start, end uint64) ([]ERC20Transfer, error) {
+	var out []ERC20Transfer
+	for block := start; block <= end; block++ {
+		header, err := bts.client.HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ERC20Transfer{TXHash: strings.ToLower(header.Hash().Hex()), BlockNumber: block, Value: big.NewInt(1)})
+	}
+	return out, nil
+}
+
+type memoryTransferHandler struct {
+	transfers map[uint64]ERC20Transfer
+}
+
+func (mth *memoryTransferHandler) HandleTransfers(_ context.Context, _ dbutils.Tx, _, _ uint64, transfers []ERC20Transfer) error {
+	for _, transfer := range transfers {
+		mth.transfers[transfer.BlockNumber] = transfer
+	}
+	return nil
+}
+
+func (mth *memoryTransferHandler) Rollback(_ context.Context, _ dbutils.Tx, fromBlock uint64) error {
+	for block := range mth.transfers {
+		if block >= fromBlock {
+			delete(mth.transfers, block)
+		}
+	}
+	return nil
+}
+
+func Test_TransferIndexer(t *testing.T) {
+	meta, err := dbutils.NewMetaDBMemory()
+	require.NoError(t, err)
+	handler := &memoryTransferHandler{transfers: map[uint64]ERC20Transfer{}}
+	testTransferIndexer(t, nil, meta, handler, func() map[uint64]string {
+		out := make(map[uint64]string, len(handler.transfers))
+		for block, transfer := range handler.transfers {
+			out[block] = transfer.TXHash
+		}
+		return out
+	})
+}
+
+// postgresTransferHandler stores the transfers in a table, in the indexer's transaction
+type postgresTransferHandler struct{}
+
+func (postgresTransferHandler) HandleTransfers(ctx context.Context, tx dbutils.Tx, _, _ uint64, transfers []ERC20Transfer) error {
+	for _, transfer := range transfers {
+		_, err := tx.ExecContext(ctx, "INSERT INTO indexer_test_transfers (block_number, tx_hash) VALUES ($1, $2)",
+			transfer.BlockNumber, transfer.TXHash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (postgresTransferHandler) Rollback(ctx context.Context, tx dbutils.Tx, fromBlock uint64) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM indexer_test_transfers WHERE block_number >= $1", fromBlock)
+	return err
+}
+
+// Test_TransferIndexer_Postgres runs the indexer with its progress and transfers committed in transactions,
+// against the database in TEST_DATABASE_URL
+func Test_TransferIndexer_Postgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	sdb, err := sqlx.Connect("pgx", dsn)
+	require.NoError(t, err)
+	defer sdb.Close()
+	_, err = sdb.ExecContext(ctx, dbutils.MetaSchema)
+	require.NoError(t, err)
+	_, err = sdb.ExecContext(ctx, `DROP TABLE IF EXISTS indexer_test_transfers;
+		CREATE TABLE indexer_test_transfers (block_number BIGINT PRIMARY KEY, tx_hash TEXT NOT NULL);
+		DELETE FROM meta WHERE key LIKE '%::simulated::0xtoken%'`)
+	require.NoError(t, err)
+	defer sdb.Exec("DROP TABLE IF EXISTS indexer_test_transfers")
+
+	meta, err := dbutils.NewMetaDB(sdb)
+	require.NoError(t, err)
+	testTransferIndexer(t, sdb, meta, postgresTransferHandler{}, func() map[uint64]string {
+		var rows []struct {
+			BlockNumber uint64 `db:"block_number"`
+			TXHash      string `db:"tx_hash"`
+		}
+		require.NoError(t, sdb.SelectContext(ctx, &rows, "SELECT block_number, tx_hash FROM indexer_test_transfers"))
+		out := make(map[uint64]string, len(rows))
+		for _, row := range rows {
+			out[row.BlockNumber] = row.TXHash
+		}
+		return out
+	})
+}
+
+// testTransferIndexer indexes a simulated chain, reorgs it and checks that transfers returns the canonical transfers
+func testTransferIndexer(t *testing.T, sdb *sqlx.DB, meta dbutils.MetaDB, handler TransferHandler, transfers func() map[uint64]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	backend := simulated.NewBackend(types.GenesisAlloc{})
+	defer backend.Close()
+	client := backend.Client()
+	for range 10 {
+		backend.Commit()
+	}
+
+	chain := &config.Chain{MaxScanBlocks: 4, RPCMaxRetries: 1}
+	indexer, err := NewTransferIndexer(logrus.New(), sdb, meta, client, blockTransferSource{client: client}, handler,
+		"simulated", "0xToken", chain, IndexerConfig{StartBlock: 1, MaxReorgDepth: 100})
+	require.NoError(t, err)
+
+	scanAll := func() {
+		for {
+			n, err := indexer.ScanOnce(ctx)
+			require.NoError(t, err)
+			if n == 0 {
+				return
+			}
+		}
+	}
+	requireCanonical := func(head uint64) {
+		indexed := transfers()
+		require.Len(t, indexed, int(head))
+		for block := uint64(1); block <= head; block++ {
+			header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(block))
+			require.NoError(t, err)
+			require.Equal(t, strings.ToLower(header.Hash().Hex()), indexed[block], "block %d", block)
+		}
+	}
+
+	scanAll()
+	requireCanonical(10)
+	pos, err := dbutils.NewBlockCursor(dbutils.WithNamespace(meta, "erc20_indexer"), "simulated", "0xToken", 1).Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(11), pos.NextBlock)
+	// The cursor and the checkpoints share the indexer's namespace
+	keys, err := meta.ListPrefix(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	for key := range keys {
+		require.True(t, strings.HasPrefix(key, "erc20_indexer::"), key)
+	}
+
+	// Reorg from block 7, with a longer chain
+	parent, err := client.HeaderByNumber(ctx, big.NewInt(6))
+	require.NoError(t, err)
+	require.NoError(t, backend.Fork(parent.Hash()))
+	require.NoError(t, backend.AdjustTime(time.Minute))
+	for range 5 {
+		backend.Commit()
+	}
+	head, err := client.BlockNumber(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), head)
+
+	// The windows end at 4, 8 and 10, so it rolls back to the checkpoint at 4
+	n, err := indexer.ScanOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), n)
+	require.Len(t, transfers(), 4)
+
+	scanAll()
+	requireCanonical(12)
+}
+
+func Test_blockCheckpoints_prune(t *testing.T) {
+	checkpoints := blockCheckpoints{Blocks: []blockCheckpoint{{Number: 10}, {Number: 20}, {Number: 30}, {Number: 40}}}
+	checkpoints.prune(25)
+	require.Equal(t, []blockCheckpoint{{Number: 10}, {Number: 20}, {Number: 30}, {Number: 40}}, checkpoints.Blocks)
+	require.False(t, checkpoints.Pruned)
+
+	checkpoints.prune(20)
+	require.Equal(t, []blockCheckpoint{{Number: 20}, {Number: 30}, {Number: 40}}, checkpoints.Blocks)
+	require.True(t, checkpoints.Pruned)
+
+	// The latest checkpoint is always kept, along with the one before it
+	checkpoints.prune(1)
+	require.Equal(t, []blockCheckpoint{{Number: 30}, {Number: 40}}, checkpoints.Blocks)
+}
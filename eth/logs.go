@@ -0,0 +1,80 @@
+package eth
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// logRangeErrors are parts of the errors RPC providers return when an eth_getLogs range is too large,
+// or would return too many logs
+var logRangeErrors = []string{
+	"query returned more than", // geth, infura: query returned more than 10000 results
+	"maximum block range",      // exceed maximum block range: 50000
+	"block range is too wide",
+	"block range too large",
+	"range is too large",
+	"response size exceeded", // alchemy: log response size exceeded
+	"too many logs",
+	"is limited to a", // quicknode: eth_getLogs is limited to a 10000 range
+}
+
+// IsLogRangeError returns true if err is an RPC provider rejecting an eth_getLogs range, in which case a smaller
+// range may succeed
+func IsLogRangeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, rangeErr := range logRangeErrors {
+		if strings.Contains(msg, rangeErr) {
+			return true
+		}
+	}
+	// Other providers reject large ranges as limit exceeded, with a hint at the range to use
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr) && rpcErr.ErrorCode() == rpcLimitExceededCode && strings.Contains(msg, "range")
+}
+
+// SortIndexer is an event which can be ordered within the chain
+type SortIndexer interface {
+	SortIndex() uint64
+}
+
+// FetchLogRange calls fetch for the blocks from start to end in ranges of at most maxRange blocks, 0 for unlimited.
+// When the RPC provider rejects a range with a log range error, the range is halved and retried, and the smaller range
+// is used for the rest of the blocks. The results are merged in SortIndex order.
+func FetchLogRange[T SortIndexer](ctx context.Context, start, end, maxRange uint64, fetch func(ctx context.Context, start, end uint64) ([]T, error)) ([]T, error) {
+	if start > end {
+		return nil, errors.Errorf("invalid block range from %d to %d", start, end)
+	}
+	size := end - start + 1
+	if maxRange != 0 && maxRange < size {
+		size = maxRange
+	}
+
+	out := []T{}
+	for from := start; ; {
+		to := min(from+size-1, end)
+		events, err := fetch(ctx, from, to)
+		if err != nil {
+			if to > from && IsLogRangeError(err) {
+				size = (to - from + 1) / 2
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to get logs from %d to %d", from, to)
+		}
+		out = append(out, events...)
+		if to == end {
+			break
+		}
+		from = to + 1
+	}
+
+	slices.SortStableFunc(out, func(a, b T) int { return cmp.Compare(a.SortIndex(), b.SortIndex()) })
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package eth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_IsLogRangeError(t *testing.T) {
+	require.True(t, IsLogRangeError(errors.New("query returned more than 10000 results")))
+	require.True(t, IsLogRangeError(errors.Wrap(errors.New("exceed maximum block range: 50000"), "failed")))
+	require.True(t, IsLogRangeError(errors.New("Log response size exceeded. You can make eth_getLogs requests with up to a 2K block range")))
+	require.True(t, IsLogRangeError(limitExceededError("try with this block range [0x1, 0x2000]")))
+	require.False(t, IsLogRangeError(limitExceededError("rate limit exceeded")))
+	require.False(t, IsLogRangeError(errors.New("connection refused")))
+	require.False(t, IsLogRangeError(errors.New("invalid block range from 10 to 1")))
+	require.False(t, IsLogRangeError(errors.New("requested block range is not available")))
+	require.False(t, IsLogRangeError(nil))
+}
+
+// limitExceededError is a JSON-RPC error with the limit exceeded code
+type limitExceededError string
+
+func (lee limitExceededError) Error() string  { return string(lee) }
+func (lee limitExceededError) ErrorCode() int { return rpcLimitExceededCode }
+
+func Test_FetchLogRange(t *testing.T) {
+	ctx := context.Background()
+
+	// A provider which returns a transfer per block, in reverse order, and rejects ranges of more than 8 blocks
+	var calls [][2]uint64
+	fetch := func(_ context.Context, start, end uint64) ([]ERC20Transfer, error) {
+		calls = append(calls, [2]uint64{start, end})
+		if end-start+1 > 8 {
+			return nil, errors.New("query returned more than 10000 results")
+		}
+		var out []ERC20Transfer
+		for block := end; block >= start; block-- {
+			out = append(out, ERC20Transfer{BlockNumber: block})
+		}
+		return out, nil
+	}
+
+	transfers, err := FetchLogRange(ctx, 100, 129, 20, fetch)
+	require.NoError(t, err)
+	require.Len(t, transfers, 30)
+	for i := range transfers {
+		require.Equal(t, uint64(100+i), transfers[i].BlockNumber)
+	}
+	// Limited to 20 by the max range, then halved twice, after which the smaller range is kept
+	require.Equal(t, [][2]uint64{{100, 119}, {100, 109}, {100, 104}, {105, 109}, {110, 114}, {115, 119}, {120, 124}, {125, 129}}, calls)
+
+	t.Run("single block", func(t *testing.T) {
+		calls = nil
+		transfers, err := FetchLogRange(ctx, 5, 5, 0, fetch)
+		require.NoError(t, err)
+		require.Len(t, transfers, 1)
+		require.Len(t, calls, 1)
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		calls = nil
+		_, err := FetchLogRange(ctx, 1, 100, 0, func(_ context.Context, start, end uint64) ([]ERC20Transfer, error) {
+			calls = append(calls, [2]uint64{start, end})
+			return nil, errors.New("connection refused")
+		})
+		require.ErrorContains(t, err, "connection refused")
+		require.Len(t, calls, 1)
+
+		_, err = FetchLogRange(ctx, 10, 1, 0, fetch)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,248 @@
+package eth
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/samber/lo"
+
+	"github.com/usecorn/common-lib/dbutils"
+	"github.com/usecorn/common-lib/server/config"
+)
+
+// ErrUnknownNetwork is returned when a network is not in the NetworkRegistry
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// NetworksEnv is the environment variable NetworkRegistry.LoadFromEnv reads a JSON array of Networks from
+const NetworksEnv = "NETWORKS"
+
+// Network describes an EVM network which the services support
+type Network struct {
+	// Name identifies the network, such as in config, cache keys and APIs
+	Name    string `json:"name"`
+	ChainID int64  `json:"chain_id"`
+	// RPCURLEnv and RPCFallbackURLsEnv are the environment variables of the primary RPC URL and the comma separated
+	// fallback URLs, which NetworkRegistry.LoadRPCURLs resolves into config.Chain.NetworkRPCURLs
+	RPCURLEnv          string `json:"rpc_url_env"`
+	RPCFallbackURLsEnv string `json:"rpc_fallback_urls_env"`
+	// BlockTime is the average time between blocks, 0 if it is unknown, in JSON as a duration such as "12s"
+	BlockTime time.Duration `json:"-"`
+	// LagBlocks overrides config.Chain.LagBlocks when it is not 0
+	LagBlocks uint64 `json:"lag_blocks"`
+	// PriceURL is the endpoint of the prices of the tokens on the network
+	PriceURL string `json:"price_url"`
+	// UnprefixedCacheKeys is set for networks whose cached token data predates networks being part of the key
+	UnprefixedCacheKeys bool `json:"unprefixed_cache_keys"`
+}
+
+func (n *Network) UnmarshalJSON(data []byte) error {
+	type network Network
+	aux := struct {
+		*network
+		BlockTime string `json:"block_time"`
+	}{network: (*network)(n)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.BlockTime != "" {
+		blockTime, err := time.ParseDuration(aux.BlockTime)
+		if err != nil {
+			return errors.Wrapf(err, "invalid block time of network %s", n.Name)
+		}
+		n.BlockTime = blockTime
+	}
+	return nil
+}
+
+func (n Network) MarshalJSON() ([]byte, error) {
+	type network Network
+	return json.Marshal(struct {
+		network
+		BlockTime string `json:"block_time,omitempty"`
+	}{network: network(n), BlockTime: durationString(n.BlockTime)})
+}
+
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// RPCURLs returns the primary RPC URL of the network followed by its fallbacks
+func (n Network) RPCURLs(chain *config.Chain) ([]string, error) {
+	urls := chain.GetRPCURLs(n.Name)
+	if len(urls) == 0 {
+		return nil, errors.Errorf("no RPC URL configured for network %s, see NetworkRegistry.LoadRPCURLs", n.Name)
+	}
+	return urls, nil
+}
+
+// Chain returns a copy of chain with the network's overrides applied
+func (n Network) Chain(chain config.Chain) *config.Chain {
+	if n.LagBlocks != 0 {
+		chain.LagBlocks = n.LagBlocks
+	}
+	return &chain
+}
+
+// NetworkChain returns chain with the overrides of network in Networks applied, or chain itself if the network is
+// not registered
+func NetworkChain(network string, chain *config.Chain) *config.Chain {
+	n, err := Networks.Get(network)
+	if err != nil {
+		return chain
+	}
+	return n.Chain(*chain)
+}
+
+// CacheKey returns the MetaDB key of parts of a token's data on the network, such as
+// erc20::corn-mainnet::<token>::decimals
+func (n Network) CacheKey(prefix string, parts ...string) string {
+	key := prefix
+	if !n.UnprefixedCacheKeys {
+		key += dbutils.NamespaceSeparator + n.Name
+	}
+	for _, part := range parts {
+		key += dbutils.NamespaceSeparator + part
+	}
+	return key
+}
+
+// NetworkRegistry maps network names to their Networks, it is safe for concurrent use
+type NetworkRegistry struct {
+	mux      sync.RWMutex
+	networks map[string]Network
+}
+
+// NewNetworkRegistry creates a NetworkRegistry with networks
+func NewNetworkRegistry(networks ...Network) (*NetworkRegistry, error) {
+	nr := &NetworkRegistry{networks: make(map[string]Network, len(networks))}
+	for _, network := range networks {
+		if err := nr.Register(network); err != nil {
+			return nil, err
+		}
+	}
+	return nr, nil
+}
+
+// Register adds network to the registry, replacing any network with the same name
+func (nr *NetworkRegistry) Register(network Network) error {
+	if network.Name == "" {
+		return errors.New("network name is required")
+	}
+	if network.ChainID <= 0 {
+		return errors.Errorf("network %s has an invalid chain id %d", network.Name, network.ChainID)
+	}
+	nr.mux.Lock()
+	defer nr.mux.Unlock()
+	for name, other := range nr.networks {
+		if name != network.Name && other.ChainID == network.ChainID {
+			return errors.Errorf("network %s has the same chain id as %s", network.Name, name)
+		}
+	}
+	nr.networks[network.Name] = network
+	return nil
+}
+
+// Get returns the network with name, or ErrUnknownNetwork
+func (nr *NetworkRegistry) Get(name string) (Network, error) {
+	nr.mux.RLock()
+	defer nr.mux.RUnlock()
+	network, ok := nr.networks[name]
+	if !ok {
+		return Network{}, errors.Wrapf(ErrUnknownNetwork, "network %s", name)
+	}
+	return network, nil
+}
+
+// ByChainID returns the network with chainID, or ErrUnknownNetwork
+func (nr *NetworkRegistry) ByChainID(chainID int64) (Network, error) {
+	nr.mux.RLock()
+	defer nr.mux.RUnlock()
+	for _, network := range nr.networks {
+		if network.ChainID == chainID {
+			return network, nil
+		}
+	}
+	return Network{}, errors.Wrapf(ErrUnknownNetwork, "chain id %d", chainID)
+}
+
+// Networks returns all the networks, ordered by chain id
+func (nr *NetworkRegistry) Networks() []Network {
+	nr.mux.RLock()
+	defer nr.mux.RUnlock()
+	out := make([]Network, 0, len(nr.networks))
+	for _, network := range nr.networks {
+		out = append(out, network)
+	}
+	slices.SortFunc(out, func(a, b Network) int { return cmp.Compare(a.ChainID, b.ChainID) })
+	return out
+}
+
+// ChainIDs returns the chain ids of all the networks, in order
+func (nr *NetworkRegistry) ChainIDs() []int64 {
+	networks := nr.Networks()
+	out := make([]int64, len(networks))
+	for i := range networks {
+		out[i] = networks[i].ChainID
+	}
+	return out
+}
+
+// Load registers the networks in data, a JSON array of Networks, replacing those with the same names
+func (nr *NetworkRegistry) Load(data []byte) error {
+	var networks []Network
+	if err := json.Unmarshal(data, &networks); err != nil {
+		return errors.Wrap(err, "failed to parse networks")
+	}
+	for _, network := range networks {
+		if err := nr.Register(network); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFromEnv loads the networks in the NetworksEnv environment variable, if it is set
+func (nr *NetworkRegistry) LoadFromEnv() error {
+	data, ok := os.LookupEnv(NetworksEnv)
+	if !ok || data == "" {
+		return nil
+	}
+	return errors.Wrap(nr.Load([]byte(data)), "failed to load networks from "+NetworksEnv)
+}
+
+// LoadRPCURLs resolves the RPC URLs of every network in the registry into chain.NetworkRPCURLs, it should be called
+// once when the config is loaded, after the networks are registered
+func (nr *NetworkRegistry) LoadRPCURLs(chain *config.Chain) {
+	for _, network := range nr.Networks() {
+		chain.LoadNetworkRPCURLs(network.Name, network.RPCURLEnv, network.RPCFallbackURLsEnv)
+	}
+}
+
+// Networks is the default NetworkRegistry, with the built in networks. Services can add networks to it on startup
+// with Networks.LoadFromEnv or Networks.Register, and then resolve their RPC URLs with Networks.LoadRPCURLs.
+var Networks = lo.Must(NewNetworkRegistry(
+	Network{
+		Name:                EthereumNetwork,
+		ChainID:             EthereumChainID,
+		RPCURLEnv:           "RPC_URL",
+		RPCFallbackURLsEnv:  "RPC_FALLBACK_URLS",
+		BlockTime:           12 * time.Second,
+		PriceURL:            "https://api.usecorn.com/api/v1/price/all",
+		UnprefixedCacheKeys: true,
+	},
+	Network{
+		Name:               CornMainnet,
+		ChainID:            CornMainnetChainID,
+		RPCURLEnv:          "CORN_RPC_URL",
+		RPCFallbackURLsEnv: "CORN_RPC_FALLBACK_URLS",
+		PriceURL:           "https://api.usecorn.com/api/v1/price/corn/all",
+	},
+))
@@ -0,0 +1,96 @@
+package eth
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/usecorn/common-lib/server/config"
+)
+
+func Test_NetworkRegistry(t *testing.T) {
+	registry, err := NewNetworkRegistry(Networks.Networks()...)
+	require.NoError(t, err)
+	require.Equal(t, []int64{EthereumChainID, CornMainnetChainID}, registry.ChainIDs())
+
+	err = registry.Load([]byte(`[{"name": "base", "chain_id": 8453, "rpc_url_env": "BASE_RPC_URL",
+		"rpc_fallback_urls_env": "BASE_RPC_FALLBACK_URLS", "block_time": "2s", "lag_blocks": 30}]`))
+	require.NoError(t, err)
+	base, err := registry.Get("base")
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, base.BlockTime)
+	require.Equal(t, uint64(30), base.Chain(config.Chain{LagBlocks: 9}).LagBlocks)
+	byID, err := registry.ByChainID(8453)
+	require.NoError(t, err)
+	require.Equal(t, base, byID)
+
+	encoded, err := json.Marshal(base)
+	require.NoError(t, err)
+	var decoded Network
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	require.Equal(t, base, decoded)
+
+	_, err = registry.Get("arbitrum")
+	require.ErrorIs(t, err, ErrUnknownNetwork)
+	require.Error(t, registry.Register(Network{Name: "base-copy", ChainID: 8453}))
+	require.Error(t, registry.Register(Network{Name: "no-chain-id"}))
+
+	t.Run("from env", func(t *testing.T) {
+		t.Setenv(NetworksEnv, `[{"name": "arbitrum", "chain_id": 42161, "block_time": "250ms"}]`)
+		require.NoError(t, registry.LoadFromEnv())
+		arbitrum, err := registry.Get("arbitrum")
+		require.NoError(t, err)
+		require.Equal(t, 250*time.Millisecond, arbitrum.BlockTime)
+
+		t.Setenv(NetworksEnv, `[{"name": "bad", "chain_id": 1234, "block_time": "fast"}]`)
+		require.Error(t, registry.LoadFromEnv())
+	})
+
+	t.Run("rpc urls", func(t *testing.T) {
+		t.Setenv("RPC_URL", "https://ignored.example")
+		t.Setenv("CORN_RPC_URL", "")
+		t.Setenv("BASE_RPC_URL", "https://base.example")
+		t.Setenv("BASE_RPC_FALLBACK_URLS", "https://base2.example, https://base3.example")
+		chain := &config.Chain{RPCURL: "https://eth.example", RPCFallbackURLs: []string{"https://eth2.example"}}
+		registry.LoadRPCURLs(chain)
+
+		// The loaded config takes precedence over the environment
+		ethereum, err := registry.Get(EthereumNetwork)
+		require.NoError(t, err)
+		ethURLs, err := ethereum.RPCURLs(chain)
+		require.NoError(t, err)
+		require.Equal(t, []string{"https://eth.example", "https://eth2.example"}, ethURLs)
+
+		baseURLs, err := base.RPCURLs(chain)
+		require.NoError(t, err)
+		require.Equal(t, []string{"https://base.example", "https://base2.example", "https://base3.example"}, baseURLs)
+
+		corn, err := registry.Get(CornMainnet)
+		require.NoError(t, err)
+		_, err = corn.RPCURLs(chain)
+		require.ErrorContains(t, err, "no RPC URL configured")
+
+		// The environment is only read when the URLs are loaded
+		t.Setenv("BASE_RPC_URL", "https://changed.example")
+		baseURLs, err = base.RPCURLs(chain)
+		require.NoError(t, err)
+		require.Equal(t, "https://base.example", baseURLs[0])
+	})
+}
+
+func Test_NetworkChain(t *testing.T) {
+	chain := &config.Chain{LagBlocks: 9}
+	require.Same(t, chain, NetworkChain("unregistered", chain))
+	require.Equal(t, chain, NetworkChain(EthereumNetwork, chain))
+}
+
+func Test_Network_CacheKey(t *testing.T) {
+	ethereum, err := Networks.Get(EthereumNetwork)
+	require.NoError(t, err)
+	require.Equal(t, "erc20::0xabc::decimals", ethereum.CacheKey("erc20", "0xabc", "decimals"))
+	corn, err := Networks.Get(CornMainnet)
+	require.NoError(t, err)
+	require.Equal(t, "erc20::corn-mainnet::0xabc::decimals", corn.CacheKey("erc20", "0xabc", "decimals"))
+}
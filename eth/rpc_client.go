@@ -0,0 +1,485 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/usecorn/common-lib/app"
+	"github.com/usecorn/common-lib/server/config"
+)
+
+// rpcLimitExceededCode is the JSON-RPC error code providers use for rate limited requests
+const rpcLimitExceededCode = -32005
+
+var (
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eth_rpc_requests_total",
+		Help: "Ethereum JSON-RPC requests by endpoint, method and status",
+	}, []string{"network", "endpoint", "method", "status"})
+	rpcRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eth_rpc_request_duration_seconds",
+		Help:    "Duration of Ethereum JSON-RPC requests by endpoint and method",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"network", "endpoint", "method"})
+)
+
+// RegisterRPCMetrics registers the metrics of MultiClients with reg, such as prometheus.DefaultRegisterer.
+// It should be called once per registry.
+func RegisterRPCMetrics(reg prometheus.Registerer) error {
+	if err := reg.Register(rpcRequests); err != nil {
+		return errors.Wrap(err, "failed to register RPC request metric")
+	}
+	if err := reg.Register(rpcRequestDuration); err != nil {
+		return errors.Wrap(err, "failed to register RPC duration metric")
+	}
+	return nil
+}
+
+// MultiClient is an Ethereum client for a network which spreads its requests over several RPC endpoints.
+// It can be used as the backend of contract bindings, an ERC20, a TransferIndexer and a BlockTimestampResolver.
+type MultiClient interface {
+	bind.ContractBackend
+	bind.DeployBackend
+	IndexerClient
+	BatchCaller
+	ChainID(ctx context.Context) (*big.Int, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	Close()
+}
+
+// RPCEndpoint is an RPC endpoint of a MultiClient
+type RPCEndpoint struct {
+	// Name identifies the endpoint in logs and metrics, it defaults to the host of the URL so that API keys in the
+	// path are not exposed
+	Name string
+	URL  string
+	// Limiter rate limits the requests to the endpoint, it defaults to config.Chain.RPCRateLimit per RPCRateDur
+	Limiter *app.MultiRateLimiter
+}
+
+type rpcEndpoint struct {
+	name    string
+	client  *ethclient.Client
+	limiter *app.MultiRateLimiter
+
+	mux            sync.Mutex
+	failures       int
+	unhealthyUntil time.Time
+}
+
+type multiClient struct {
+	log       logrus.Ext1FieldLogger
+	network   string
+	chain     *config.Chain
+	endpoints []*rpcEndpoint
+	sem       *semaphore.Weighted
+	now       func() time.Time
+}
+
+// NetworkRPCURLs returns the RPC endpoints of network in Networks from chain, the primary endpoint followed by
+// the fallbacks
+func NetworkRPCURLs(network string, chain *config.Chain) ([]string, error) {
+	n, err := Networks.Get(network)
+	if err != nil {
+		return nil, err
+	}
+	return n.RPCURLs(chain)
+}
+
+// DialNetwork creates a MultiClient for the RPC endpoints of network configured in chain, with the network's
+// overrides of chain applied
+func DialNetwork(ctx context.Context, log logrus.Ext1FieldLogger, network string, chain *config.Chain) (MultiClient, error) {
+	n, err := Networks.Get(network)
+	if err != nil {
+		return nil, err
+	}
+	urls, err := n.RPCURLs(chain)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]RPCEndpoint, len(urls))
+	for i := range urls {
+		endpoints[i] = RPCEndpoint{URL: urls[i]}
+	}
+	return NewMultiClient(ctx, log, network, n.Chain(*chain), endpoints...)
+}
+
+// NewMultiClient creates a MultiClient for network which sends each request to the first healthy endpoint, in the
+// order given. Requests are attempted up to chain.RPCMaxRetries times in total, retrying on another endpoint if
+// there is one, otherwise after a jittered exponential backoff from chain.RPCRetryDelay up to chain.RPCMaxRetryDelay. An endpoint
+// which fails chain.RPCUnhealthyThreshold times in a row is skipped for chain.RPCUnhealthyCooldown.
+func NewMultiClient(ctx context.Context, log logrus.Ext1FieldLogger, network string, chain *config.Chain,
+	endpoints ...RPCEndpoint) (MultiClient, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("at least one endpoint is required")
+	}
+	mc := &multiClient{
+		log:       log.WithField("network", network),
+		network:   network,
+		chain:     chain,
+		endpoints: make([]*rpcEndpoint, 0, len(endpoints)),
+		sem:       semaphore.NewWeighted(max(chain.RPCMaxConcurrency, 1)),
+		now:       time.Now,
+	}
+	for i, endpoint := range endpoints {
+		client, err := ethclient.DialContext(ctx, endpoint.URL)
+		if err != nil {
+			mc.Close()
+			return nil, errors.Wrapf(err, "failed to dial endpoint %d", i)
+		}
+		name := endpoint.Name
+		if name == "" {
+			name = endpointName(endpoint.URL, i)
+		}
+		limiter := endpoint.Limiter
+		if limiter == nil && chain.RPCRateLimit > 0 && chain.RPCRateDur > 0 {
+			limiter = app.NewMultiRateLimiter([]time.Duration{chain.RPCRateDur / time.Duration(chain.RPCRateLimit)},
+				[]int{chain.RPCRateLimit})
+		}
+		mc.endpoints = append(mc.endpoints, &rpcEndpoint{name: name, client: client, limiter: limiter})
+	}
+	return mc, nil
+}
+
+func endpointName(rpcURL string, i int) string {
+	u, err := url.Parse(rpcURL)
+	if err != nil || u.Host == "" {
+		return "endpoint-" + strconv.Itoa(i)
+	}
+	return u.Host
+}
+
+// IsRetryableRPCError returns true if retrying the request which failed with err may succeed, such as after network
+// errors, timeouts, rate limits and server errors. Errors returned by the node for the request itself, such as reverts,
+// missing blocks and rejected log ranges, are not retryable.
+func IsRetryableRPCError(err error) bool {
+	if err == nil || errors.Is(err, ethereum.NotFound) || errors.Is(err, context.Canceled) || IsLogRangeError(err) {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == 429 || httpErr.StatusCode >= 500
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.ErrorCode() == rpcLimitExceededCode
+	}
+	return true
+}
+
+func (ep *rpcEndpoint) healthy(now time.Time) bool {
+	ep.mux.Lock()
+	defer ep.mux.Unlock()
+	return !now.Before(ep.unhealthyUntil)
+}
+
+func (ep *rpcEndpoint) cooldownEnd() time.Time {
+	ep.mux.Lock()
+	defer ep.mux.Unlock()
+	return ep.unhealthyUntil
+}
+
+// record updates the health of the endpoint with the result of a request, returning true if it became unhealthy
+func (ep *rpcEndpoint) record(failed bool, now time.Time, chain *config.Chain) bool {
+	ep.mux.Lock()
+	defer ep.mux.Unlock()
+	if !failed {
+		ep.failures = 0
+		return false
+	}
+	ep.failures++
+	if ep.failures < max(chain.RPCUnhealthyThreshold, 1) {
+		return false
+	}
+	ep.failures = 0
+	ep.unhealthyUntil = now.Add(chain.RPCUnhealthyCooldown)
+	return true
+}
+
+// pick returns the first healthy endpoint other than last, the last failed endpoint, falling back to last if it is
+// the only healthy one. When none are healthy, the one whose cooldown ends first is used.
+func (mc *multiClient) pick(last *rpcEndpoint) *rpcEndpoint {
+	now := mc.now()
+	var fallback *rpcEndpoint
+	for _, ep := range mc.endpoints {
+		if !ep.healthy(now) {
+			continue
+		}
+		if ep != last {
+			return ep
+		}
+		fallback = ep
+	}
+	if fallback != nil {
+		return fallback
+	}
+	soonest := mc.endpoints[0]
+	for _, ep := range mc.endpoints[1:] {
+		if ep.cooldownEnd().Before(soonest.cooldownEnd()) {
+			soonest = ep
+		}
+	}
+	return soonest
+}
+
+// backoff returns the jittered delay before the attempt after attempt, between half and all of the exponential delay
+func (mc *multiClient) backoff(attempt int) time.Duration {
+	delay := mc.chain.RPCRetryDelay
+	maxDelay := mc.chain.RPCMaxRetryDelay
+	if maxDelay <= 0 {
+		maxDelay = delay
+	}
+	for range attempt {
+		if delay >= maxDelay/2 {
+			delay = maxDelay
+			break
+		}
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// attempt sends a single request to ep, within the concurrency, rate and time limits
+func attempt[T any](ctx context.Context, mc *multiClient, ep *rpcEndpoint, method string,
+	fn func(ctx context.Context, client *ethclient.Client) (T, error)) (T, error) {
+	var zero T
+	if err := mc.sem.Acquire(ctx, 1); err != nil {
+		return zero, err
+	}
+	defer mc.sem.Release(1)
+	if ep.limiter != nil {
+		if err := ep.limiter.Wait(ctx); err != nil {
+			return zero, err
+		}
+	}
+	if mc.chain.RPCTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mc.chain.RPCTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	out, err := fn(ctx, ep.client)
+	rpcRequestDuration.WithLabelValues(mc.network, ep.name, method).Observe(time.Since(start).Seconds())
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	rpcRequests.WithLabelValues(mc.network, ep.name, method, status).Inc()
+	return out, err
+}
+
+// call sends a request with fn, retrying and failing over to other endpoints as configured
+func call[T any](ctx context.Context, mc *multiClient, method string,
+	fn func(ctx context.Context, client *ethclient.Client) (T, error)) (T, error) {
+	var (
+		out  T
+		err  error
+		last *rpcEndpoint
+	)
+	attempts := max(mc.chain.RPCMaxRetries, 1)
+	for i := range attempts {
+		ep := mc.pick(last)
+		if ep == last && i > 0 {
+			if sleepErr := app.SleepContext(ctx, mc.backoff(i-1)); sleepErr != nil {
+				return out, errors.Wrapf(sleepErr, "%s interrupted", method)
+			}
+		}
+
+		out, err = attempt(ctx, mc, ep, method, fn)
+		if ctx.Err() != nil {
+			return out, errors.Wrapf(ctx.Err(), "%s failed", method)
+		}
+		retryable := IsRetryableRPCError(err)
+		if ep.record(retryable, mc.now(), mc.chain) {
+			mc.log.WithField("endpoint", ep.name).WithError(err).Warn("RPC endpoint is unhealthy")
+		}
+		if !retryable {
+			return out, err
+		}
+		mc.log.WithFields(logrus.Fields{"endpoint": ep.name, "method": method, "attempt": i + 1}).
+			WithError(err).Debug("RPC request failed")
+		last = ep
+	}
+	return out, errors.Wrapf(err, "%s failed after %d attempts", method, attempts)
+}
+
+// callErr is call for requests which only return an error
+func callErr(ctx context.Context, mc *multiClient, method string, fn func(ctx context.Context, client *ethclient.Client) error) error {
+	_, err := call(ctx, mc, method, func(ctx context.Context, client *ethclient.Client) (struct{}, error) {
+		return struct{}{}, fn(ctx, client)
+	})
+	return err
+}
+
+func (mc *multiClient) Close() {
+	for _, ep := range mc.endpoints {
+		ep.client.Close()
+	}
+}
+
+func (mc *multiClient) BlockNumber(ctx context.Context) (uint64, error) {
+	return call(ctx, mc, "eth_blockNumber", func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.BlockNumber(ctx)
+	})
+}
+
+func (mc *multiClient) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	return call(ctx, mc, "eth_getBlockByNumber", func(ctx context.Context, client *ethclient.Client) (*types.Block, error) {
+		return client.BlockByNumber(ctx, number)
+	})
+}
+
+func (mc *multiClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return call(ctx, mc, "eth_getBlockByNumber", func(ctx context.Context, client *ethclient.Client) (*types.Header, error) {
+		return client.HeaderByNumber(ctx, number)
+	})
+}
+
+func (mc *multiClient) ChainID(ctx context.Context) (*big.Int, error) {
+	return call(ctx, mc, "eth_chainId", func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.ChainID(ctx)
+	})
+}
+
+func (mc *multiClient) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return call(ctx, mc, "eth_getBalance", func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.BalanceAt(ctx, account, blockNumber)
+	})
+}
+
+func (mc *multiClient) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return call(ctx, mc, "eth_getTransactionCount", func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.NonceAt(ctx, account, blockNumber)
+	})
+}
+
+func (mc *multiClient) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return call(ctx, mc, "eth_getCode", func(ctx context.Context, client *ethclient.Client) ([]byte, error) {
+		return client.CodeAt(ctx, contract, blockNumber)
+	})
+}
+
+func (mc *multiClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return call(ctx, mc, "eth_call", func(ctx context.Context, client *ethclient.Client) ([]byte, error) {
+		return client.CallContract(ctx, msg, blockNumber)
+	})
+}
+
+func (mc *multiClient) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	return call(ctx, mc, "eth_getCode", func(ctx context.Context, client *ethclient.Client) ([]byte, error) {
+		return client.PendingCodeAt(ctx, account)
+	})
+}
+
+func (mc *multiClient) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return call(ctx, mc, "eth_getTransactionCount", func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.PendingNonceAt(ctx, account)
+	})
+}
+
+func (mc *multiClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return call(ctx, mc, "eth_gasPrice", func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.SuggestGasPrice(ctx)
+	})
+}
+
+func (mc *multiClient) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return call(ctx, mc, "eth_maxPriorityFeePerGas", func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.SuggestGasTipCap(ctx)
+	})
+}
+
+func (mc *multiClient) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	return call(ctx, mc, "eth_estimateGas", func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.EstimateGas(ctx, msg)
+	})
+}
+
+// SendTransaction sends a signed transaction. As an attempt which failed, such as by timing out, may still have
+// reached the node, a transaction which is already known is treated as sent, and so is a nonce too low error on a
+// retry if the transaction has been mined.
+func (mc *multiClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	retry := false
+	return callErr(ctx, mc, "eth_sendRawTransaction", func(ctx context.Context, client *ethclient.Client) error {
+		err := client.SendTransaction(ctx, tx)
+		wasRetry := retry
+		retry = true
+		switch {
+		case err == nil || isAlreadyKnownError(err):
+			return nil
+		case wasRetry && isNonceTooLowError(err):
+			if _, receiptErr := client.TransactionReceipt(ctx, tx.Hash()); receiptErr == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// isAlreadyKnownError returns true if err is a node rejecting a transaction which is already in its pool
+func isAlreadyKnownError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "already known") || strings.Contains(msg, "alreadyknown") ||
+		strings.Contains(msg, "known transaction") || strings.Contains(msg, "already imported")
+}
+
+// isNonceTooLowError returns true if err is a node rejecting a transaction whose nonce has already been used
+func isNonceTooLowError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "nonce too low")
+}
+
+func (mc *multiClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	return call(ctx, mc, "eth_getTransactionReceipt", func(ctx context.Context, client *ethclient.Client) (*types.Receipt, error) {
+		return client.TransactionReceipt(ctx, txHash)
+	})
+}
+
+func (mc *multiClient) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	return call(ctx, mc, "eth_getLogs", func(ctx context.Context, client *ethclient.Client) ([]types.Log, error) {
+		return client.FilterLogs(ctx, query)
+	})
+}
+
+// SubscribeFilterLogs subscribes on the first healthy endpoint without retries, as the subscription outlives the
+// request. It requires a websocket or IPC endpoint.
+func (mc *multiClient) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	ep := mc.pick(nil)
+	sub, err := ep.client.SubscribeFilterLogs(ctx, query, ch)
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	rpcRequests.WithLabelValues(mc.network, ep.name, "eth_subscribe", status).Inc()
+	return sub, err
+}
+
+// BatchCallContext sends a batch request, which is retried when the whole batch fails. The errors of the
+// individual requests are set in their BatchElem and are not retried.
+func (mc *multiClient) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	return callErr(ctx, mc, "batch", func(ctx context.Context, client *ethclient.Client) error {
+		return client.Client().BatchCallContext(ctx, b)
+	})
+}
@@ -0,0 +1,272 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usecorn/common-lib/server/config"
+)
+
+// fakeRPCServer is a JSON-RPC endpoint which responds with status until it has failed failures times,
+// then with result, or rpcErr if it is set
+type fakeRPCServer struct {
+	*httptest.Server
+	mux      sync.Mutex
+	requests int
+	failures int
+	status   int
+	result   any
+	rpcErr   map[string]any
+	// delay is how long the failed requests take to respond
+	delay time.Duration
+	// results are the results of methods which take precedence over result and rpcErr
+	results map[string]any
+}
+
+func newFakeRPCServer(t *testing.T, failures int, status int, result any) *fakeRPCServer {
+	frs := &fakeRPCServer{failures: failures, status: status, result: result}
+	frs.Server = httptest.NewServer(http.HandlerFunc(frs.serve))
+	t.Cleanup(frs.Close)
+	return frs
+}
+
+func (frs *fakeRPCServer) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	frs.mux.Lock()
+	frs.requests++
+	fail := frs.requests <= frs.failures
+	frs.mux.Unlock()
+	if fail {
+		select {
+		case <-time.After(frs.delay):
+		case <-r.Context().Done():
+			return
+		}
+		http.Error(w, "unavailable", frs.status)
+		return
+	}
+	resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	if result, ok := frs.results[req.Method]; ok {
+		resp["result"] = result
+	} else if frs.rpcErr != nil {
+		resp["error"] = frs.rpcErr
+	} else {
+		resp["result"] = frs.result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (frs *fakeRPCServer) count() int {
+	frs.mux.Lock()
+	defer frs.mux.Unlock()
+	return frs.requests
+}
+
+func requestCount(t *testing.T, endpoint, method, status string) float64 {
+	var m dto.Metric
+	require.NoError(t, rpcRequests.WithLabelValues("test", endpoint, method, status).(prometheus.Metric).Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func testChain() *config.Chain {
+	return &config.Chain{
+		RPCTimeout:            time.Second,
+		RPCMaxConcurrency:     2,
+		RPCMaxRetries:         3,
+		RPCRetryDelay:         time.Millisecond,
+		RPCMaxRetryDelay:      5 * time.Millisecond,
+		RPCUnhealthyThreshold: 2,
+		RPCUnhealthyCooldown:  time.Hour,
+	}
+}
+
+func Test_MultiClient_Failover(t *testing.T) {
+	ctx := context.Background()
+	primary := newFakeRPCServer(t, 100, http.StatusServiceUnavailable, "0x1")
+	secondary := newFakeRPCServer(t, 0, 0, "0x10")
+	client, err := NewMultiClient(ctx, logrus.New(), "test", testChain(),
+		RPCEndpoint{Name: "primary", URL: primary.URL}, RPCEndpoint{Name: "secondary", URL: secondary.URL})
+	require.NoError(t, err)
+	defer client.Close()
+
+	for range 3 {
+		block, err := client.BlockNumber(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint64(16), block)
+	}
+	// The primary is skipped once it has failed twice in a row
+	require.Equal(t, 2, primary.count())
+	require.Equal(t, 3, secondary.count())
+	require.Equal(t, float64(2), requestCount(t, "primary", "eth_blockNumber", "error"))
+	require.Equal(t, float64(3), requestCount(t, "secondary", "eth_blockNumber", "ok"))
+}
+
+func Test_MultiClient_Retry(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeRPCServer(t, 2, http.StatusTooManyRequests, "0x5")
+	client, err := NewMultiClient(ctx, logrus.New(), "test", testChain(), RPCEndpoint{URL: server.URL})
+	require.NoError(t, err)
+	defer client.Close()
+
+	block, err := client.BlockNumber(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), block)
+	require.Equal(t, 3, server.count())
+
+	t.Run("gives up after max retries", func(t *testing.T) {
+		server := newFakeRPCServer(t, 100, http.StatusBadGateway, "0x5")
+		client, err := NewMultiClient(ctx, logrus.New(), "test", testChain(), RPCEndpoint{URL: server.URL})
+		require.NoError(t, err)
+		defer client.Close()
+		_, err = client.BlockNumber(ctx)
+		require.ErrorContains(t, err, "failed after 3 attempts")
+		require.Equal(t, 3, server.count())
+	})
+
+	t.Run("node errors are not retried", func(t *testing.T) {
+		server := newFakeRPCServer(t, 0, 0, nil)
+		server.rpcErr = map[string]any{"code": 3, "message": "execution reverted"}
+		other := newFakeRPCServer(t, 0, 0, "0x")
+		client, err := NewMultiClient(ctx, logrus.New(), "test", testChain(),
+			RPCEndpoint{URL: server.URL}, RPCEndpoint{URL: other.URL})
+		require.NoError(t, err)
+		defer client.Close()
+		_, err = client.CallContract(ctx, ethereum.CallMsg{}, nil)
+		require.ErrorContains(t, err, "execution reverted")
+		require.Equal(t, 1, server.count())
+		require.Equal(t, 0, other.count())
+	})
+}
+
+func Test_MultiClient_Timeout(t *testing.T) {
+	ctx := context.Background()
+	chain := testChain()
+	chain.RPCTimeout = 50 * time.Millisecond
+
+	t.Run("attempt deadline is retried", func(t *testing.T) {
+		server := newFakeRPCServer(t, 1, http.StatusServiceUnavailable, "0x5")
+		server.delay = time.Second
+		client, err := NewMultiClient(ctx, logrus.New(), "test", chain, RPCEndpoint{URL: server.URL})
+		require.NoError(t, err)
+		defer client.Close()
+
+		block, err := client.BlockNumber(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), block)
+		require.Equal(t, 2, server.count())
+	})
+
+	t.Run("cancelled parent is not retried", func(t *testing.T) {
+		server := newFakeRPCServer(t, 100, http.StatusServiceUnavailable, "0x5")
+		server.delay = time.Second
+		client, err := NewMultiClient(ctx, logrus.New(), "test", testChain(), RPCEndpoint{URL: server.URL})
+		require.NoError(t, err)
+		defer client.Close()
+
+		ctx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(20*time.Millisecond, cancel)
+		_, err = client.BlockNumber(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 1, server.count())
+	})
+
+	t.Run("cancelled during backoff", func(t *testing.T) {
+		server := newFakeRPCServer(t, 100, http.StatusServiceUnavailable, "0x5")
+		chain := testChain()
+		chain.RPCRetryDelay = time.Hour
+		chain.RPCMaxRetryDelay = time.Hour
+		client, err := NewMultiClient(ctx, logrus.New(), "test", chain, RPCEndpoint{URL: server.URL})
+		require.NoError(t, err)
+		defer client.Close()
+
+		ctx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(20*time.Millisecond, cancel)
+		_, err = client.BlockNumber(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 1, server.count())
+	})
+}
+
+func Test_MultiClient_SendTransaction(t *testing.T) {
+	ctx := context.Background()
+	tx := types.NewTx(&types.LegacyTx{Nonce: 1, Gas: 21000, GasPrice: big.NewInt(1)})
+
+	t.Run("already known", func(t *testing.T) {
+		server := newFakeRPCServer(t, 0, 0, nil)
+		server.rpcErr = map[string]any{"code": -32000, "message": "already known"}
+		client, err := NewMultiClient(ctx, logrus.New(), "test", testChain(), RPCEndpoint{URL: server.URL})
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, client.SendTransaction(ctx, tx))
+	})
+
+	t.Run("mined after a failed attempt", func(t *testing.T) {
+		receipt, err := (&types.Receipt{Status: types.ReceiptStatusSuccessful, Logs: []*types.Log{}, TxHash: tx.Hash()}).MarshalJSON()
+		require.NoError(t, err)
+		server := newFakeRPCServer(t, 1, http.StatusGatewayTimeout, nil)
+		server.rpcErr = map[string]any{"code": -32000, "message": "nonce too low"}
+		server.results = map[string]any{"eth_getTransactionReceipt": json.RawMessage(receipt)}
+		client, err := NewMultiClient(ctx, logrus.New(), "test", testChain(), RPCEndpoint{URL: server.URL})
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, client.SendTransaction(ctx, tx))
+		require.Equal(t, 3, server.count())
+	})
+
+	t.Run("nonce too low", func(t *testing.T) {
+		server := newFakeRPCServer(t, 0, 0, nil)
+		server.rpcErr = map[string]any{"code": -32000, "message": "nonce too low"}
+		client, err := NewMultiClient(ctx, logrus.New(), "test", testChain(), RPCEndpoint{URL: server.URL})
+		require.NoError(t, err)
+		defer client.Close()
+		require.ErrorContains(t, client.SendTransaction(ctx, tx), "nonce too low")
+		require.Equal(t, 1, server.count())
+	})
+}
+
+func Test_IsRetryableRPCError(t *testing.T) {
+	require.True(t, IsRetryableRPCError(errors.New("connection reset by peer")))
+	require.True(t, IsRetryableRPCError(context.DeadlineExceeded))
+	require.True(t, IsRetryableRPCError(rpc.HTTPError{StatusCode: http.StatusTooManyRequests}))
+	require.True(t, IsRetryableRPCError(errors.Wrap(rpc.HTTPError{StatusCode: http.StatusInternalServerError}, "failed")))
+	require.False(t, IsRetryableRPCError(rpc.HTTPError{StatusCode: http.StatusUnauthorized}))
+	require.False(t, IsRetryableRPCError(ethereum.NotFound))
+	require.False(t, IsRetryableRPCError(context.Canceled))
+	require.False(t, IsRetryableRPCError(errors.New("query returned more than 10000 results")))
+	require.False(t, IsRetryableRPCError(nil))
+}
+
+func Test_MultiClient_Backoff(t *testing.T) {
+	mc := &multiClient{chain: &config.Chain{RPCRetryDelay: 100 * time.Millisecond, RPCMaxRetryDelay: time.Second}}
+	for attempt, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second, time.Second} {
+		for range 10 {
+			delay := mc.backoff(attempt)
+			require.GreaterOrEqual(t, delay, expected/2)
+			require.LessOrEqual(t, delay, expected)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// ERC1271ABI is the ABI of the ERC-1271 isValidSignature function, used to verify contract wallet signatures
+const ERC1271ABI = `[{"inputs":[{"internalType":"bytes32","name":"hash","type":"bytes32"},{"internalType":"bytes","name":"signature","type":"bytes"}],"name":"isValidSignature","outputs":[{"internalType":"bytes4","name":"magicValue","type":"bytes4"}],"stateMutability":"view","type":"function"}]`
+
+// ERC1271MagicValue is returned by isValidSignature when the signature is valid, bytes4(keccak256("isValidSignature(bytes32,bytes)"))
+var ERC1271MagicValue = [4]byte{0x16, 0x26, 0xba, 0x7e}
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrSignerMismatch   = errors.New("signature was not signed by the expected signer")
+)
+
+var erc1271ABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(ERC1271ABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// DecodeSignature decodes a 0x prefixed hex signature, as returned by wallets.
+func DecodeSignature(signature string) ([]byte, error) {
+	out, err := hexutil.Decode(signature)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode signature")
+	}
+	return out, nil
+}
+
+// PersonalSignHash returns the EIP-191 (version 0x45) hash of a message, as signed by personal_sign.
+func PersonalSignHash(message []byte) common.Hash {
+	return common.BytesToHash(accounts.TextHash(message))
+}
+
+// TypedDataHash returns the EIP-712 hash of typed data, as signed by eth_signTypedData_v4.
+func TypedDataHash(typedData apitypes.TypedData) (common.Hash, error) {
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return common.Hash{}, errors.Wrap(err, "could not hash typed data")
+	}
+	return common.BytesToHash(hash), nil
+}
+
+// RecoverSigner recovers the address which signed the hash. Accepts signatures with a recovery id of 0/1 or 27/28.
+func RecoverSigner(hash common.Hash, signature []byte) (common.Address, error) {
+	if len(signature) != crypto.SignatureLength {
+		return common.Address{}, errors.Wrapf(ErrInvalidSignature, "invalid signature length: %d instead of %d", len(signature), crypto.SignatureLength)
+	}
+	sig := make([]byte, crypto.SignatureLength)
+	copy(sig, signature)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	if sig[crypto.RecoveryIDOffset] > 1 {
+		return common.Address{}, errors.Wrapf(ErrInvalidSignature, "invalid recovery id: %d", signature[crypto.RecoveryIDOffset])
+	}
+
+	pubKey, err := crypto.SigToPub(hash.Bytes(), sig)
+	if err != nil {
+		return common.Address{}, errors.WithSecondaryError(ErrInvalidSignature, err)
+	}
+	return crypto.PubkeyToAddress(*pubKey), nil
+}
+
+// RecoverPersonalSign recovers the address which signed the message with personal_sign (EIP-191).
+func RecoverPersonalSign(message []byte, signature []byte) (common.Address, error) {
+	return RecoverSigner(PersonalSignHash(message), signature)
+}
+
+// RecoverTypedData recovers the address which signed the EIP-712 typed data.
+func RecoverTypedData(typedData apitypes.TypedData, signature []byte) (common.Address, error) {
+	hash, err := TypedDataHash(typedData)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return RecoverSigner(hash, signature)
+}
+
+// SignatureVerifier verifies EVM wallet signatures, for both externally owned accounts and ERC-1271 contract wallets.
+type SignatureVerifier interface {
+	// VerifyHash verifies that signer signed the given hash.
+	VerifyHash(ctx context.Context, signer common.Address, hash common.Hash, signature []byte) error
+	// VerifyPersonalSign verifies that signer signed the message with personal_sign (EIP-191).
+	VerifyPersonalSign(ctx context.Context, signer common.Address, message []byte, signature []byte) error
+	// VerifyTypedData verifies that signer signed the EIP-712 typed data.
+	VerifyTypedData(ctx context.Context, signer common.Address, typedData apitypes.TypedData, signature []byte) error
+}
+
+type signatureVerifier struct {
+	caller bind.ContractCaller
+}
+
+// NewSignatureVerifier creates a new SignatureVerifier. If caller is nil, only signatures from externally owned accounts
+// can be verified, otherwise signers with contract code are verified with ERC-1271 isValidSignature through caller.
+func NewSignatureVerifier(caller bind.ContractCaller) SignatureVerifier {
+	return &signatureVerifier{caller: caller}
+}
+
+func (sv *signatureVerifier) VerifyHash(ctx context.Context, signer common.Address, hash common.Hash, signature []byte) error {
+	recovered, recoverErr := RecoverSigner(hash, signature)
+	if recoverErr == nil && recovered == signer {
+		return nil
+	}
+	if sv.caller == nil {
+		if recoverErr != nil {
+			return recoverErr
+		}
+		return errors.Wrapf(ErrSignerMismatch, "expected %s, recovered %s", signer.Hex(), recovered.Hex())
+	}
+
+	code, err := sv.caller.CodeAt(ctx, signer, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get code at %s", signer.Hex())
+	}
+	if len(code) == 0 { // Not a contract wallet
+		if recoverErr != nil {
+			return recoverErr
+		}
+		return errors.Wrapf(ErrSignerMismatch, "expected %s, recovered %s", signer.Hex(), recovered.Hex())
+	}
+	return sv.verifyERC1271(ctx, signer, hash, signature)
+}
+
+func (sv *signatureVerifier) verifyERC1271(ctx context.Context, signer common.Address, hash common.Hash, signature []byte) error {
+	contract := bind.NewBoundContract(signer, erc1271ABI, sv.caller, nil, nil)
+	var out []interface{}
+	err := contract.Call(&bind.CallOpts{Context: ctx}, &out, "isValidSignature", hash, signature)
+	if err != nil {
+		return errors.WithSecondaryError(ErrInvalidSignature, errors.Wrapf(err, "isValidSignature call to %s failed", signer.Hex()))
+	}
+	if len(out) != 1 {
+		return errors.Wrapf(ErrInvalidSignature, "unexpected isValidSignature result from %s", signer.Hex())
+	}
+	magicValue, ok := out[0].([4]byte)
+	if !ok || !bytes.Equal(magicValue[:], ERC1271MagicValue[:]) {
+		return errors.Wrapf(ErrInvalidSignature, "contract wallet %s rejected the signature", signer.Hex())
+	}
+	return nil
+}
+
+func (sv *signatureVerifier) VerifyPersonalSign(ctx context.Context, signer common.Address, message []byte, signature []byte) error {
+	return sv.VerifyHash(ctx, signer, PersonalSignHash(message), signature)
+}
+
+func (sv *signatureVerifier) VerifyTypedData(ctx context.Context, signer common.Address, typedData apitypes.TypedData, signature []byte) error {
+	hash, err := TypedDataHash(typedData)
+	if err != nil {
+		return err
+	}
+	return sv.VerifyHash(ctx, signer, hash, signature)
+}
@@ -0,0 +1,106 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/require"
+)
+
+// Runtime bytecode of contracts which return a fixed bytes4 value for any call, used as ERC-1271 wallets.
+var (
+	// PUSH4 0x1626ba7e PUSH1 0xe0 SHL PUSH1 0 MSTORE PUSH1 0x20 PUSH1 0 RETURN
+	alwaysValidWallet = common.FromHex("0x631626ba7e60e01b60005260206000f3")
+	// PUSH4 0xffffffff PUSH1 0xe0 SHL PUSH1 0 MSTORE PUSH1 0x20 PUSH1 0 RETURN
+	alwaysInvalidWallet = common.FromHex("0x63ffffffff60e01b60005260206000f3")
+)
+
+func testTypedData() apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+			},
+			"Login": {
+				{Name: "wallet", Type: "address"},
+				{Name: "nonce", Type: "string"},
+			},
+		},
+		PrimaryType: "Login",
+		Domain: apitypes.TypedDataDomain{
+			Name:    "corn",
+			Version: "1",
+			ChainId: math.NewHexOrDecimal256(CornMainnetChainID),
+		},
+		Message: apitypes.TypedDataMessage{
+			"wallet": "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			"nonce":  "abc123",
+		},
+	}
+}
+
+func Test_SignatureVerifier(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := crypto.PubkeyToAddress(key.PublicKey)
+
+	validWallet := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	invalidWallet := common.HexToAddress("0x1000000000000000000000000000000000000002")
+
+	backend := simulated.NewBackend(types.GenesisAlloc{
+		signer:        {Balance: big.NewInt(1)},
+		validWallet:   {Code: alwaysValidWallet, Balance: big.NewInt(0)},
+		invalidWallet: {Code: alwaysInvalidWallet, Balance: big.NewInt(0)},
+	})
+	defer backend.Close()
+	verifier := NewSignatureVerifier(backend.Client())
+
+	message := []byte("Sign in to corn")
+	sig, err := crypto.Sign(PersonalSignHash(message).Bytes(), key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27 // wallets return a v of 27/28
+
+	t.Run("personal sign", func(t *testing.T) {
+		recovered, err := RecoverPersonalSign(message, sig)
+		require.NoError(t, err)
+		require.Equal(t, signer, recovered)
+
+		require.NoError(t, verifier.VerifyPersonalSign(ctx, signer, message, sig))
+		require.ErrorIs(t, verifier.VerifyPersonalSign(ctx, signer, []byte("something else"), sig), ErrSignerMismatch)
+		require.ErrorIs(t, verifier.VerifyPersonalSign(ctx, signer, message, sig[:64]), ErrInvalidSignature)
+	})
+
+	t.Run("typed data", func(t *testing.T) {
+		hash, err := TypedDataHash(testTypedData())
+		require.NoError(t, err)
+		typedSig, err := crypto.Sign(hash.Bytes(), key)
+		require.NoError(t, err)
+
+		recovered, err := RecoverTypedData(testTypedData(), typedSig)
+		require.NoError(t, err)
+		require.Equal(t, signer, recovered)
+		require.NoError(t, verifier.VerifyTypedData(ctx, signer, testTypedData(), typedSig))
+
+		modified := testTypedData()
+		modified.Message["nonce"] = "def456"
+		require.ErrorIs(t, verifier.VerifyTypedData(ctx, signer, modified, typedSig), ErrSignerMismatch)
+	})
+
+	t.Run("erc1271", func(t *testing.T) {
+		require.NoError(t, verifier.VerifyPersonalSign(ctx, validWallet, message, sig))
+		require.ErrorIs(t, verifier.VerifyPersonalSign(ctx, invalidWallet, message, sig), ErrInvalidSignature)
+
+		// Without a contract caller, contract wallets cannot be verified
+		require.ErrorIs(t, NewSignatureVerifier(nil).VerifyPersonalSign(ctx, validWallet, message, sig), ErrSignerMismatch)
+	})
+}
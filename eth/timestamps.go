@@ -0,0 +1,266 @@
+package eth
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/usecorn/common-lib/dbutils"
+	"github.com/usecorn/common-lib/server/config"
+)
+
+// ErrBeforeGenesis is returned when looking up the block for a timestamp before the first block
+var ErrBeforeGenesis = errors.New("timestamp is before the genesis block")
+
+// BatchCaller sends JSON-RPC batch requests, it is implemented by *rpc.Client, such as from ethclient.Client.Client()
+type BatchCaller interface {
+	BatchCallContext(ctx context.Context, b []rpc.BatchElem) error
+}
+
+// BlockTimestampResolver gets the timestamps of blocks, in unix seconds
+type BlockTimestampResolver interface {
+	Timestamp(ctx context.Context, block uint64) (uint64, error)
+	// Timestamps gets the timestamps of many blocks, fetching the headers of those which are not cached in batches.
+	// Only the timestamps of blocks at least LagBlocks behind the head are cached, as later blocks may be reorged.
+	Timestamps(ctx context.Context, blocks []uint64) (map[uint64]uint64, error)
+	// BlockAtTimestamp returns the last block with a timestamp at or before ts, by binary search over the blocks up to the head
+	BlockAtTimestamp(ctx context.Context, ts uint64) (uint64, error)
+}
+
+// TimestampResolverConfig configures a BlockTimestampResolver
+type TimestampResolverConfig struct {
+	// BatchSize is the number of headers requested in each JSON-RPC batch
+	BatchSize int `env:"TIMESTAMP_BATCH_SIZE" env-default:"100"`
+	// Concurrency is the maximum number of batches requested at once, such as config.Chain.RPCMaxConcurrency
+	Concurrency int `env:"TIMESTAMP_CONCURRENCY" env-default:"5"`
+	// CacheSize is the number of timestamps kept in memory
+	CacheSize int `env:"TIMESTAMP_CACHE_SIZE" env-default:"100000"`
+	// LagBlocks is how far behind the head a block must be for its timestamp to be cached, such as config.Chain.LagBlocks
+	LagBlocks uint64 `env:"TIMESTAMP_LAG_BLOCKS" env-default:"9"`
+}
+
+// NewTimestampResolverConfig returns the default TimestampResolverConfig for chain, which requests up to
+// chain.RPCMaxConcurrency batches at once and caches the timestamps of blocks chain.LagBlocks behind the head
+func NewTimestampResolverConfig(chain *config.Chain) TimestampResolverConfig {
+	return TimestampResolverConfig{
+		BatchSize:   100,
+		Concurrency: max(int(chain.RPCMaxConcurrency), 1),
+		CacheSize:   100000,
+		LagBlocks:   chain.LagBlocks,
+	}
+}
+
+type blockTimestampResolver struct {
+	client  BatchCaller
+	meta    dbutils.MetaDB
+	network string
+	conf    TimestampResolverConfig
+	cache   *lru.Cache[uint64, uint64]
+}
+
+// NewBlockTimestampResolver creates a BlockTimestampResolver for network, which caches the timestamps in memory, and
+// in meta if it is not nil, so that they persist across restarts
+func NewBlockTimestampResolver(client BatchCaller, meta dbutils.MetaDB, network string, conf TimestampResolverConfig) (BlockTimestampResolver, error) {
+	if conf.BatchSize <= 0 || conf.Concurrency <= 0 || conf.CacheSize <= 0 {
+		return nil, errors.New("batch size, concurrency and cache size must be positive")
+	}
+	return &blockTimestampResolver{
+		client:  client,
+		meta:    meta,
+		network: network,
+		conf:    conf,
+		cache:   lru.NewCache[uint64, uint64](conf.CacheSize),
+	}, nil
+}
+
+func (btr *blockTimestampResolver) metaKey(block uint64) string {
+	return "block_timestamp" + dbutils.NamespaceSeparator + btr.network + dbutils.NamespaceSeparator + strconv.FormatUint(block, 10)
+}
+
+func (btr *blockTimestampResolver) Timestamp(ctx context.Context, block uint64) (uint64, error) {
+	timestamps, err := btr.Timestamps(ctx, []uint64{block})
+	if err != nil {
+		return 0, err
+	}
+	return timestamps[block], nil
+}
+
+func (btr *blockTimestampResolver) Timestamps(ctx context.Context, blocks []uint64) (map[uint64]uint64, error) {
+	out := make(map[uint64]uint64, len(blocks))
+	var uncached []uint64
+	for _, block := range blocks {
+		if _, ok := out[block]; ok {
+			continue
+		}
+		if ts, ok := btr.cache.Get(block); ok {
+			out[block] = ts
+			continue
+		}
+		out[block] = 0
+		uncached = append(uncached, block)
+	}
+	missing, err := btr.loadStored(ctx, uncached, out)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	var safeHead uint64
+	fetched := make([]uint64, len(missing))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(btr.conf.Concurrency)
+	group.Go(func() (err error) {
+		safeHead, err = btr.safeHead(groupCtx)
+		return err
+	})
+	for start := 0; start < len(missing); start += btr.conf.BatchSize {
+		end := min(start+btr.conf.BatchSize, len(missing))
+		group.Go(func() error {
+			return btr.fetchBatch(groupCtx, missing[start:end], fetched[start:end])
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	stored := make(map[string]any, len(missing))
+	for i, block := range missing {
+		out[block] = fetched[i]
+		if block > safeHead {
+			continue
+		}
+		btr.cache.Add(block, fetched[i])
+		stored[btr.metaKey(block)] = fetched[i]
+	}
+	if btr.meta != nil && len(stored) != 0 {
+		if err := btr.meta.SetMany(ctx, stored); err != nil {
+			return nil, errors.Wrap(err, "failed to cache block timestamps")
+		}
+	}
+	return out, nil
+}
+
+// loadStored gets the timestamps of blocks from the MetaDB in one query, storing them in out, and returns the blocks
+// which are not stored
+func (btr *blockTimestampResolver) loadStored(ctx context.Context, blocks []uint64, out map[uint64]uint64) ([]uint64, error) {
+	if btr.meta == nil || len(blocks) == 0 {
+		return blocks, nil
+	}
+	keys := make([]string, len(blocks))
+	for i, block := range blocks {
+		keys[i] = btr.metaKey(block)
+	}
+	vals, err := btr.meta.GetMany(ctx, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get cached block timestamps")
+	}
+	var missing []uint64
+	for i, block := range blocks {
+		rawVal, ok := vals[keys[i]]
+		if !ok {
+			missing = append(missing, block)
+			continue
+		}
+		ts, err := strconv.ParseUint(rawVal, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cached timestamp of block %d", block)
+		}
+		btr.cache.Add(block, ts)
+		out[block] = ts
+	}
+	return missing, nil
+}
+
+// safeHead returns the last block which is LagBlocks behind the head, whose timestamp can be cached
+func (btr *blockTimestampResolver) safeHead(ctx context.Context) (uint64, error) {
+	if btr.conf.LagBlocks == 0 {
+		return math.MaxUint64, nil
+	}
+	head, err := btr.head(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if head < btr.conf.LagBlocks {
+		return 0, nil
+	}
+	return head - btr.conf.LagBlocks, nil
+}
+
+// fetchBatch gets the timestamps of blocks in a single batch request, storing them in out
+func (btr *blockTimestampResolver) fetchBatch(ctx context.Context, blocks []uint64, out []uint64) error {
+	type header struct {
+		Timestamp hexutil.Uint64 `json:"timestamp"`
+	}
+	headers := make([]*header, len(blocks))
+	batch := make([]rpc.BatchElem, len(blocks))
+	for i, block := range blocks {
+		batch[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []any{hexutil.EncodeUint64(block), false},
+			Result: &headers[i],
+		}
+	}
+	if err := btr.client.BatchCallContext(ctx, batch); err != nil {
+		return errors.Wrap(err, "failed to get block headers")
+	}
+	for i := range batch {
+		if batch[i].Error != nil {
+			return errors.Wrapf(batch[i].Error, "failed to get header of block %d", blocks[i])
+		}
+		if headers[i] == nil {
+			return errors.Errorf("block %d not found", blocks[i])
+		}
+		out[i] = uint64(headers[i].Timestamp)
+	}
+	return nil
+}
+
+func (btr *blockTimestampResolver) head(ctx context.Context) (uint64, error) {
+	var head hexutil.Uint64
+	batch := []rpc.BatchElem{{Method: "eth_blockNumber", Result: &head}}
+	if err := btr.client.BatchCallContext(ctx, batch); err != nil {
+		return 0, errors.Wrap(err, "failed to get block number")
+	}
+	if batch[0].Error != nil {
+		return 0, errors.Wrap(batch[0].Error, "failed to get block number")
+	}
+	return uint64(head), nil
+}
+
+func (btr *blockTimestampResolver) BlockAtTimestamp(ctx context.Context, ts uint64) (uint64, error) {
+	head, err := btr.head(ctx)
+	if err != nil {
+		return 0, err
+	}
+	genesisTime, err := btr.Timestamp(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+	if ts < genesisTime {
+		return 0, errors.Wrapf(ErrBeforeGenesis, "%d is before %d", ts, genesisTime)
+	}
+
+	// The block at low is always at or before ts
+	low, high := uint64(0), head
+	for low < high {
+		mid := low + (high-low+1)/2
+		midTime, err := btr.Timestamp(ctx, mid)
+		if err != nil {
+			return 0, err
+		}
+		if midTime <= ts {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return low, nil
+}
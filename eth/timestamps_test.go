@@ -0,0 +1,134 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usecorn/common-lib/dbutils"
+)
+
+// fakeBatchCaller serves a chain of head+1 blocks which are 12 seconds apart
+type fakeBatchCaller struct {
+	mux        sync.Mutex
+	head       uint64
+	batchSizes []int
+}
+
+func (fbc *fakeBatchCaller) BatchCallContext(_ context.Context, b []rpc.BatchElem) error {
+	fbc.mux.Lock()
+	fbc.batchSizes = append(fbc.batchSizes, len(b))
+	fbc.mux.Unlock()
+	for i := range b {
+		var result any
+		switch b[i].Method {
+		case "eth_blockNumber":
+			result = hexutil.Uint64(fbc.head)
+		case "eth_getBlockByNumber":
+			block, err := hexutil.DecodeUint64(b[i].Args[0].(string))
+			if err != nil {
+				return err
+			}
+			if block <= fbc.head {
+				result = map[string]any{"number": hexutil.Uint64(block), "timestamp": hexutil.Uint64(1000 + 12*block)}
+			}
+		}
+		raw, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, b[i].Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fbc *fakeBatchCaller) requests() int {
+	fbc.mux.Lock()
+	defer fbc.mux.Unlock()
+	return len(fbc.batchSizes)
+}
+
+func Test_BlockTimestampResolver(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeBatchCaller{head: 1000}
+	meta, err := dbutils.NewMetaDBMemory()
+	require.NoError(t, err)
+	resolver, err := NewBlockTimestampResolver(client, meta, EthereumNetwork, TimestampResolverConfig{BatchSize: 10, Concurrency: 2, CacheSize: 100})
+	require.NoError(t, err)
+
+	blocks := make([]uint64, 0, 26)
+	for block := uint64(0); block < 25; block++ {
+		blocks = append(blocks, block)
+	}
+	blocks = append(blocks, 3) // Duplicates are only fetched once
+	timestamps, err := resolver.Timestamps(ctx, blocks)
+	require.NoError(t, err)
+	require.Len(t, timestamps, 25)
+	for block, ts := range timestamps {
+		require.Equal(t, 1000+12*block, ts)
+	}
+	require.ElementsMatch(t, []int{10, 10, 5}, client.batchSizes)
+
+	// Cached in memory
+	ts, err := resolver.Timestamp(ctx, 24)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1288), ts)
+	require.Equal(t, 3, client.requests())
+
+	// And in the MetaDB
+	stored, err := meta.GetUint64(ctx, "block_timestamp::ethereum::24")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1288), stored)
+	other, err := NewBlockTimestampResolver(client, meta, EthereumNetwork, TimestampResolverConfig{BatchSize: 10, Concurrency: 2, CacheSize: 100})
+	require.NoError(t, err)
+	_, err = other.Timestamps(ctx, blocks)
+	require.NoError(t, err)
+	require.Equal(t, 3, client.requests())
+
+	_, err = resolver.Timestamp(ctx, 2000)
+	require.ErrorContains(t, err, "block 2000 not found")
+
+	t.Run("recent blocks", func(t *testing.T) {
+		meta, err := dbutils.NewMetaDBMemory()
+		require.NoError(t, err)
+		resolver, err := NewBlockTimestampResolver(client, meta, EthereumNetwork,
+			TimestampResolverConfig{BatchSize: 10, Concurrency: 2, CacheSize: 100, LagBlocks: 10})
+		require.NoError(t, err)
+		timestamps, err := resolver.Timestamps(ctx, []uint64{989, 990, 991, 1000})
+		require.NoError(t, err)
+		require.Equal(t, map[uint64]uint64{989: 12868, 990: 12880, 991: 12892, 1000: 13000}, timestamps)
+
+		// Only the blocks at least 10 behind the head are cached, as the others may be reorged
+		stored, err := meta.ListPrefix(ctx, "block_timestamp::")
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"block_timestamp::ethereum::989": "12868", "block_timestamp::ethereum::990": "12880"}, stored)
+		requests := client.requests()
+		_, err = resolver.Timestamps(ctx, []uint64{990, 991})
+		require.NoError(t, err)
+		require.Equal(t, requests+2, client.requests())
+	})
+
+	t.Run("block at timestamp", func(t *testing.T) {
+		block, err := resolver.BlockAtTimestamp(ctx, 1000+12*500+5)
+		require.NoError(t, err)
+		require.Equal(t, uint64(500), block)
+		block, err = resolver.BlockAtTimestamp(ctx, 1000+12*500)
+		require.NoError(t, err)
+		require.Equal(t, uint64(500), block)
+		block, err = resolver.BlockAtTimestamp(ctx, 1000)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), block)
+		block, err = resolver.BlockAtTimestamp(ctx, 1_000_000)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1000), block)
+		_, err = resolver.BlockAtTimestamp(ctx, 999)
+		require.ErrorIs(t, err, ErrBeforeGenesis)
+	})
+}
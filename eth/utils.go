@@ -15,6 +15,7 @@ import (
 )
 
 // CurrentSafeBlockHead returns the current block number minus the lag blocks. Additionally with retry if the RPC call fails.
+// conf should have the overrides of the client's network applied, such as from NetworkChain.
 func CurrentSafeBlockHead(ctx context.Context, conf *config.Chain, ethClient EthClient) (blockNum uint64, err error) {
 
 	for range conf.RPCMaxRetries {
@@ -33,7 +34,10 @@ func CurrentSafeBlockHead(ctx context.Context, conf *config.Chain, ethClient Eth
 
 // GetHeaderByNumberRetry retrieves the block header by number with retries, and will also attempt to redial the client if it fails.
 // There are times when the connection itself is dropped, and redialing is necessary to get a new connection.
+//
+// Deprecated: use a MultiClient from DialNetwork, which retries all requests and fails over to other endpoints.
 func GetHeaderByNumberRetry(ctx context.Context, network string, conf *config.Chain, client *ethclient.Client, blockNo int64) (*types.Header, error) {
+	conf = NetworkChain(network, conf)
 
 	for i := range conf.RPCMaxRetries {
 		header, err := client.HeaderByNumber(ctx, big.NewInt(blockNo))
@@ -48,14 +52,12 @@ func GetHeaderByNumberRetry(ctx context.Context, network string, conf *config.Ch
 		if err != nil {
 			return nil, errors.Wrap(err, "sleep interrupted")
 		}
-		switch network {
-		case EthereumNetwork:
-			client, err = ethclient.DialContext(ctx, conf.RPCURL)
-		case CornMainnet:
-			client, err = ethclient.DialContext(ctx, conf.CornRPCURL)
-		default:
-			return nil, errors.Errorf("unknown network %s", network)
+		urls, err := NetworkRPCURLs(network, conf)
+		if err != nil {
+			return nil, err
 		}
+		// Redial the primary endpoint first, then the fallbacks in turn
+		client, err = ethclient.DialContext(ctx, urls[i%len(urls)])
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to redial")
 		}
@@ -83,6 +85,9 @@ type BN interface {
 	BlockNumber() int64
 }
 
+// GetBlockToTimestampMap gets the timestamps of the blocks of events, one block at a time.
+//
+// Deprecated: use ResolveBlockTimestamps, which fetches headers in batches rather than full blocks, and caches them.
 func GetBlockToTimestampMap[T BN](ctx context.Context, ethClient EthClient, events []T) (map[int64]int64, error) {
 	blockTimestamps := make(map[int64]int64)
 	for _, event := range events {
@@ -98,3 +103,20 @@ func GetBlockToTimestampMap[T BN](ctx context.Context, ethClient EthClient, even
 	}
 	return blockTimestamps, nil
 }
+
+// ResolveBlockTimestamps gets the timestamps of the blocks of events from resolver
+func ResolveBlockTimestamps[T BN](ctx context.Context, resolver BlockTimestampResolver, events []T) (map[int64]int64, error) {
+	blocks := make([]uint64, len(events))
+	for i, event := range events {
+		blocks[i] = uint64(event.BlockNumber())
+	}
+	timestamps, err := resolver.Timestamps(ctx, blocks)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int64]int64, len(timestamps))
+	for block, ts := range timestamps {
+		out[int64(block)] = int64(ts)
+	}
+	return out, nil
+}
@@ -167,7 +167,7 @@ func BatchUnrelatedEarnRequests(earnRequests []EarnRequest) (EarnRequestFullBatc
 	}
 
 	for i := range earnRequests {
-		out.UserAddrs[i] = earnRequests[i].UserAddr
+		out.UserAddrs[i] = earnRequests[i].UserAddr.String()
 		out.EarnRates[i] = earnRequests[i].EarnRate
 		out.SourceUsers[i] = earnRequests[i].GetSourceUser()
 		out.Sources[i] = earnRequests[i].Source
@@ -315,7 +315,7 @@ func BatchEarnRequests(earnRequests []EarnRequest) (EarnRequestBatch, error) {
 	}
 
 	for i := range earnRequests {
-		out.UserAddrs[i] = earnRequests[i].UserAddr
+		out.UserAddrs[i] = earnRequests[i].UserAddr.String()
 		out.EarnRates[i] = earnRequests[i].EarnRate
 		out.SourceUsers[i] = earnRequests[i].GetSourceUser()
 		if out.StartBlock != earnRequests[i].StartBlock {
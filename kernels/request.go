@@ -12,13 +12,13 @@ import (
 )
 
 type EarnRequest struct {
-	UserAddr   string `json:"userAddr"`
-	Source     string `json:"source"`
-	SubSource  string `json:"subSource"`
-	SourceUser string `json:"sourceUser"`
-	StartBlock int64  `json:"startBlock"`
-	StartTime  int64  `json:"startTime"`
-	EarnRate   string `json:"earnRate"`
+	UserAddr   validate.EthAddress `json:"userAddr"`
+	Source     string              `json:"source"`
+	SubSource  string              `json:"subSource"`
+	SourceUser string              `json:"sourceUser"`
+	StartBlock int64               `json:"startBlock"`
+	StartTime  int64               `json:"startTime"`
+	EarnRate   string              `json:"earnRate"`
 }
 
 func (er EarnRequest) Clone() EarnRequest {
@@ -33,13 +33,18 @@ func (er EarnRequest) Clone() EarnRequest {
 func (e EarnRequest) ReferralBonuses(referralChain []string, tierEarnRates map[int]*big.Rat) ([]EarnRequest, error) {
 	var out []EarnRequest
 
-	if e.GetSourceUser() != e.UserAddr || len(referralChain) == 0 {
+	// UserAddr is normalized to lowercase, SourceUser may be in any case
+	if !strings.EqualFold(e.GetSourceUser(), e.UserAddr.String()) || len(referralChain) == 0 {
 		return nil, nil
 	}
 
 	for i := range referralChain {
+		referrer, err := validate.ParseEthAddress(referralChain[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid referrer \"%s\"", referralChain[i])
+		}
 		req := EarnRequest{
-			UserAddr:   referralChain[i],
+			UserAddr:   referrer,
 			Source:     e.Source,
 			SubSource:  e.SubSource,
 			SourceUser: e.GetSourceUser(),
@@ -61,7 +66,7 @@ func (e EarnRequest) ReferralBonuses(referralChain []string, tierEarnRates map[i
 
 func (e EarnRequest) GetSourceUser() string {
 	if e.SourceUser == "" {
-		return e.UserAddr
+		return e.UserAddr.String()
 	}
 	return e.SourceUser
 }
@@ -84,7 +89,7 @@ func (e EarnRequest) Validate() error {
 		return ErrEarnInf
 	}
 
-	if len(e.UserAddr) != 42 {
+	if e.UserAddr.Validate() != nil {
 		return ErrInvalidUserAddr
 	}
 	if len(e.Source) == 0 {
@@ -103,24 +108,36 @@ func (e EarnRequest) IsPerBlock() bool {
 }
 
 type GrantRequest struct {
-	UUID            uuid.UUID `json:"uuid"`
-	UserAddr        string    `json:"userAddr"`
-	Amount          string    `json:"amount"`
-	Source          string    `json:"source"`
-	SubSource       string    `json:"subSource"`
-	SourceUser      string    `json:"-"`
-	Category        string    `json:"category"`
-	GrantTime       int64     `json:"grantTime"`
-	ExcludeReferral bool      `json:"excludeReferral"`
+	UUID            uuid.UUID           `json:"uuid"`
+	UserAddr        validate.EthAddress `json:"userAddr"`
+	Amount          string              `json:"amount"`
+	Source          string              `json:"source"`
+	SubSource       string              `json:"subSource"`
+	SourceUser      string              `json:"-"`
+	Category        string              `json:"category"`
+	GrantTime       int64               `json:"grantTime"`
+	ExcludeReferral bool                `json:"excludeReferral"`
 }
 
 func (gr GrantRequest) GetSourceUser() string {
 	if gr.SourceUser == "" {
-		return strings.ToLower(gr.UserAddr)
+		return strings.ToLower(gr.UserAddr.String())
 	}
 	return strings.ToLower(gr.SourceUser)
 }
 
+// ValidateReferralChain checks that every address in a referral chain is a valid Ethereum address
+func ValidateReferralChain(referralChain []string) error {
+	for i := range referralChain {
+		if _, err := validate.ParseEthAddress(referralChain[i]); err != nil {
+			return errors.Wrapf(err, "invalid referrer \"%s\"", referralChain[i])
+		}
+	}
+	return nil
+}
+
+// ReferralBonuses panics if a referrer is not a valid Ethereum address, so the chain should be checked with
+// ValidateReferralChain first.
 func (gr GrantRequest) ReferralBonuses(referralChain []string, tierEarnRates map[int]*big.Rat) []GrantRequest {
 	var out []GrantRequest
 
@@ -137,7 +154,7 @@ func (gr GrantRequest) ReferralBonuses(referralChain []string, tierEarnRates map
 		multiplier := big.NewRat(1, 1).Set(tierEarnRates[i])
 		req := GrantRequest{
 			UUID:       uuid.NewSHA1(gr.UUID, []byte{byte(i >> 24 & 0xFF), byte(i >> 16 & 0xFF), byte(i >> 8 & 0xFF), byte(i & 0xFF)}),
-			UserAddr:   referralChain[i],
+			UserAddr:   validate.MustParseEthAddress(referralChain[i]),
 			Amount:     multiplier.Mul(parsedAmount, multiplier).FloatString(20), // Points are accurate to 20 decimal places
 			Source:     gr.Source,
 			SourceUser: gr.GetSourceUser(),
@@ -148,11 +165,7 @@ func (gr GrantRequest) ReferralBonuses(referralChain []string, tierEarnRates map
 }
 
 func (g GrantRequest) Validate() error {
-	if len(g.UserAddr) != 42 {
-		return ErrInvalidUserAddr
-	}
-
-	if !validate.EthAddrExp.MatchString(g.UserAddr) {
+	if g.UserAddr.Validate() != nil {
 		return ErrInvalidUserAddr
 	}
 
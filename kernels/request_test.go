@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/usecorn/common-lib/testutils"
+	"github.com/usecorn/common-lib/validate"
 )
 
 func Test_EarnRequest_Validate(t *testing.T) {
@@ -21,7 +22,7 @@ func Test_EarnRequest_Validate(t *testing.T) {
 		{
 			name: "startBlock and startTime both zero",
 			req: EarnRequest{
-				UserAddr:   testutils.GenRandEVMAddr(),
+				UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 				Source:     "source",
 				SubSource:  "sub",
 				StartBlock: 0,
@@ -33,7 +34,7 @@ func Test_EarnRequest_Validate(t *testing.T) {
 		{
 			name: "startBlock and startTime both non-zero",
 			req: EarnRequest{
-				UserAddr:   testutils.GenRandEVMAddr(),
+				UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 				Source:     "source",
 				SubSource:  "sub",
 				StartBlock: 1,
@@ -45,7 +46,7 @@ func Test_EarnRequest_Validate(t *testing.T) {
 		{
 			name: "startBlock non-zero",
 			req: EarnRequest{
-				UserAddr:   testutils.GenRandEVMAddr(),
+				UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 				Source:     "source",
 				SubSource:  "sub",
 				StartBlock: 1,
@@ -57,7 +58,7 @@ func Test_EarnRequest_Validate(t *testing.T) {
 		{
 			name: "startTime non-zero",
 			req: EarnRequest{
-				UserAddr:   testutils.GenRandEVMAddr(),
+				UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 				Source:     "source",
 				StartBlock: 0,
 				SubSource:  "sub",
@@ -69,7 +70,7 @@ func Test_EarnRequest_Validate(t *testing.T) {
 		{
 			name: "negative earn rate",
 			req: EarnRequest{
-				UserAddr:   testutils.GenRandEVMAddr(),
+				UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 				Source:     "source",
 				StartBlock: 0,
 				SubSource:  "sub",
@@ -81,7 +82,7 @@ func Test_EarnRequest_Validate(t *testing.T) {
 		{
 			name: "invalid user address",
 			req: EarnRequest{
-				UserAddr:   testutils.GenRandEVMAddr() + "f",
+				UserAddr:   validate.EthAddress(testutils.GenRandEVMAddr() + "f"),
 				Source:     "source",
 				StartBlock: 0,
 				StartTime:  1,
@@ -92,7 +93,7 @@ func Test_EarnRequest_Validate(t *testing.T) {
 		{
 			name: "empty source",
 			req: EarnRequest{
-				UserAddr:   testutils.GenRandEVMAddr(),
+				UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 				Source:     "",
 				StartBlock: 0,
 				StartTime:  1,
@@ -119,7 +120,7 @@ func Test_EarnRequest_IsPerBlock(t *testing.T) {
 		{
 			name: "startBlock non-zero",
 			req: EarnRequest{
-				UserAddr:   testutils.GenRandEVMAddr(),
+				UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 				Source:     "source",
 				StartBlock: 1,
 				StartTime:  0,
@@ -130,7 +131,7 @@ func Test_EarnRequest_IsPerBlock(t *testing.T) {
 		{
 			name: "startTime non-zero",
 			req: EarnRequest{
-				UserAddr:   testutils.GenRandEVMAddr(),
+				UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 				Source:     "source",
 				StartBlock: 0,
 				StartTime:  1,
@@ -159,7 +160,7 @@ func Test_EarnRequest_GetSourceUser(t *testing.T) {
 		{
 			name: "get source user",
 			req: EarnRequest{
-				UserAddr:   user1,
+				UserAddr:   validate.MustParseEthAddress(user1),
 				Source:     "source",
 				StartBlock: 1,
 				StartTime:  0,
@@ -170,7 +171,7 @@ func Test_EarnRequest_GetSourceUser(t *testing.T) {
 		{
 			name: "get source user",
 			req: EarnRequest{
-				UserAddr:   user1,
+				UserAddr:   validate.MustParseEthAddress(user1),
 				Source:     "source",
 				SourceUser: user2,
 				StartBlock: 1,
@@ -198,7 +199,7 @@ func Test_EarnRequest_ReferralBonuses(t *testing.T) {
 
 	t.Run("happy path", func(t *testing.T) {
 		rq := EarnRequest{
-			UserAddr:   testutils.GenRandEVMAddr(),
+			UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 			Source:     "source",
 			StartBlock: 1,
 			StartTime:  0,
@@ -213,7 +214,7 @@ func Test_EarnRequest_ReferralBonuses(t *testing.T) {
 		require.Len(t, bonuses, 2)
 
 		for i := range bonuses {
-			require.Equal(t, referralChain[i], bonuses[i].UserAddr)
+			require.Equal(t, referralChain[i], bonuses[i].UserAddr.String())
 			require.Equal(t, rq.GetSourceUser(), bonuses[i].SourceUser)
 			require.Equal(t, rq.StartBlock, bonuses[i].StartBlock)
 			require.Equal(t, rq.StartTime, bonuses[i].StartTime)
@@ -226,7 +227,7 @@ func Test_EarnRequest_ReferralBonuses(t *testing.T) {
 	t.Run("source user set", func(t *testing.T) {
 		req := EarnRequest{
 			EarnRate:   "100",
-			UserAddr:   testutils.GenRandEVMAddr(),
+			UserAddr:   validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 			SourceUser: testutils.GenRandEVMAddr(),
 			Source:     "ohio",
 			SubSource:  "corn",
@@ -237,6 +238,32 @@ func Test_EarnRequest_ReferralBonuses(t *testing.T) {
 		require.Nil(t, res)
 	})
 
+	t.Run("mixed case source user", func(t *testing.T) {
+		user := validate.MustParseEthAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+		req := EarnRequest{
+			EarnRate:   "100",
+			UserAddr:   user,
+			SourceUser: user.Checksummed(),
+			Source:     "ohio",
+			SubSource:  "corn",
+		}
+
+		res, err := req.ReferralBonuses([]string{testutils.GenRandEVMAddr()}, tierRates)
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+	})
+
+	t.Run("invalid referrer", func(t *testing.T) {
+		req := EarnRequest{
+			EarnRate: "100",
+			UserAddr: validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
+			Source:   "ohio",
+		}
+
+		_, err := req.ReferralBonuses([]string{"0x1234"}, tierRates)
+		require.ErrorIs(t, err, validate.ErrInvalidEthAddr)
+	})
+
 }
 
 func Test_GrantRequest_ReferralBonuses(t *testing.T) {
@@ -259,7 +286,7 @@ func Test_GrantRequest_ReferralBonuses(t *testing.T) {
 		req := GrantRequest{
 			UUID:     id,
 			Amount:   "-100",
-			UserAddr: testutils.GenRandEVMAddr(),
+			UserAddr: validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 			Source:   "ohio",
 			Category: "category",
 		}
@@ -273,7 +300,7 @@ func Test_GrantRequest_ReferralBonuses(t *testing.T) {
 		req := GrantRequest{
 			UUID:     id,
 			Amount:   "100",
-			UserAddr: testutils.GenRandEVMAddr(),
+			UserAddr: validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 			Source:   "kansas",
 			Category: "category",
 		}
@@ -286,7 +313,7 @@ func Test_GrantRequest_ReferralBonuses(t *testing.T) {
 
 		for i := range res {
 			require.NotEqual(t, req.UUID, res[i].UUID)
-			require.Equal(t, addrs[i], res[i].UserAddr)
+			require.Equal(t, addrs[i], res[i].UserAddr.String())
 			require.Equal(t, expected[i], res[i].Amount)
 		}
 	})
@@ -295,7 +322,7 @@ func Test_GrantRequest_ReferralBonuses(t *testing.T) {
 		req := GrantRequest{
 			UUID:     id,
 			Amount:   "100",
-			UserAddr: testutils.GenRandEVMAddr(),
+			UserAddr: validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 			Source:   "arkansas",
 		}
 
@@ -314,6 +341,12 @@ func Test_GrantRequest_ReferralBonuses(t *testing.T) {
 
 }
 
+func Test_ValidateReferralChain(t *testing.T) {
+	t.Parallel()
+	require.NoError(t, ValidateReferralChain([]string{testutils.GenRandEVMAddr(), "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}))
+	require.ErrorIs(t, ValidateReferralChain([]string{testutils.GenRandEVMAddr(), "0x1234"}), validate.ErrInvalidEthAddr)
+}
+
 func Test_GrantRequest_Validate(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -325,18 +358,30 @@ func Test_GrantRequest_Validate(t *testing.T) {
 			name: "invalid user address",
 			req: GrantRequest{
 				UUID:      uuid.New(),
-				UserAddr:  testutils.GenRandEVMAddr() + "5",
+				UserAddr:  validate.EthAddress(testutils.GenRandEVMAddr() + "5"),
 				Amount:    "100",
 				GrantTime: 123214251,
 				Category:  "category",
 			},
 			err: ErrInvalidUserAddr,
 		},
+		{
+			name: "mixed case user address",
+			req: GrantRequest{
+				UUID:      uuid.New(),
+				UserAddr:  validate.EthAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"),
+				Amount:    "100",
+				Source:    "wyoming",
+				Category:  "category",
+				GrantTime: 123214251,
+			},
+			err: ErrInvalidUserAddr,
+		},
 		{
 			name: "valid user address",
 			req: GrantRequest{
 				UUID:      uuid.New(),
-				UserAddr:  testutils.GenRandEVMAddr(),
+				UserAddr:  validate.MustParseEthAddress(testutils.GenRandEVMAddr()),
 				Amount:    "100",
 				Source:    "wyoming",
 				Category:  "category",
@@ -22,13 +22,21 @@ type priceAPI struct {
 	cacheExpiration time.Duration
 	client          *http.Client
 	cache           *gcache.Cache
+	networks        *eth.NetworkRegistry
 }
 
+// NewPriceAPI creates a PriceAPI for the networks in eth.Networks
 func NewPriceAPI(apiTimeout, cacheExpiration time.Duration) PriceAPI {
+	return NewPriceAPIWithNetworks(apiTimeout, cacheExpiration, eth.Networks)
+}
+
+// NewPriceAPIWithNetworks creates a PriceAPI which gets the prices of networks from their PriceURL
+func NewPriceAPIWithNetworks(apiTimeout, cacheExpiration time.Duration, networks *eth.NetworkRegistry) PriceAPI {
 	return &priceAPI{
 		cacheExpiration: cacheExpiration,
 		client:          &http.Client{Timeout: apiTimeout},
 		cache:           gcache.New(cacheExpiration, 2*cacheExpiration),
+		networks:        networks,
 	}
 }
 
@@ -39,16 +47,11 @@ func (papi *priceAPI) GetAllPrices(ctx context.Context, network string) (map[str
 			return prices, nil
 		}
 	}
-	var url string
-	switch network {
-	case eth.EthereumNetwork:
-		url = "https://api.usecorn.com/api/v1/price/all"
-	case eth.CornMainnet:
-		url = "https://api.usecorn.com/api/v1/price/corn/all"
-	default:
+	n, err := papi.networks.Get(network)
+	if err != nil || n.PriceURL == "" {
 		return nil, errors.Newf("unsupported network %s", network)
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, n.PriceURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,193 @@
+package queue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"reflect"
+
+	"github.com/cockroachdb/errors"
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentTypeAttribute is the message attribute holding the content type of the payload,
+// so that subscribers can decode messages published with different codecs, such as during a rollout
+const ContentTypeAttribute = "content-type"
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeGzipJSON = "application/json+gzip"
+	ContentTypeCBOR     = "application/cbor"
+	ContentTypeProtobuf = "application/protobuf"
+)
+
+// DefaultGzipMaxSize is the largest decompressed payload GzipJSONCodec decodes, 64MiB
+const DefaultGzipMaxSize int64 = 64 << 20
+
+var (
+	// ErrUnknownContentType is returned when a message has a content type none of the subscriber's codecs can decode
+	ErrUnknownContentType = errors.New("unknown content type")
+	// ErrPayloadTooLarge is returned when a compressed payload decompresses to more than the codec's maximum size
+	ErrPayloadTooLarge = errors.New("payload too large")
+)
+
+// Codec encodes and decodes message payloads
+type Codec interface {
+	// ContentType is sent in the content-type attribute of every message encoded with the codec
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSONCodec Codec = jsonCodec{}
+	// GzipJSONCodec decompresses payloads of up to DefaultGzipMaxSize, see NewGzipJSONCodec
+	GzipJSONCodec Codec = NewGzipJSONCodec(DefaultGzipMaxSize)
+	CBORCodec     Codec = cborCodec{}
+	// ProtobufCodec can only encode and decode types which implement proto.Message
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+// DefaultCodecs are the codecs subscribers can decode with, if none are configured
+func DefaultCodecs() []Codec {
+	return []Codec{JSONCodec, GzipJSONCodec, CBORCodec, ProtobufCodec}
+}
+
+// codecFor returns the codec matching the content-type attribute. Messages without a content type are JSON, as they
+// were published before codecs were introduced.
+func codecFor(codecs []Codec, attributes map[string]string) (Codec, error) {
+	contentType, ok := attributes[ContentTypeAttribute]
+	if !ok {
+		contentType = ContentTypeJSON
+	}
+	for _, codec := range codecs {
+		if codec.ContentType() == contentType {
+			return codec, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrUnknownContentType, "no codec for %q", contentType)
+}
+
+// decodeMessage decodes data with the codec matching the content-type attribute
+func decodeMessage(codecs []Codec, attributes map[string]string, data []byte, v any) error {
+	codec, err := codecFor(codecs, attributes)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, v)
+}
+
+// withContentType returns a copy of attributes with the content type of codec set
+func withContentType(attributes map[string]string, codec Codec) map[string]string {
+	out := make(map[string]string, len(attributes)+1)
+	for k, v := range attributes {
+		out[k] = v
+	}
+	out[ContentTypeAttribute] = codec.ContentType()
+	return out
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gzipJSONCodec struct {
+	maxSize int64
+}
+
+// NewGzipJSONCodec creates a gzip compressed JSON codec which fails to decode payloads larger than maxSize once
+// decompressed with ErrPayloadTooLarge. Subscribers use the first of their codecs with a matching content type,
+// so it replaces GzipJSONCodec in SubscriberOptions.Codecs.
+func NewGzipJSONCodec(maxSize int64) Codec {
+	return gzipJSONCodec{maxSize: maxSize}
+}
+
+func (gzipJSONCodec) ContentType() string {
+	return ContentTypeGzipJSON
+}
+
+func (gzipJSONCodec) Marshal(v any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gc gzipJSONCodec) Unmarshal(data []byte, v any) error {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "invalid gzip payload")
+	}
+	defer r.Close()
+	// Read one byte past the limit to tell a payload of exactly maxSize from a larger one
+	decompressed, err := io.ReadAll(io.LimitReader(r, gc.maxSize+1))
+	if err != nil {
+		return errors.Wrap(err, "invalid gzip payload")
+	}
+	if int64(len(decompressed)) > gc.maxSize {
+		return errors.Wrapf(ErrPayloadTooLarge, "gzip payload decompresses to more than %d bytes", gc.maxSize)
+	}
+	return json.Unmarshal(decompressed, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string {
+	return ContentTypeCBOR
+}
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Newf("%T does not implement proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+// Unmarshal accepts either a proto.Message, or a pointer to one, which is allocated if it is nil
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	if msg, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, msg)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && rv.Elem().Kind() == reflect.Pointer {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if msg, ok := rv.Elem().Interface().(proto.Message); ok {
+			return proto.Unmarshal(data, msg)
+		}
+	}
+	return errors.Newf("%T does not implement proto.Message", v)
+}
@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecTestPayload struct {
+	UserAddrs []string `json:"userAddrs" cbor:"userAddrs"`
+	EarnRates []string `json:"earnRates" cbor:"earnRates"`
+	StartTime int64    `json:"startTime" cbor:"startTime"`
+}
+
+func Test_Codecs(t *testing.T) {
+	payload := codecTestPayload{
+		UserAddrs: []string{"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"},
+		EarnRates: []string{"1.5", "2"},
+		StartTime: 1700000000,
+	}
+	for _, codec := range []Codec{JSONCodec, GzipJSONCodec, CBORCodec} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			data, err := codec.Marshal(payload)
+			require.NoError(t, err)
+			var out codecTestPayload
+			require.NoError(t, codec.Unmarshal(data, &out))
+			require.Equal(t, payload, out)
+		})
+	}
+
+	t.Run(ContentTypeProtobuf, func(t *testing.T) {
+		data, err := ProtobufCodec.Marshal(wrapperspb.String("hello"))
+		require.NoError(t, err)
+		var out *wrapperspb.StringValue
+		require.NoError(t, ProtobufCodec.Unmarshal(data, &out))
+		require.Equal(t, "hello", out.GetValue())
+
+		_, err = ProtobufCodec.Marshal(payload)
+		require.Error(t, err)
+	})
+
+	t.Run("gzip max size", func(t *testing.T) {
+		data, err := GzipJSONCodec.Marshal(payload)
+		require.NoError(t, err)
+		encoded, err := JSONCodec.Marshal(payload)
+		require.NoError(t, err)
+		// The gzip codec's JSON encoder adds a trailing newline
+		size := int64(len(encoded)) + 1
+
+		var out codecTestPayload
+		require.NoError(t, NewGzipJSONCodec(size).Unmarshal(data, &out))
+		require.Equal(t, payload, out)
+		require.ErrorIs(t, NewGzipJSONCodec(size-1).Unmarshal(data, &out), ErrPayloadTooLarge)
+	})
+
+	t.Run("decode by content type", func(t *testing.T) {
+		data, err := CBORCodec.Marshal(payload)
+		require.NoError(t, err)
+		var out codecTestPayload
+		require.NoError(t, decodeMessage(DefaultCodecs(), withContentType(nil, CBORCodec), data, &out))
+		require.Equal(t, payload, out)
+
+		// Messages without a content type are JSON
+		require.NoError(t, decodeMessage(DefaultCodecs(), nil, []byte(`{"startTime": 1}`), &out))
+		require.Equal(t, int64(1), out.StartTime)
+
+		err = decodeMessage([]Codec{JSONCodec}, withContentType(nil, CBORCodec), data, &out)
+		require.ErrorIs(t, err, ErrUnknownContentType)
+	})
+}
+
+func Test_GooglePubSub_MixedCodecs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, _ := newTestPubSub(ctx, t, pubsub.SubscriptionConfig{})
+
+	jsonPub, err := NewGooglePubSubPublisher[codecTestPayload](ctx, logrus.New(), client, "topic")
+	require.NoError(t, err)
+	gzipPub, err := NewGooglePubSubPublisherWithOptions[codecTestPayload](ctx, logrus.New(), client, "topic", PublisherOptions{Codec: GzipJSONCodec})
+	require.NoError(t, err)
+	sub, err := NewGooglePubSubSubscriber[codecTestPayload](ctx, logrus.New(), client, "sub")
+	require.NoError(t, err)
+
+	require.NoError(t, jsonPub.Publish(ctx, codecTestPayload{StartTime: 1}))
+	require.NoError(t, gzipPub.PublishMessage(ctx, OutgoingMessage[codecTestPayload]{
+		Data:       codecTestPayload{StartTime: 2},
+		Attributes: map[string]string{"source": "test"},
+	}))
+
+	recvCtx, recvCancel := context.WithCancel(ctx)
+	ch := sub.ReceiveCh(recvCtx)
+	contentTypes := map[int64]string{}
+	for range 2 {
+		msg := <-ch
+		contentTypes[msg.Data.StartTime] = msg.Attributes[ContentTypeAttribute]
+		msg.Ack()
+	}
+	require.Equal(t, map[int64]string{1: ContentTypeJSON, 2: ContentTypeGzipJSON}, contentTypes)
+
+	recvCancel()
+	for range ch {
+	}
+}
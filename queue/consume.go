@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/getsentry/sentry-go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/usecorn/common-lib/app"
+)
+
+var (
+	// ErrSubscriptionClosed is returned by Consume when the subscription stops delivering messages before ctx is cancelled
+	ErrSubscriptionClosed = errors.New("subscription closed")
+	// ErrDrainTimeout is returned by Consume when in-flight handlers did not finish within the drain timeout
+	ErrDrainTimeout = errors.New("timed out waiting for handlers to finish")
+)
+
+// Handler processes a message. The message is acked if it returns nil and nacked if it returns an error or panics,
+// so handlers must not ack or nack the message themselves.
+type Handler[T any] func(ctx context.Context, msg Message[T]) error
+
+// ConsumeOptions configures Consume
+type ConsumeOptions struct {
+	// Workers is the number of messages handled concurrently
+	Workers int `env:"QUEUE_WORKERS" env-default:"1"`
+	// DrainTimeout is how long in-flight handlers have to finish after ctx is cancelled, before their context is cancelled too
+	DrainTimeout time.Duration `env:"QUEUE_DRAIN_TIMEOUT" env-default:"30s"`
+	// Log defaults to the standard logrus logger
+	Log logrus.Ext1FieldLogger
+}
+
+func (opts ConsumeOptions) withDefaults() ConsumeOptions {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = 30 * time.Second
+	}
+	if opts.Log == nil {
+		opts.Log = logrus.StandardLogger()
+	}
+	return opts
+}
+
+// Consume receives messages from sub and runs handler on a pool of opts.Workers goroutines, until ctx is cancelled or
+// the process receives SIGINT/SIGTERM. On shutdown it stops receiving, nacks messages which were received but not
+// started, and waits up to opts.DrainTimeout for in-flight handlers to finish. Panics in handlers are recovered,
+// reported to Sentry and the message is nacked.
+func Consume[T any](ctx context.Context, sub QueueSubscriber[T], handler Handler[T], opts ConsumeOptions) error {
+	opts = opts.withDefaults()
+	ctx, stop := app.ContextWithSignal(ctx)
+	defer stop()
+	// Handlers keep running after ctx is cancelled, so that they can finish while draining
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+
+	ch := sub.ReceiveCh(ctx)
+	wg := sync.WaitGroup{}
+	for range opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range ch {
+				if ctx.Err() != nil {
+					msg.Nack()
+					continue
+				}
+				handleMessage(handlerCtx, opts.Log, handler, msg)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		if ctx.Err() != nil {
+			return nil
+		}
+		return ErrSubscriptionClosed
+	case <-ctx.Done():
+	}
+
+	opts.Log.Info("draining queue consumer")
+	select {
+	case <-done:
+		return nil
+	case <-time.After(opts.DrainTimeout):
+		cancelHandlers()
+		<-done
+		return ErrDrainTimeout
+	}
+}
+
+func handleMessage[T any](ctx context.Context, log logrus.Ext1FieldLogger, handler Handler[T], msg Message[T]) {
+	defer func() {
+		if r := recover(); r != nil {
+			hub := sentry.CurrentHub().Clone()
+			hub.Recover(r)
+			log.WithField("panic", r).Error("queue handler panicked")
+			msg.Nack()
+		}
+	}()
+
+	if err := handler(ctx, msg); err != nil {
+		log.WithError(err).Warn("queue handler failed")
+		msg.Nack()
+		return
+	}
+	msg.Ack()
+}
@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Consume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mq := NewMemoryQueueWithOptions[int](logrus.New(), 20, SubscriberOptions{Retry: RetryPolicy{InitialBackoff: time.Millisecond}})
+	for i := range 10 {
+		require.NoError(t, mq.Publish(ctx, i))
+	}
+
+	var (
+		mu         sync.Mutex
+		handled    = map[int]int{}
+		running    atomic.Int32
+		maxRunning atomic.Int32
+		wg         sync.WaitGroup
+	)
+	wg.Add(10)
+	handler := func(ctx context.Context, msg Message[int]) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		if n > maxRunning.Load() {
+			maxRunning.Store(n)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		handled[msg.Data]++
+		attempt := handled[msg.Data]
+		mu.Unlock()
+		switch {
+		case msg.Data == 3 && attempt == 1:
+			return errors.New("try again")
+		case msg.Data == 7 && attempt == 1:
+			panic("boom")
+		}
+		wg.Done()
+		return nil
+	}
+
+	consumeCtx, consumeCancel := context.WithCancel(ctx)
+	errCh := make(chan error)
+	go func() {
+		errCh <- Consume[int](consumeCtx, mq, handler, ConsumeOptions{Workers: 3, Log: logrus.New()})
+	}()
+
+	wg.Wait()
+	consumeCancel()
+	require.NoError(t, <-errCh)
+
+	require.Len(t, handled, 10)
+	require.Equal(t, 2, handled[3])
+	require.Equal(t, 2, handled[7])
+	require.LessOrEqual(t, maxRunning.Load(), int32(3))
+	require.Equal(t, 0, mq.Len())
+}
+
+func Test_Consume_DrainTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mq := NewMemoryQueueWithOptions[int](logrus.New(), 1, SubscriberOptions{Retry: RetryPolicy{InitialBackoff: time.Millisecond}})
+	require.NoError(t, mq.Publish(ctx, 1))
+
+	started := make(chan struct{})
+	handler := func(ctx context.Context, msg Message[int]) error {
+		close(started)
+		<-ctx.Done() // Only cancelled once the drain timeout is hit
+		return ctx.Err()
+	}
+
+	consumeCtx, consumeCancel := context.WithCancel(ctx)
+	errCh := make(chan error)
+	go func() {
+		errCh <- Consume[int](consumeCtx, mq, handler, ConsumeOptions{DrainTimeout: 50 * time.Millisecond, Log: logrus.New()})
+	}()
+
+	<-started
+	consumeCancel()
+	require.ErrorIs(t, <-errCh, ErrDrainTimeout)
+	// Nacked, so it is back on the queue after the backoff
+	require.Eventually(t, func() bool { return mq.Len() == 1 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,282 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// DedupSchema is the table used by the Postgres DedupStore
+const DedupSchema = `CREATE TABLE IF NOT EXISTS queue_dedup (
+	key TEXT PRIMARY KEY,
+	expires_at TIMESTAMPTZ NOT NULL,
+	processed BOOLEAN NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS queue_dedup_expires_at_idx ON queue_dedup (expires_at);`
+
+// dedupPurgeInterval is the minimum time between purges of expired keys
+const dedupPurgeInterval = time.Minute
+
+// DedupState is the state of a message key in a DedupStore
+type DedupState int
+
+const (
+	// DedupClaimed is returned by Claim when the key was free, and is now leased to the caller
+	DedupClaimed DedupState = iota
+	// DedupInProgress is returned by Claim when another delivery holds the lease on the key
+	DedupInProgress
+	// DedupProcessed is returned by Claim when the message of the key has been processed
+	DedupProcessed
+)
+
+// DedupStore records the keys of messages which are being, or have been, processed
+type DedupStore interface {
+	// Claim leases key for ttl if it is not leased or processed, and returns the state it was in
+	Claim(ctx context.Context, key string, ttl time.Duration) (DedupState, error)
+	// MarkProcessed marks a claimed key as processed, and keeps it for ttl from now
+	MarkProcessed(ctx context.Context, key string, ttl time.Duration) error
+	// Release removes key, so that it can be claimed again
+	Release(ctx context.Context, key string) error
+}
+
+// DedupConfig configures a deduplicating subscriber
+type DedupConfig struct {
+	// TTL is how long the keys of processed messages are remembered, it should exceed how long duplicates can arrive
+	TTL time.Duration `env:"QUEUE_DEDUP_TTL" env-default:"168h"`
+	// Lease is how long a key is reserved while its message is processed, after which a redelivery can be processed
+	// again, such as when the process died while handling it
+	Lease time.Duration `env:"QUEUE_DEDUP_LEASE" env-default:"10m"`
+	// StoreTimeout is the timeout for store operations on ack and nack
+	StoreTimeout time.Duration `env:"QUEUE_DEDUP_STORE_TIMEOUT" env-default:"10s"`
+}
+
+// DedupByID uses the message ID assigned by the queue backend as the dedup key
+func DedupByID[T any](msg Message[T]) string {
+	return msg.ID
+}
+
+type dedupSubscriber[T any] struct {
+	sub       QueueSubscriber[T]
+	log       logrus.Ext1FieldLogger
+	store     DedupStore
+	namespace string
+	keyFn     func(Message[T]) string
+	conf      DedupConfig
+}
+
+// NewDedupSubscriber wraps sub so that messages with a key which has already been processed are acked and skipped.
+// Messages whose key is leased by a delivery which is still being handled are nacked, so that they are redelivered
+// and skipped if that delivery is acked, or processed if it is nacked.
+// keyFn derives the key from the message, such as DedupByID, or a field of the payload which is stable across
+// publishes, like GrantRequest.UUID. Keys are stored under namespace, so that several subscribers can share a store.
+// The key is released if the message is nacked, and kept for conf.TTL once it is acked.
+func NewDedupSubscriber[T any](log logrus.Ext1FieldLogger, sub QueueSubscriber[T], store DedupStore, namespace string,
+	keyFn func(Message[T]) string, conf DedupConfig) (QueueSubscriber[T], error) {
+	if conf.TTL <= 0 || conf.Lease <= 0 || conf.StoreTimeout <= 0 {
+		return nil, errors.New("ttl, lease and store timeout must be positive")
+	}
+	return &dedupSubscriber[T]{
+		sub:       sub,
+		log:       log,
+		store:     store,
+		namespace: namespace,
+		keyFn:     keyFn,
+		conf:      conf,
+	}, nil
+}
+
+func (ds *dedupSubscriber[T]) ReceiveCh(ctx context.Context) <-chan Message[T] {
+	outChan := make(chan Message[T])
+	go func() {
+		defer close(outChan)
+		for msg := range ds.sub.ReceiveCh(ctx) {
+			key := ds.namespace + "::" + ds.keyFn(msg)
+			state, err := ds.store.Claim(ctx, key, ds.conf.Lease)
+			if err != nil {
+				ds.log.WithError(err).WithField("key", key).Error("failed to check for duplicate message")
+				msg.Nack()
+				continue
+			}
+			switch state {
+			case DedupProcessed:
+				ds.log.WithField("key", key).Debug("skipping duplicate message")
+				msg.Ack()
+				continue
+			case DedupInProgress:
+				// Acking could ack the message which is being handled, so it is redelivered until that one is done
+				ds.log.WithField("key", key).Debug("delaying duplicate message which is being processed")
+				msg.Nack()
+				continue
+			}
+
+			msg.Msg = &dedupMessage{inner: msg.Msg, log: ds.log, store: ds.store, key: key, conf: ds.conf}
+			select {
+			case outChan <- msg:
+			case <-ctx.Done():
+				msg.Nack()
+			}
+		}
+	}()
+	return outChan
+}
+
+type dedupMessage struct {
+	inner UnderlyingMessage
+	log   logrus.Ext1FieldLogger
+	store DedupStore
+	key   string
+	conf  DedupConfig
+}
+
+func (dm *dedupMessage) Ack() {
+	ctx, cancel := context.WithTimeout(context.Background(), dm.conf.StoreTimeout)
+	defer cancel()
+	if err := dm.store.MarkProcessed(ctx, dm.key, dm.conf.TTL); err != nil {
+		// The message may be processed again once the lease expires
+		dm.log.WithError(err).WithField("key", dm.key).Error("failed to mark message as processed")
+	}
+	dm.inner.Ack()
+}
+
+func (dm *dedupMessage) Nack() {
+	ctx, cancel := context.WithTimeout(context.Background(), dm.conf.StoreTimeout)
+	defer cancel()
+	if err := dm.store.Release(ctx, dm.key); err != nil {
+		// Redeliveries are skipped until the lease expires
+		dm.log.WithError(err).WithField("key", dm.key).Error("failed to release message key")
+	}
+	dm.inner.Nack()
+}
+
+type memoryDedupEntry struct {
+	expiresAt time.Time
+	processed bool
+}
+
+type memoryDedupStore struct {
+	mux        sync.Mutex
+	entries    map[string]memoryDedupEntry
+	lastPurged time.Time
+	now        func() time.Time
+}
+
+// NewMemoryDedupStore creates a DedupStore which keeps keys in memory, so it only deduplicates within a process
+func NewMemoryDedupStore() DedupStore {
+	return &memoryDedupStore{entries: map[string]memoryDedupEntry{}, now: time.Now}
+}
+
+func (mds *memoryDedupStore) Claim(_ context.Context, key string, ttl time.Duration) (DedupState, error) {
+	mds.mux.Lock()
+	defer mds.mux.Unlock()
+	now := mds.now()
+	if now.Sub(mds.lastPurged) > dedupPurgeInterval {
+		for k, entry := range mds.entries {
+			if !entry.expiresAt.After(now) {
+				delete(mds.entries, k)
+			}
+		}
+		mds.lastPurged = now
+	}
+
+	if entry, ok := mds.entries[key]; ok && entry.expiresAt.After(now) {
+		if entry.processed {
+			return DedupProcessed, nil
+		}
+		return DedupInProgress, nil
+	}
+	mds.entries[key] = memoryDedupEntry{expiresAt: now.Add(ttl)}
+	return DedupClaimed, nil
+}
+
+func (mds *memoryDedupStore) MarkProcessed(_ context.Context, key string, ttl time.Duration) error {
+	mds.mux.Lock()
+	defer mds.mux.Unlock()
+	mds.entries[key] = memoryDedupEntry{expiresAt: mds.now().Add(ttl), processed: true}
+	return nil
+}
+
+func (mds *memoryDedupStore) Release(_ context.Context, key string) error {
+	mds.mux.Lock()
+	defer mds.mux.Unlock()
+	delete(mds.entries, key)
+	return nil
+}
+
+type postgresDedupStore struct {
+	claim         *sqlx.Stmt
+	markProcessed *sqlx.Stmt
+	release       *sqlx.Stmt
+	purge         *sqlx.Stmt
+	lastPurged    atomic.Int64
+}
+
+// NewPostgresDedupStore creates a DedupStore backed by the queue_dedup table, which deduplicates across processes
+func NewPostgresDedupStore(sdb *sqlx.DB) (DedupStore, error) {
+	// The state of the existing row is read from the snapshot before the insert, so it is only used when the claim fails
+	claim, err := sdb.Preparex(`WITH claimed AS (
+			INSERT INTO queue_dedup (key, expires_at) VALUES ($1, now() + make_interval(secs => $2))
+			ON CONFLICT (key) DO UPDATE SET expires_at = EXCLUDED.expires_at, processed = false
+				WHERE queue_dedup.expires_at <= now()
+			RETURNING key
+		)
+		SELECT EXISTS (SELECT 1 FROM claimed) AS claimed,
+			COALESCE((SELECT processed FROM queue_dedup WHERE key = $1), false) AS processed`)
+	if err != nil {
+		return nil, err
+	}
+	markProcessed, err := sdb.Preparex("UPDATE queue_dedup SET expires_at = now() + make_interval(secs => $2), processed = true WHERE key = $1")
+	if err != nil {
+		return nil, err
+	}
+	release, err := sdb.Preparex("DELETE FROM queue_dedup WHERE key = $1")
+	if err != nil {
+		return nil, err
+	}
+	purge, err := sdb.Preparex("DELETE FROM queue_dedup WHERE expires_at <= now()")
+	if err != nil {
+		return nil, err
+	}
+	return &postgresDedupStore{claim: claim, markProcessed: markProcessed, release: release, purge: purge}, nil
+}
+
+// Claim also deletes expired keys, at most once every dedupPurgeInterval. Purge errors are only logged, as they do
+// not affect the claim.
+func (pds *postgresDedupStore) Claim(ctx context.Context, key string, ttl time.Duration) (DedupState, error) {
+	now := time.Now().UnixNano()
+	if last := pds.lastPurged.Load(); now-last > int64(dedupPurgeInterval) && pds.lastPurged.CompareAndSwap(last, now) {
+		if _, err := pds.purge.ExecContext(ctx); err != nil {
+			logrus.WithError(err).Warn("failed to purge expired dedup keys")
+		}
+	}
+
+	var row struct {
+		Claimed   bool `db:"claimed"`
+		Processed bool `db:"processed"`
+	}
+	if err := pds.claim.GetContext(ctx, &row, key, ttl.Seconds()); err != nil {
+		return DedupInProgress, err
+	}
+	switch {
+	case row.Claimed:
+		return DedupClaimed, nil
+	case row.Processed:
+		return DedupProcessed, nil
+	default:
+		return DedupInProgress, nil
+	}
+}
+
+func (pds *postgresDedupStore) MarkProcessed(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := pds.markProcessed.ExecContext(ctx, key, ttl.Seconds())
+	return err
+}
+
+func (pds *postgresDedupStore) Release(ctx context.Context, key string) error {
+	_, err := pds.release.ExecContext(ctx, key)
+	return err
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore()
+	now := time.Now()
+	store.(*memoryDedupStore).now = func() time.Time { return now }
+
+	state, err := store.Claim(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, DedupClaimed, state)
+	state, err = store.Claim(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, DedupInProgress, state)
+
+	// The lease expires
+	now = now.Add(2 * time.Minute)
+	state, err = store.Claim(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, DedupClaimed, state)
+
+	require.NoError(t, store.MarkProcessed(ctx, "a", time.Hour))
+	now = now.Add(30 * time.Minute)
+	state, err = store.Claim(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, DedupProcessed, state)
+
+	require.NoError(t, store.Release(ctx, "a"))
+	state, err = store.Claim(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, DedupClaimed, state)
+}
+
+func Test_DedupSubscriber(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type grant struct {
+		UUID   string
+		Amount int
+	}
+	mq := NewMemoryQueue[grant](10)
+	sub, err := NewDedupSubscriber[grant](logrus.New(), mq, NewMemoryDedupStore(), "grants",
+		func(msg Message[grant]) string { return msg.Data.UUID },
+		DedupConfig{TTL: time.Hour, Lease: time.Minute, StoreTimeout: time.Second})
+	require.NoError(t, err)
+
+	require.NoError(t, mq.Publish(ctx, grant{UUID: "1", Amount: 10}))
+	require.NoError(t, mq.Publish(ctx, grant{UUID: "2", Amount: 20}))
+	require.NoError(t, mq.Publish(ctx, grant{UUID: "1", Amount: 10})) // Duplicate
+
+	recvCtx, recvCancel := context.WithCancel(ctx)
+	ch := sub.ReceiveCh(recvCtx)
+
+	msg := <-ch
+	require.Equal(t, "1", msg.Data.UUID)
+	msg.Ack()
+
+	msg = <-ch
+	require.Equal(t, "2", msg.Data.UUID)
+	msg.Nack() // Released, so the redelivery is processed
+
+	msg = <-ch
+	require.Equal(t, "2", msg.Data.UUID)
+	require.Equal(t, 2, msg.DeliveryAttempt)
+	msg.Ack()
+
+	require.NoError(t, mq.Publish(ctx, grant{UUID: "3", Amount: 30}))
+	msg = <-ch
+	require.Equal(t, "3", msg.Data.UUID, "the duplicate of 1 should have been skipped")
+	msg.Ack()
+	require.Equal(t, 0, mq.Len())
+
+	recvCancel()
+	for range ch {
+	}
+}
+
+// recordedMessage records whether it was acked or nacked
+type recordedMessage struct {
+	acked, nacked bool
+}
+
+func (rm *recordedMessage) Ack()  { rm.acked = true }
+func (rm *recordedMessage) Nack() { rm.nacked = true }
+
+type chanSubscriber[T any] chan Message[T]
+
+func (cs chanSubscriber[T]) ReceiveCh(context.Context) <-chan Message[T] {
+	return cs
+}
+
+func Test_DedupSubscriber_InProgress(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore()
+	msgs := make(chanSubscriber[string], 2)
+	sub, err := NewDedupSubscriber[string](logrus.New(), msgs, store, "ns", DedupByID[string],
+		DedupConfig{TTL: time.Hour, Lease: time.Minute, StoreTimeout: time.Second})
+	require.NoError(t, err)
+
+	// Another delivery of 1 holds the lease, so acking this one could lose the message if that delivery fails
+	state, err := store.Claim(ctx, "ns::1", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, DedupClaimed, state)
+	require.NoError(t, store.MarkProcessed(ctx, "ns::2", time.Hour))
+
+	inProgress, processed := &recordedMessage{}, &recordedMessage{}
+	msgs <- Message[string]{ID: "1", Msg: inProgress}
+	msgs <- Message[string]{ID: "2", Msg: processed}
+	close(msgs)
+	for range sub.ReceiveCh(ctx) {
+		t.Fatal("duplicates should not be delivered")
+	}
+	require.Equal(t, &recordedMessage{nacked: true}, inProgress)
+	require.Equal(t, &recordedMessage{acked: true}, processed)
+}
@@ -2,11 +2,13 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
+	"sync/atomic"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/cockroachdb/errors"
 	"github.com/sirupsen/logrus"
+
+	"github.com/usecorn/common-lib/app"
 )
 
 type GooglePubSubClient interface {
@@ -42,69 +44,167 @@ func NewGooglePubSubSubscription(ctx context.Context, pubSubClient GooglePubSubC
 	return subscript, nil
 }
 
+// ParseGooglePubSubMsg decodes the message with the codec matching its content-type attribute, out of DefaultCodecs.
 func ParseGooglePubSubMsg[T any](msg *pubsub.Message) (Message[T], error) {
+	return parseGooglePubSubMsg[T](msg, DefaultCodecs())
+}
+
+func parseGooglePubSubMsg[T any](msg *pubsub.Message, codecs []Codec) (Message[T], error) {
 	var t T
-	err := json.Unmarshal(msg.Data, &t)
+	err := decodeMessage(codecs, msg.Attributes, msg.Data, &t)
 	if err != nil {
 		return Message[T]{}, err
 	}
-	return Message[T]{Data: t, Msg: msg}, nil
+	out := Message[T]{ID: msg.ID, Data: t, Attributes: msg.Attributes, OrderingKey: msg.OrderingKey, Msg: msg}
+	if msg.DeliveryAttempt != nil {
+		out.DeliveryAttempt = *msg.DeliveryAttempt
+	}
+	return out, nil
 }
 
 type gcpPubSubPublisher[T any] struct {
 	topic *pubsub.Topic
 	log   logrus.Ext1FieldLogger
+	opts  PublisherOptions
 }
 
+// NewGooglePubSubPublisher creates a publisher for the topic which encodes messages as JSON. Message ordering is not
+// enabled, see NewGooglePubSubPublisherWithOptions to publish messages with an ordering key.
 func NewGooglePubSubPublisher[T any](ctx context.Context, log logrus.Ext1FieldLogger, pubSubClient GooglePubSubClient, id string) (QueuePublisher[T], error) {
+	return NewGooglePubSubPublisherWithOptions[T](ctx, log, pubSubClient, id, PublisherOptions{})
+}
+
+// NewGooglePubSubPublisherWithOptions creates a publisher for the topic which encodes messages with opts.Codec. With
+// opts.EnableMessageOrdering, messages with an ordering key are delivered in order to subscriptions which have message
+// ordering enabled.
+func NewGooglePubSubPublisherWithOptions[T any](ctx context.Context, log logrus.Ext1FieldLogger, pubSubClient GooglePubSubClient,
+	id string, opts PublisherOptions) (QueuePublisher[T], error) {
 
 	topic, err := NewGooglePubSubTopic(ctx, pubSubClient, id)
 	if err != nil {
 		return nil, err
 	}
-	return &gcpPubSubPublisher[T]{topic: topic, log: log}, nil
+	topic.EnableMessageOrdering = opts.EnableMessageOrdering
+	return &gcpPubSubPublisher[T]{topic: topic, log: log, opts: opts.withDefaults()}, nil
 }
 
 func (pub *gcpPubSubPublisher[T]) Publish(ctx context.Context, data T) error {
-	bytes, err := json.Marshal(data)
-	if err != nil {
-		return err
+	return pub.PublishMessage(ctx, OutgoingMessage[T]{Data: data})
+}
+
+func (pub *gcpPubSubPublisher[T]) PublishMessage(ctx context.Context, msg OutgoingMessage[T]) error {
+	return pub.PublishBatch(ctx, []OutgoingMessage[T]{msg})
+}
+
+// PublishBatch publishes all the messages before waiting for any of the results, so that the client can batch them.
+func (pub *gcpPubSubPublisher[T]) PublishBatch(ctx context.Context, msgs []OutgoingMessage[T]) error {
+	results := make([]*pubsub.PublishResult, len(msgs))
+	errs := map[int]error{}
+	for i := range msgs {
+		bytes, err := pub.opts.Codec.Marshal(msgs[i].Data)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		results[i] = pub.topic.Publish(ctx, &pubsub.Message{
+			Data:        bytes,
+			Attributes:  withContentType(msgs[i].Attributes, pub.opts.Codec),
+			OrderingKey: msgs[i].OrderingKey,
+		})
+	}
+
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		if _, err := result.Get(ctx); err != nil {
+			errs[i] = err
+			if msgs[i].OrderingKey != "" {
+				// Publishing is paused for an ordering key after a failure, until it is resumed
+				pub.topic.ResumePublish(msgs[i].OrderingKey)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(msgs) == 1 {
+		return errs[0]
 	}
-	_, err = pub.topic.Publish(ctx, &pubsub.Message{Data: bytes}).Get(ctx)
-	return err
+	return &PublishBatchError{Errors: errs}
 }
 
 type gcpPubSubSubscriber[T any] struct {
-	sub     *pubsub.Subscription
-	log     logrus.Ext1FieldLogger
-	workers int
+	sub  *pubsub.Subscription
+	log  logrus.Ext1FieldLogger
+	opts SubscriberOptions
 }
 
 func NewGooglePubSubSubscriber[T any](ctx context.Context, log logrus.Ext1FieldLogger, pubSubClient GooglePubSubClient, subID string) (QueueSubscriber[T], error) {
+	return NewGooglePubSubSubscriberWithOptions[T](ctx, log, pubSubClient, subID, SubscriberOptions{})
+}
+
+// NewGooglePubSubSubscriberWithOptions creates a subscriber which retries Receive failures with backoff and sends
+// messages which cannot be parsed to opts.DeadLetter. Delivery attempts are only known if the subscription has a
+// dead letter policy, otherwise unparseable messages are nacked until they are dead-lettered.
+func NewGooglePubSubSubscriberWithOptions[T any](ctx context.Context, log logrus.Ext1FieldLogger, pubSubClient GooglePubSubClient,
+	subID string, opts SubscriberOptions) (QueueSubscriber[T], error) {
 	sub, err := NewGooglePubSubSubscription(ctx, pubSubClient, subID)
 	if err != nil {
 		return nil, err
 	}
-	return &gcpPubSubSubscriber[T]{sub: sub, log: log}, nil
+	return &gcpPubSubSubscriber[T]{sub: sub, log: log, opts: opts.withDefaults()}, nil
 }
 
 func (sub *gcpPubSubSubscriber[T]) ReceiveCh(ctx context.Context) <-chan Message[T] {
-	outChan := make(chan Message[T], sub.workers)
+	outChan := make(chan Message[T])
 	go func() {
-		err := sub.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-			parsedMessage, err := ParseGooglePubSubMsg[T](msg)
-			if err != nil {
-				sub.log.WithError(err).Error("failed to unmarshal pubsub message into json")
-				msg.Nack()
+		defer close(outChan)
+		for attempts := 1; ; attempts++ {
+			var received atomic.Bool
+			err := sub.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+				received.Store(true)
+				sub.handle(ctx, outChan, msg)
+			})
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			if received.Load() { // The subscription was healthy before failing, so start backing off from scratch
+				attempts = 1
+			}
+			err = errors.Wrapf(err, "failed to receive from subscription %s", sub.sub.ID())
+			if !sub.opts.Retry.ShouldRetry(attempts) {
+				sub.opts.reportError(sub.log, errors.WithSecondaryError(errors.Wrapf(ErrMaxAttemptsExceeded, "%d attempts", attempts), err))
+				return
+			}
+			backoff := sub.opts.Retry.Backoff(attempts)
+			sub.log.WithError(err).WithField("backoff", backoff).Warn("retrying subscription")
+			if app.SleepContext(ctx, backoff) != nil {
 				return
 			}
-			outChan <- parsedMessage
-		})
-		if err != nil {
-			sub.log.WithError(err).Panic("failed to subscribe to subscription")
 		}
-		close(outChan)
 	}()
 	return outChan
+}
 
+func (sub *gcpPubSubSubscriber[T]) handle(ctx context.Context, outChan chan<- Message[T], msg *pubsub.Message) {
+	parsedMessage, err := parseGooglePubSubMsg[T](msg, sub.opts.Codecs)
+	if err != nil {
+		attempts := 0
+		if msg.DeliveryAttempt != nil {
+			attempts = *msg.DeliveryAttempt
+		}
+		err = errors.Wrapf(err, "failed to decode pubsub message %s", msg.ID)
+		if sub.opts.giveUp(ctx, sub.log, sub.sub.ID(), msg.Data, attempts, err) {
+			msg.Ack()
+		} else {
+			msg.Nack()
+		}
+		return
+	}
+	select {
+	case outChan <- parsedMessage:
+	case <-ctx.Done():
+		msg.Nack()
+	}
 }
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newTestPubSub starts a fake Pub/Sub server with a topic called "topic" and a subscription to it called "sub"
+func newTestPubSub(ctx context.Context, t *testing.T, subConf pubsub.SubscriptionConfig) (*pubsub.Client, *pubsub.Topic) {
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	client, err := pubsub.NewClient(ctx, "project", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	topic, err := client.CreateTopic(ctx, "topic")
+	require.NoError(t, err)
+	subConf.Topic = topic
+	_, err = client.CreateSubscription(ctx, "sub", subConf)
+	require.NoError(t, err)
+	return client, topic
+}
+
+func Test_GooglePubSubPublisher_PublishBatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, _ := newTestPubSub(ctx, t, pubsub.SubscriptionConfig{EnableMessageOrdering: true})
+
+	pub, err := NewGooglePubSubPublisherWithOptions[int](ctx, logrus.New(), client, "topic", PublisherOptions{EnableMessageOrdering: true})
+	require.NoError(t, err)
+	sub, err := NewGooglePubSubSubscriber[int](ctx, logrus.New(), client, "sub")
+	require.NoError(t, err)
+
+	msgs := make([]OutgoingMessage[int], 10)
+	for i := range msgs {
+		msgs[i] = OutgoingMessage[int]{
+			Data:        i,
+			Attributes:  map[string]string{"source": "test"},
+			OrderingKey: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		}
+	}
+	require.NoError(t, pub.PublishBatch(ctx, msgs))
+
+	recvCtx, recvCancel := context.WithCancel(ctx)
+	ch := sub.ReceiveCh(recvCtx)
+	received := []int{}
+	for range msgs {
+		msg := <-ch
+		require.Equal(t, "test", msg.Attributes["source"])
+		require.Equal(t, msgs[0].OrderingKey, msg.OrderingKey)
+		received = append(received, msg.Data)
+		msg.Ack()
+	}
+	require.True(t, sort.IntsAreSorted(received), "messages with the same ordering key should be received in order")
+
+	recvCancel()
+	for range ch {
+	}
+}
+
+func Test_PublishBatchError(t *testing.T) {
+	mq := NewMemoryQueue[int](2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := mq.PublishBatch(ctx, []OutgoingMessage[int]{{Data: 1}, {Data: 2}, {Data: 3}, {Data: 4}})
+	var batchErr *PublishBatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr.Errors, 2)
+	require.Contains(t, batchErr.Errors, 2)
+	require.Contains(t, batchErr.Errors, 3)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
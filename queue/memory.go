@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryQueue is an in-memory, channel based queue which implements both QueuePublisher and QueueSubscriber.
+// It is useful for tests and for single binary deployments where publisher and subscriber run in the same process.
+// Messages are delivered to exactly one receiver, and are redelivered after a backoff if they are Nacked. Ordering keys
+// are kept on the message but are not enforced, as Nacked messages go to the back of the queue. Nacked messages which
+// no longer fit in the queue are dead-lettered, or dropped, with ErrQueueFull.
+type MemoryQueue[T any] struct {
+	ch     chan memoryEntry[T]
+	nextID atomic.Int64
+	log    logrus.Ext1FieldLogger
+	opts   SubscriberOptions
+}
+
+// ErrQueueFull is the reason a nacked MemoryQueue message is dead-lettered when the queue has no room for it
+var ErrQueueFull = errors.New("queue is full")
+
+type memoryEntry[T any] struct {
+	id       string
+	msg      OutgoingMessage[T]
+	attempts int
+}
+
+// NewMemoryQueue creates a new MemoryQueue which can hold up to bufferSize messages before Publish blocks.
+// Nacked messages are retried with the DefaultRetryPolicy.
+func NewMemoryQueue[T any](bufferSize int) *MemoryQueue[T] {
+	return NewMemoryQueueWithOptions[T](logrus.StandardLogger(), bufferSize, SubscriberOptions{})
+}
+
+// NewMemoryQueueWithOptions creates a MemoryQueue like NewMemoryQueue. Nacked messages are redelivered after the
+// backoff from opts.Retry, and are dead-lettered, JSON encoded, once they run out of attempts.
+func NewMemoryQueueWithOptions[T any](log logrus.Ext1FieldLogger, bufferSize int, opts SubscriberOptions) *MemoryQueue[T] {
+	return &MemoryQueue[T]{ch: make(chan memoryEntry[T], bufferSize), log: log, opts: opts.withDefaults()}
+}
+
+// Publish adds the message to the queue, blocking if the queue is full until ctx is cancelled.
+func (mq *MemoryQueue[T]) Publish(ctx context.Context, data T) error {
+	return mq.PublishMessage(ctx, OutgoingMessage[T]{Data: data})
+}
+
+func (mq *MemoryQueue[T]) PublishMessage(ctx context.Context, msg OutgoingMessage[T]) error {
+	select {
+	case mq.ch <- memoryEntry[T]{id: strconv.FormatInt(mq.nextID.Add(1), 10), msg: msg}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (mq *MemoryQueue[T]) PublishBatch(ctx context.Context, msgs []OutgoingMessage[T]) error {
+	for i := range msgs {
+		if err := mq.PublishMessage(ctx, msgs[i]); err != nil {
+			errs := map[int]error{}
+			for j := i; j < len(msgs); j++ {
+				errs[j] = err
+			}
+			return &PublishBatchError{Errors: errs}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of messages waiting in the queue.
+func (mq *MemoryQueue[T]) Len() int {
+	return len(mq.ch)
+}
+
+// ReceiveCh returns a channel which receives messages from the queue, until ctx is cancelled.
+func (mq *MemoryQueue[T]) ReceiveCh(ctx context.Context) <-chan Message[T] {
+	outChan := make(chan Message[T])
+	go func() {
+		defer close(outChan)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry := <-mq.ch:
+				entry.attempts++
+				msg := Message[T]{
+					ID:              entry.id,
+					Data:            entry.msg.Data,
+					Attributes:      entry.msg.Attributes,
+					OrderingKey:     entry.msg.OrderingKey,
+					DeliveryAttempt: entry.attempts,
+					Msg:             &memoryMessage[T]{queue: mq, entry: entry},
+				}
+				select {
+				case outChan <- msg:
+				case <-ctx.Done():
+					entry.attempts-- // Never delivered
+					mq.requeue(entry)
+					return
+				}
+			}
+		}
+	}()
+	return outChan
+}
+
+// requeue puts entry back at the end of the queue, or gives up on it if the queue is full
+func (mq *MemoryQueue[T]) requeue(entry memoryEntry[T]) {
+	select {
+	case mq.ch <- entry:
+	default:
+		mq.giveUp(entry, errors.Wrapf(ErrQueueFull, "failed to requeue message %s", entry.id))
+	}
+}
+
+// giveUp dead-letters entry, encoded with the codec of its content type, if it has run out of attempts or err is set.
+// It returns false if the entry should be retried.
+func (mq *MemoryQueue[T]) giveUp(entry memoryEntry[T], err error) bool {
+	codec, codecErr := codecFor(mq.opts.Codecs, entry.msg.Attributes)
+	if codecErr != nil {
+		mq.opts.reportError(mq.log, codecErr)
+		codec = JSONCodec
+	}
+	data, marshalErr := codec.Marshal(entry.msg.Data)
+	if marshalErr != nil {
+		mq.opts.reportError(mq.log, errors.Wrapf(marshalErr, "failed to encode message %s", entry.id))
+	}
+	return mq.opts.giveUp(context.Background(), mq.log, "memory", data, entry.attempts, err)
+}
+
+type memoryMessage[T any] struct {
+	queue *MemoryQueue[T]
+	entry memoryEntry[T]
+	done  atomic.Bool
+}
+
+func (mm *memoryMessage[T]) Ack() {
+	mm.done.Store(true)
+}
+
+func (mm *memoryMessage[T]) Nack() {
+	if mm.done.Swap(true) {
+		return // Already acked or nacked
+	}
+	mq := mm.queue
+	if mq.giveUp(mm.entry, nil) {
+		return
+	}
+	time.AfterFunc(mq.opts.Retry.Backoff(mm.entry.attempts), func() { mq.requeue(mm.entry) })
+}
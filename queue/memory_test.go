@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MemoryQueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mq := NewMemoryQueue[int](10)
+	var pub QueuePublisher[int] = mq
+	var sub QueueSubscriber[int] = mq
+
+	for i := range 3 {
+		require.NoError(t, pub.Publish(ctx, i))
+	}
+	require.Equal(t, 3, mq.Len())
+
+	recvCtx, recvCancel := context.WithCancel(ctx)
+	ch := sub.ReceiveCh(recvCtx)
+
+	first := <-ch
+	require.Equal(t, 0, first.Data)
+	first.Nack() // Should be redelivered after the others
+
+	received := []int{}
+	for range 3 {
+		msg := <-ch
+		received = append(received, msg.Data)
+		msg.Ack()
+	}
+	require.Equal(t, []int{1, 2, 0}, received)
+
+	recvCancel()
+	_, ok := <-ch
+	require.False(t, ok, "channel should be closed when the context is cancelled")
+}
+
+func Test_MemoryQueue_PublishFull(t *testing.T) {
+	mq := NewMemoryQueue[string](1)
+	require.NoError(t, mq.Publish(context.Background(), "a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, mq.Publish(ctx, "b"), context.DeadlineExceeded)
+}
+
+func Test_MemoryQueue_Attributes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mq := NewMemoryQueue[string](1)
+	require.NoError(t, mq.PublishMessage(ctx, OutgoingMessage[string]{Data: "a", Attributes: map[string]string{"k": "v"}, OrderingKey: "key"}))
+
+	ch := mq.ReceiveCh(ctx)
+	msg := <-ch
+	require.Equal(t, map[string]string{"k": "v"}, msg.Attributes)
+	require.Equal(t, "key", msg.OrderingKey)
+	require.Equal(t, 1, msg.DeliveryAttempt)
+	msg.Nack()
+
+	msg = <-ch
+	require.Equal(t, "a", msg.Data)
+	require.Equal(t, 2, msg.DeliveryAttempt)
+	msg.Ack()
+}
+
+func Test_MemoryQueue_Retry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dlq := NewMemoryQueue[DeadLetter](1)
+	mq := NewMemoryQueueWithOptions[int](logrus.New(), 1, SubscriberOptions{
+		Retry:      RetryPolicy{MaxAttempts: 3, InitialBackoff: 20 * time.Millisecond},
+		DeadLetter: dlq,
+	})
+	require.NoError(t, mq.Publish(ctx, 42))
+
+	ch := mq.ReceiveCh(ctx)
+	var nackedAt time.Time
+	for attempt := 1; attempt <= 3; attempt++ {
+		msg := <-ch
+		require.Equal(t, attempt, msg.DeliveryAttempt)
+		if attempt > 1 {
+			require.GreaterOrEqual(t, time.Since(nackedAt), 20*time.Millisecond, "redelivered before the backoff")
+		}
+		nackedAt = time.Now()
+		msg.Nack()
+	}
+
+	// Dead-lettered after the last attempt, rather than redelivered
+	dead := <-dlq.ReceiveCh(ctx)
+	require.Equal(t, 3, dead.Data.Attempts)
+	require.JSONEq(t, "42", string(dead.Data.Data))
+	select {
+	case msg := <-ch:
+		t.Fatalf("unexpected redelivery of %d", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_MemoryQueue_RequeueFull(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dlq := NewMemoryQueue[DeadLetter](1)
+	mq := NewMemoryQueueWithOptions[int](logrus.New(), 1, SubscriberOptions{
+		Retry:      RetryPolicy{InitialBackoff: time.Millisecond},
+		DeadLetter: dlq,
+	})
+	require.NoError(t, mq.PublishMessage(ctx, OutgoingMessage[int]{Data: 42,
+		Attributes: map[string]string{ContentTypeAttribute: ContentTypeCBOR}}))
+
+	recvCtx, recvCancel := context.WithCancel(ctx)
+	ch := mq.ReceiveCh(recvCtx)
+	msg := <-ch
+	recvCancel()
+	for range ch {
+	}
+	require.NoError(t, mq.Publish(ctx, 43))
+
+	// There is no room to redeliver the nacked message, so it is dead-lettered, with its own content type
+	msg.Nack()
+	dead := <-dlq.ReceiveCh(ctx)
+	require.Contains(t, dead.Data.Error, ErrQueueFull.Error())
+	var data int
+	require.NoError(t, CBORCodec.Unmarshal(dead.Data.Data, &data))
+	require.Equal(t, 42, data)
+	require.Equal(t, 1, mq.Len())
+}
@@ -0,0 +1,269 @@
+package queue
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"github.com/usecorn/common-lib/app"
+)
+
+// OutboxSchema is the table used by the transactional outbox, messages for every queue share the same table.
+const OutboxSchema = `CREATE TABLE IF NOT EXISTS queue_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	queue TEXT NOT NULL,
+	data BYTEA NOT NULL,
+	attributes JSONB NOT NULL DEFAULT '{}',
+	ordering_key TEXT NOT NULL DEFAULT '',
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	available_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	sent_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS queue_outbox_unsent_idx ON queue_outbox (queue, available_at) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS queue_outbox_sent_at_idx ON queue_outbox (queue, sent_at) WHERE sent_at IS NOT NULL;`
+
+// OutboxWriter adds messages to the outbox inside the caller's transaction, so that they are published by an
+// OutboxRelay if and only if the transaction commits.
+type OutboxWriter[T any] interface {
+	Enqueue(ctx context.Context, tx *sqlx.Tx, data T) error
+	EnqueueMessages(ctx context.Context, tx *sqlx.Tx, msgs ...OutgoingMessage[T]) error
+}
+
+type outboxWriter[T any] struct {
+	queue string
+	opts  PublisherOptions
+}
+
+// NewOutboxWriter creates an OutboxWriter for messages which will be relayed to queue, encoded with opts.Codec.
+func NewOutboxWriter[T any](queue string, opts PublisherOptions) OutboxWriter[T] {
+	return &outboxWriter[T]{queue: queue, opts: opts.withDefaults()}
+}
+
+func (ow *outboxWriter[T]) Enqueue(ctx context.Context, tx *sqlx.Tx, data T) error {
+	return ow.EnqueueMessages(ctx, tx, OutgoingMessage[T]{Data: data})
+}
+
+func (ow *outboxWriter[T]) EnqueueMessages(ctx context.Context, tx *sqlx.Tx, msgs ...OutgoingMessage[T]) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	rows, err := encodePostgresRows(ow.queue, ow.opts.Codec, msgs)
+	if err != nil {
+		return err
+	}
+	return insertPostgresRows(ctx, tx, "queue_outbox", rows)
+}
+
+// OutboxRelayConfig configures how an OutboxRelay polls the outbox and retries failed messages
+type OutboxRelayConfig struct {
+	// PollInterval is how long to wait before polling again when there is nothing to relay
+	PollInterval time.Duration `env:"OUTBOX_POLL_INTERVAL" env-default:"1s"`
+	// BatchSize is the maximum number of messages published at once
+	BatchSize int `env:"OUTBOX_BATCH_SIZE" env-default:"100"`
+	// Lease is how long claimed messages are hidden from other relays while they are being published
+	Lease time.Duration `env:"OUTBOX_LEASE" env-default:"1m"`
+	// InitialBackoff is the backoff after the first failed publish of a message, doubling up to MaxBackoff
+	InitialBackoff time.Duration `env:"OUTBOX_INITIAL_BACKOFF" env-default:"1s"`
+	MaxBackoff     time.Duration `env:"OUTBOX_MAX_BACKOFF" env-default:"5m"`
+	// MaxAttempts stops retrying a message after this many attempts, leaving it in the outbox with its last error.
+	// As messages with the same ordering key are relayed in order, it also blocks the messages after it. 0 retries forever.
+	MaxAttempts int `env:"OUTBOX_MAX_ATTEMPTS" env-default:"0"`
+	// Retention is how long sent messages are kept in the outbox before they are deleted
+	Retention time.Duration `env:"OUTBOX_RETENTION" env-default:"24h"`
+}
+
+func (conf OutboxRelayConfig) retryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    conf.MaxAttempts,
+		InitialBackoff: conf.InitialBackoff,
+		MaxBackoff:     conf.MaxBackoff,
+		Multiplier:     2,
+	}
+}
+
+// OutboxRelay publishes the messages in the outbox
+type OutboxRelay interface {
+	// Run relays messages until ctx is cancelled
+	Run(ctx context.Context) error
+}
+
+type outboxRelay[T any] struct {
+	queue  string
+	log    logrus.Ext1FieldLogger
+	sdb    *sqlx.DB
+	pub    QueuePublisher[T]
+	conf   OutboxRelayConfig
+	codecs []Codec
+	claim  *sqlx.Stmt
+	fail   *sqlx.Stmt
+	purge  *sqlx.Stmt
+}
+
+type outboxRow struct {
+	ID          int64  `db:"id"`
+	Data        []byte `db:"data"`
+	Attributes  []byte `db:"attributes"`
+	OrderingKey string `db:"ordering_key"`
+	Attempts    int    `db:"attempts"`
+}
+
+// NewOutboxRelay creates an OutboxRelay which publishes the outbox messages for queue through pub. Any number of
+// relays can run for the same queue, as messages are claimed with FOR UPDATE SKIP LOCKED, and messages with the same
+// ordering key are only published once all the earlier ones have been sent.
+func NewOutboxRelay[T any](log logrus.Ext1FieldLogger, sdb *sqlx.DB, queue string, pub QueuePublisher[T], conf OutboxRelayConfig) (OutboxRelay, error) {
+	if conf.BatchSize <= 0 {
+		return nil, errors.New("batch size must be positive")
+	}
+	if conf.PollInterval <= 0 || conf.Lease <= 0 || conf.InitialBackoff <= 0 {
+		return nil, errors.New("poll interval, lease and initial backoff must be positive")
+	}
+	claim, err := sdb.Preparex(`UPDATE queue_outbox SET attempts = attempts + 1, available_at = now() + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT id FROM queue_outbox o WHERE queue = $1 AND sent_at IS NULL AND available_at <= now()
+			AND ($4 = 0 OR attempts < $4)
+			AND (ordering_key = '' OR NOT EXISTS (
+				SELECT 1 FROM queue_outbox prev WHERE prev.queue = o.queue AND prev.ordering_key = o.ordering_key
+				AND prev.sent_at IS NULL AND prev.id < o.id
+			))
+			ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED
+		) RETURNING id, data, attributes, ordering_key, attempts`)
+	if err != nil {
+		return nil, err
+	}
+	fail, err := sdb.Preparex("UPDATE queue_outbox SET available_at = now() + make_interval(secs => $2), last_error = $3 WHERE id = $1")
+	if err != nil {
+		return nil, err
+	}
+	purge, err := sdb.Preparex("DELETE FROM queue_outbox WHERE queue = $1 AND sent_at < now() - make_interval(secs => $2)")
+	if err != nil {
+		return nil, err
+	}
+	return &outboxRelay[T]{
+		queue:  queue,
+		log:    log.WithField("outbox", queue),
+		sdb:    sdb,
+		pub:    pub,
+		conf:   conf,
+		codecs: DefaultCodecs(),
+		claim:  claim,
+		fail:   fail,
+		purge:  purge,
+	}, nil
+}
+
+func (relay *outboxRelay[T]) Run(ctx context.Context) error {
+	for {
+		n, err := relay.relayBatch(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			relay.log.WithError(err).Error("failed to relay outbox messages")
+		}
+		if n != 0 && err == nil {
+			continue // There may be more messages waiting
+		}
+
+		if _, err := relay.purge.ExecContext(ctx, relay.queue, relay.conf.Retention.Seconds()); err != nil && ctx.Err() == nil {
+			relay.log.WithError(err).Error("failed to purge sent outbox messages")
+		}
+		if app.SleepContext(ctx, relay.conf.PollInterval) != nil {
+			return nil
+		}
+	}
+}
+
+// relayBatch claims and publishes a batch of messages, returning how many were claimed
+func (relay *outboxRelay[T]) relayBatch(ctx context.Context) (int, error) {
+	var rows []outboxRow
+	err := relay.claim.SelectContext(ctx, &rows, relay.queue, relay.conf.BatchSize, relay.conf.Lease.Seconds(), relay.conf.MaxAttempts)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to claim outbox messages")
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	sent, failed := relayOutboxRows(ctx, relay.pub, relay.codecs, rows)
+	if len(sent) != 0 {
+		query, args, err := sqlx.In("UPDATE queue_outbox SET sent_at = now(), last_error = '' WHERE id IN (?)", sent)
+		if err != nil {
+			return len(rows), err
+		}
+		// If this fails, the messages are published again once the lease expires
+		if _, err = relay.sdb.ExecContext(ctx, relay.sdb.Rebind(query), args...); err != nil {
+			return len(rows), errors.Wrap(err, "failed to mark outbox messages as sent")
+		}
+	}
+
+	retry := relay.conf.retryPolicy()
+	for i := range rows {
+		relayErr, ok := failed[rows[i].ID]
+		if !ok {
+			continue
+		}
+		backoff := retry.Backoff(rows[i].Attempts)
+		relay.log.WithError(relayErr).WithField("id", rows[i].ID).WithField("backoff", backoff).Warn("failed to relay outbox message")
+		if _, err := relay.fail.ExecContext(ctx, rows[i].ID, backoff.Seconds(), relayErr.Error()); err != nil {
+			return len(rows), errors.Wrap(err, "failed to record outbox message failure")
+		}
+	}
+	return len(rows), nil
+}
+
+// relayOutboxRows decodes and publishes the rows in order, returning the ids which were sent and the errors for those which were not
+func relayOutboxRows[T any](ctx context.Context, pub QueuePublisher[T], codecs []Codec, rows []outboxRow) ([]int64, map[int64]error) {
+	rows = slices.Clone(rows)
+	slices.SortFunc(rows, func(a, b outboxRow) int { return cmp.Compare(a.ID, b.ID) })
+
+	failed := map[int64]error{}
+	msgs := make([]OutgoingMessage[T], 0, len(rows))
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		msg := OutgoingMessage[T]{OrderingKey: row.OrderingKey}
+		err := json.Unmarshal(row.Attributes, &msg.Attributes)
+		if err == nil {
+			err = decodeMessage(codecs, msg.Attributes, row.Data, &msg.Data)
+		}
+		if err != nil {
+			failed[row.ID] = errors.Wrap(err, "failed to decode outbox message")
+			continue
+		}
+		delete(msg.Attributes, ContentTypeAttribute) // Set again by the publisher's codec
+		msgs = append(msgs, msg)
+		ids = append(ids, row.ID)
+	}
+	if len(msgs) == 0 {
+		return nil, failed
+	}
+
+	err := pub.PublishBatch(ctx, msgs)
+	var batchErr *PublishBatchError
+	switch {
+	case err == nil:
+		return ids, failed
+	case errors.As(err, &batchErr):
+		sent := make([]int64, 0, len(ids))
+		for i, id := range ids {
+			if pubErr, ok := batchErr.Errors[i]; ok {
+				failed[id] = pubErr
+			} else {
+				sent = append(sent, id)
+			}
+		}
+		return sent, failed
+	default:
+		for _, id := range ids {
+			failed[id] = err
+		}
+		return nil, failed
+	}
+}
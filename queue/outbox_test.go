@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type failingPublisher[T any] struct {
+	*MemoryQueue[T]
+	failIndexes map[int]bool
+}
+
+func (fp failingPublisher[T]) PublishBatch(ctx context.Context, msgs []OutgoingMessage[T]) error {
+	errs := map[int]error{}
+	for i := range msgs {
+		if fp.failIndexes[i] {
+			errs[i] = errors.New("publish failed")
+			continue
+		}
+		if err := fp.PublishMessage(ctx, msgs[i]); err != nil {
+			errs[i] = err
+		}
+	}
+	if len(errs) != 0 {
+		return &PublishBatchError{Errors: errs}
+	}
+	return nil
+}
+
+func testOutboxRows(t *testing.T, msgs ...OutgoingMessage[int]) []outboxRow {
+	encoded, err := encodePostgresRows("q", CBORCodec, msgs)
+	require.NoError(t, err)
+	rows := make([]outboxRow, len(encoded))
+	for i := range encoded {
+		rows[i] = outboxRow{
+			ID:          int64(len(encoded) - i), // Claims are returned in any order
+			Data:        encoded[i].Data,
+			Attributes:  []byte(encoded[i].Attributes),
+			OrderingKey: encoded[i].OrderingKey,
+			Attempts:    1,
+		}
+	}
+	return rows
+}
+
+func Test_relayOutboxRows(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("all sent", func(t *testing.T) {
+		mq := NewMemoryQueue[int](10)
+		rows := testOutboxRows(t,
+			OutgoingMessage[int]{Data: 1, Attributes: map[string]string{"k": "v"}},
+			OutgoingMessage[int]{Data: 2, OrderingKey: "user"},
+		)
+		sent, failed := relayOutboxRows[int](ctx, mq, DefaultCodecs(), rows)
+		require.Empty(t, failed)
+		require.Equal(t, []int64{1, 2}, sent)
+
+		ch := mq.ReceiveCh(ctx)
+		first, second := <-ch, <-ch
+		// Relayed in id order, and re-encoded by the publisher rather than keeping the outbox content type
+		require.Equal(t, 2, first.Data)
+		require.Equal(t, "user", first.OrderingKey)
+		require.Equal(t, 1, second.Data)
+		require.Equal(t, map[string]string{"k": "v"}, second.Attributes)
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		pub := failingPublisher[int]{MemoryQueue: NewMemoryQueue[int](10), failIndexes: map[int]bool{1: true}}
+		rows := testOutboxRows(t, OutgoingMessage[int]{Data: 1}, OutgoingMessage[int]{Data: 2}, OutgoingMessage[int]{Data: 3})
+		rows = append(rows, outboxRow{ID: 4, Data: []byte("{"), Attributes: []byte("{}")})
+
+		sent, failed := relayOutboxRows[int](ctx, pub, DefaultCodecs(), rows)
+		require.Equal(t, []int64{1, 3}, sent)
+		require.Len(t, failed, 2)
+		require.Contains(t, failed[2].Error(), "publish failed")
+		require.Contains(t, failed[4].Error(), "failed to decode")
+		require.Equal(t, 2, pub.Len())
+	})
+
+	t.Run("publish error", func(t *testing.T) {
+		mq := NewMemoryQueue[int](0)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		sent, failed := relayOutboxRows[int](cancelled, mq, DefaultCodecs(), testOutboxRows(t, OutgoingMessage[int]{Data: 1}))
+		require.Empty(t, sent)
+		require.ErrorIs(t, failed[1], context.Canceled)
+	})
+}
+
+func Test_OutboxRelay_Postgres(t *testing.T) {
+	sdb := testPostgres(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := sdb.ExecContext(ctx, OutboxSchema)
+	require.NoError(t, err)
+	queue := t.Name() + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	defer sdb.Exec("DELETE FROM queue_outbox WHERE queue = $1", queue)
+
+	writer := NewOutboxWriter[int](queue, PublisherOptions{})
+	enqueue := func(commit bool, msgs ...OutgoingMessage[int]) {
+		tx, err := sdb.BeginTxx(ctx, nil)
+		require.NoError(t, err)
+		require.NoError(t, writer.EnqueueMessages(ctx, tx, msgs...))
+		if commit {
+			require.NoError(t, tx.Commit())
+		} else {
+			require.NoError(t, tx.Rollback())
+		}
+	}
+	// Only the messages of committed transactions are relayed
+	enqueue(false, OutgoingMessage[int]{Data: 1})
+	enqueue(true, OutgoingMessage[int]{Data: 2, OrderingKey: "user"}, OutgoingMessage[int]{Data: 3, OrderingKey: "user"},
+		OutgoingMessage[int]{Data: 4})
+
+	pub := failingPublisher[int]{MemoryQueue: NewMemoryQueue[int](10), failIndexes: map[int]bool{0: true}}
+	relay, err := NewOutboxRelay[int](logrus.New(), sdb, queue, pub, OutboxRelayConfig{
+		PollInterval:   10 * time.Millisecond,
+		BatchSize:      10,
+		Lease:          time.Minute,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		Retention:      time.Hour,
+	})
+	require.NoError(t, err)
+
+	// 3 waits for 2, which fails to publish
+	n, err := relay.(*outboxRelay[int]).relayBatch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, 1, pub.Len())
+	var lastError string
+	require.NoError(t, sdb.GetContext(ctx, &lastError, "SELECT last_error FROM queue_outbox WHERE queue = $1 AND sent_at IS NULL AND attempts = 1", queue))
+	require.Contains(t, lastError, "publish failed")
+
+	delete(pub.failIndexes, 0)
+	require.Eventually(t, func() bool {
+		_, err := relay.(*outboxRelay[int]).relayBatch(ctx)
+		require.NoError(t, err)
+		return pub.Len() == 3
+	}, 5*time.Second, 20*time.Millisecond)
+
+	ch := pub.ReceiveCh(ctx)
+	var relayed []int
+	for range 3 {
+		msg := <-ch
+		relayed = append(relayed, msg.Data)
+	}
+	require.Equal(t, []int{4, 2, 3}, relayed)
+	var unsent int
+	require.NoError(t, sdb.GetContext(ctx, &unsent, "SELECT count(*) FROM queue_outbox WHERE queue = $1 AND sent_at IS NULL", queue))
+	require.Zero(t, unsent)
+}
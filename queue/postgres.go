@@ -0,0 +1,267 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"github.com/usecorn/common-lib/app"
+)
+
+// PostgresQueueSchema is the table used by the Postgres queue backend, all queues share the same table.
+const PostgresQueueSchema = `CREATE TABLE IF NOT EXISTS queue_messages (
+	id BIGSERIAL PRIMARY KEY,
+	queue TEXT NOT NULL,
+	data BYTEA NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	available_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	attributes JSONB NOT NULL DEFAULT '{}',
+	ordering_key TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS queue_messages_queue_available_at_idx ON queue_messages (queue, available_at);
+CREATE INDEX IF NOT EXISTS queue_messages_queue_ordering_key_idx ON queue_messages (queue, ordering_key, id) WHERE ordering_key <> '';`
+
+// PostgresSubscriberConfig configures how a Postgres subscriber polls for messages
+type PostgresSubscriberConfig struct {
+	// PollInterval is how long to wait before polling again when the queue is empty
+	PollInterval time.Duration `env:"QUEUE_POLL_INTERVAL" env-default:"1s"`
+	// BatchSize is the maximum number of messages claimed per poll
+	BatchSize int `env:"QUEUE_BATCH_SIZE" env-default:"10"`
+	// AckDeadline is how long a claimed message is hidden from other subscribers before it is redelivered,
+	// if it is neither acked nor nacked
+	AckDeadline time.Duration `env:"QUEUE_ACK_DEADLINE" env-default:"1m"`
+	// AckTimeout is the timeout for the ack/nack queries
+	AckTimeout time.Duration `env:"QUEUE_ACK_TIMEOUT" env-default:"10s"`
+}
+
+// postgresMaxBatchRows keeps batch inserts under the postgres limit of 65535 parameters
+const postgresMaxBatchRows = 10000
+
+type postgresPublisher[T any] struct {
+	queue string
+	sdb   *sqlx.DB
+	opts  PublisherOptions
+}
+
+type postgresInsertRow struct {
+	Queue       string `db:"queue"`
+	Data        []byte `db:"data"`
+	Attributes  string `db:"attributes"`
+	OrderingKey string `db:"ordering_key"`
+}
+
+// NewPostgresPublisher creates a QueuePublisher which inserts messages into the queue_messages table, encoded with opts.Codec.
+func NewPostgresPublisher[T any](sdb *sqlx.DB, queue string, opts PublisherOptions) (QueuePublisher[T], error) {
+	return &postgresPublisher[T]{queue: queue, sdb: sdb, opts: opts.withDefaults()}, nil
+}
+
+func (pub *postgresPublisher[T]) Publish(ctx context.Context, data T) error {
+	return pub.PublishMessage(ctx, OutgoingMessage[T]{Data: data})
+}
+
+func (pub *postgresPublisher[T]) PublishMessage(ctx context.Context, msg OutgoingMessage[T]) error {
+	return pub.PublishBatch(ctx, []OutgoingMessage[T]{msg})
+}
+
+// PublishBatch inserts all the messages in a single transaction, so either all or none of them are published.
+func (pub *postgresPublisher[T]) PublishBatch(ctx context.Context, msgs []OutgoingMessage[T]) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	rows, err := encodePostgresRows(pub.queue, pub.opts.Codec, msgs)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pub.sdb.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertPostgresRows(ctx, tx, "queue_messages", rows); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func encodePostgresRows[T any](queue string, codec Codec, msgs []OutgoingMessage[T]) ([]postgresInsertRow, error) {
+	rows := make([]postgresInsertRow, len(msgs))
+	for i := range msgs {
+		data, err := codec.Marshal(msgs[i].Data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to marshal message %d", i)
+		}
+		attributes, err := json.Marshal(withContentType(msgs[i].Attributes, codec))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to marshal attributes of message %d", i)
+		}
+		rows[i] = postgresInsertRow{Queue: queue, Data: data, Attributes: string(attributes), OrderingKey: msgs[i].OrderingKey}
+	}
+	return rows, nil
+}
+
+// insertPostgresRows inserts rows into table, which must have the queue, data, attributes and ordering_key columns
+func insertPostgresRows(ctx context.Context, tx *sqlx.Tx, table string, rows []postgresInsertRow) error {
+	for start := 0; start < len(rows); start += postgresMaxBatchRows {
+		end := min(start+postgresMaxBatchRows, len(rows))
+		_, err := tx.NamedExecContext(ctx, `INSERT INTO `+table+` (queue, data, attributes, ordering_key)
+			VALUES (:queue, :data, :attributes, :ordering_key)`, rows[start:end])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type postgresSubscriber[T any] struct {
+	queue   string
+	log     logrus.Ext1FieldLogger
+	conf    PostgresSubscriberConfig
+	claim   *sqlx.Stmt
+	ack     *sqlx.Stmt
+	nack    *sqlx.Stmt
+	release *sqlx.Stmt
+	opts    SubscriberOptions
+}
+
+type postgresRow struct {
+	ID          int64  `db:"id"`
+	Data        []byte `db:"data"`
+	Attempts    int    `db:"attempts"`
+	Attributes  []byte `db:"attributes"`
+	OrderingKey string `db:"ordering_key"`
+}
+
+// NewPostgresSubscriber creates a QueueSubscriber which claims messages from the queue_messages table with
+// FOR UPDATE SKIP LOCKED, so that any number of subscribers can safely consume from the same queue.
+// Messages with an ordering key are only claimed once every earlier message with that key has been acked.
+// Nacked messages are retried with the backoff from opts.Retry, and are dead-lettered once they run out of attempts.
+func NewPostgresSubscriber[T any](log logrus.Ext1FieldLogger, sdb *sqlx.DB, queue string, conf PostgresSubscriberConfig,
+	opts SubscriberOptions) (QueueSubscriber[T], error) {
+	if conf.BatchSize <= 0 {
+		return nil, errors.New("batch size must be positive")
+	}
+	if conf.PollInterval <= 0 || conf.AckDeadline <= 0 || conf.AckTimeout <= 0 {
+		return nil, errors.New("poll interval, ack deadline and ack timeout must be positive")
+	}
+	claim, err := sdb.Preparex(`UPDATE queue_messages SET attempts = attempts + 1, available_at = now() + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT id FROM queue_messages q WHERE queue = $1 AND available_at <= now()
+			AND (ordering_key = '' OR NOT EXISTS (
+				SELECT 1 FROM queue_messages prev WHERE prev.queue = q.queue AND prev.ordering_key = q.ordering_key AND prev.id < q.id
+			))
+			ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED
+		) RETURNING id, data, attempts, attributes, ordering_key`)
+	if err != nil {
+		return nil, err
+	}
+	ack, err := sdb.Preparex("DELETE FROM queue_messages WHERE id = $1")
+	if err != nil {
+		return nil, err
+	}
+	nack, err := sdb.Preparex("UPDATE queue_messages SET available_at = now() + make_interval(secs => $2) WHERE id = $1")
+	if err != nil {
+		return nil, err
+	}
+	// Claimed messages which were never delivered are made available again, without counting the attempt
+	release, err := sdb.Preparex("UPDATE queue_messages SET attempts = attempts - 1, available_at = now() WHERE id = $1")
+	if err != nil {
+		return nil, err
+	}
+	return &postgresSubscriber[T]{
+		queue:   queue,
+		log:     log,
+		conf:    conf,
+		claim:   claim,
+		ack:     ack,
+		nack:    nack,
+		release: release,
+		opts:    opts.withDefaults(),
+	}, nil
+}
+
+func (sub *postgresSubscriber[T]) ReceiveCh(ctx context.Context) <-chan Message[T] {
+	outChan := make(chan Message[T])
+	go func() {
+		defer close(outChan)
+		for {
+			var rows []postgresRow
+			err := sub.claim.SelectContext(ctx, &rows, sub.queue, sub.conf.BatchSize, sub.conf.AckDeadline.Seconds())
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				sub.opts.reportError(sub.log, errors.Wrapf(err, "failed to claim messages from queue %s", sub.queue))
+			}
+
+			for i, row := range rows {
+				msg := Message[T]{
+					ID:              strconv.FormatInt(row.ID, 10),
+					OrderingKey:     row.OrderingKey,
+					DeliveryAttempt: row.Attempts,
+					Msg:             &postgresMessage[T]{sub: sub, row: row},
+				}
+				err := json.Unmarshal(row.Attributes, &msg.Attributes)
+				if err == nil {
+					err = decodeMessage(sub.opts.Codecs, msg.Attributes, row.Data, &msg.Data)
+				}
+				if err != nil {
+					err = errors.Wrapf(err, "failed to decode queue message %d", row.ID)
+					(&postgresMessage[T]{sub: sub, row: row}).fail(ctx, err)
+					continue
+				}
+				select {
+				case outChan <- msg:
+				case <-ctx.Done():
+					for _, unsent := range rows[i:] {
+						sub.settle(sub.release, unsent.ID)
+					}
+					return
+				}
+			}
+
+			if len(rows) == 0 {
+				if app.SleepContext(ctx, sub.conf.PollInterval) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return outChan
+}
+
+func (sub *postgresSubscriber[T]) settle(stmt *sqlx.Stmt, args ...any) {
+	ctx, cancel := context.WithTimeout(context.Background(), sub.conf.AckTimeout)
+	defer cancel()
+	if _, err := stmt.ExecContext(ctx, args...); err != nil {
+		sub.opts.reportError(sub.log, errors.Wrapf(err, "failed to ack/nack queue message %v", args[0]))
+	}
+}
+
+type postgresMessage[T any] struct {
+	sub *postgresSubscriber[T]
+	row postgresRow
+}
+
+func (pm *postgresMessage[T]) Ack() {
+	pm.sub.settle(pm.sub.ack, pm.row.ID)
+}
+
+func (pm *postgresMessage[T]) Nack() {
+	pm.fail(context.Background(), nil)
+}
+
+// fail either dead-letters the message or makes it available again after the retry backoff
+func (pm *postgresMessage[T]) fail(ctx context.Context, parseErr error) {
+	if pm.sub.opts.giveUp(ctx, pm.sub.log, pm.sub.queue, pm.row.Data, pm.row.Attempts, parseErr) {
+		pm.Ack()
+		return
+	}
+	pm.sub.settle(pm.sub.nack, pm.row.ID, pm.sub.opts.Retry.Backoff(pm.row.Attempts).Seconds())
+}
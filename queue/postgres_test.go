@@ -0,0 +1,164 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// testPostgres connects to the database in TEST_DATABASE_URL, skipping the test if it is not set
+func testPostgres(t *testing.T) *sqlx.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	sdb, err := sqlx.Connect("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { sdb.Close() })
+	return sdb
+}
+
+// testPostgresQueue creates the queue table and returns a queue name which is not used by other tests
+func testPostgresQueue(t *testing.T, sdb *sqlx.DB) string {
+	_, err := sdb.Exec(PostgresQueueSchema)
+	require.NoError(t, err)
+	queue := t.Name() + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	t.Cleanup(func() { sdb.Exec("DELETE FROM queue_messages WHERE queue = $1", queue) })
+	return queue
+}
+
+func countPostgresMessages(t *testing.T, sdb *sqlx.DB, queue string) int {
+	var n int
+	require.NoError(t, sdb.Get(&n, "SELECT count(*) FROM queue_messages WHERE queue = $1", queue))
+	return n
+}
+
+var testPostgresSubscriberConfig = PostgresSubscriberConfig{
+	PollInterval: 10 * time.Millisecond,
+	BatchSize:    5,
+	AckDeadline:  time.Minute,
+	AckTimeout:   5 * time.Second,
+}
+
+func Test_PostgresQueue_SkipLocked(t *testing.T) {
+	sdb := testPostgres(t)
+	queue := testPostgresQueue(t, sdb)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pub, err := NewPostgresPublisher[int](sdb, queue, PublisherOptions{})
+	require.NoError(t, err)
+	const n = 50
+	msgs := make([]OutgoingMessage[int], n)
+	for i := range msgs {
+		msgs[i] = OutgoingMessage[int]{Data: i}
+	}
+	require.NoError(t, pub.PublishBatch(ctx, msgs))
+
+	// Subscribers claiming at the same time never receive the same message
+	subCtx, stop := context.WithCancel(ctx)
+	received := make(chan int, 2*n)
+	wg := sync.WaitGroup{}
+	for range 3 {
+		sub, err := NewPostgresSubscriber[int](logrus.New(), sdb, queue, testPostgresSubscriberConfig, SubscriberOptions{})
+		require.NoError(t, err)
+		ch := sub.ReceiveCh(subCtx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range ch {
+				msg.Ack()
+				received <- msg.Data
+			}
+		}()
+	}
+
+	seen := map[int]bool{}
+	for len(seen) < n {
+		select {
+		case data := <-received:
+			require.False(t, seen[data], "message %d was delivered twice", data)
+			seen[data] = true
+		case <-ctx.Done():
+			t.Fatalf("received %d of %d messages", len(seen), n)
+		}
+	}
+	stop()
+	wg.Wait()
+	close(received)
+	for data := range received {
+		require.False(t, seen[data], "message %d was delivered twice", data)
+	}
+	require.Zero(t, countPostgresMessages(t, sdb, queue))
+}
+
+func Test_PostgresQueue_Release(t *testing.T) {
+	sdb := testPostgres(t)
+	queue := testPostgresQueue(t, sdb)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pub, err := NewPostgresPublisher[int](sdb, queue, PublisherOptions{})
+	require.NoError(t, err)
+	for i := range testPostgresSubscriberConfig.BatchSize {
+		require.NoError(t, pub.Publish(ctx, i))
+	}
+	sub, err := NewPostgresSubscriber[int](logrus.New(), sdb, queue, testPostgresSubscriberConfig, SubscriberOptions{})
+	require.NoError(t, err)
+
+	// The whole batch is claimed, but only the messages which were delivered count as attempts
+	subCtx, stop := context.WithCancel(ctx)
+	ch := sub.ReceiveCh(subCtx)
+	<-ch
+	stop()
+	delivered := 1
+	for range ch {
+		delivered++
+	}
+
+	var released, attempted int
+	require.NoError(t, sdb.Get(&released, "SELECT count(*) FROM queue_messages WHERE queue = $1 AND attempts = 0 AND available_at <= now()", queue))
+	require.NoError(t, sdb.Get(&attempted, "SELECT count(*) FROM queue_messages WHERE queue = $1 AND attempts = 1", queue))
+	require.Equal(t, testPostgresSubscriberConfig.BatchSize-delivered, released)
+	require.Equal(t, delivered, attempted)
+}
+
+func Test_PostgresQueue_Retry(t *testing.T) {
+	sdb := testPostgres(t)
+	queue := testPostgresQueue(t, sdb)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pub, err := NewPostgresPublisher[int](sdb, queue, PublisherOptions{})
+	require.NoError(t, err)
+	require.NoError(t, pub.Publish(ctx, 42))
+
+	dlq := NewMemoryQueue[DeadLetter](1)
+	sub, err := NewPostgresSubscriber[int](logrus.New(), sdb, queue, testPostgresSubscriberConfig, SubscriberOptions{
+		Retry:      RetryPolicy{MaxAttempts: 2, InitialBackoff: 20 * time.Millisecond},
+		DeadLetter: dlq,
+	})
+	require.NoError(t, err)
+
+	ch := sub.ReceiveCh(ctx)
+	for attempt := 1; attempt <= 2; attempt++ {
+		msg := <-ch
+		require.Equal(t, attempt, msg.DeliveryAttempt)
+		require.Equal(t, 42, msg.Data)
+		msg.Nack()
+	}
+
+	// Dead-lettered and deleted after the last attempt
+	dead := <-dlq.ReceiveCh(ctx)
+	require.Equal(t, 2, dead.Data.Attempts)
+	require.Equal(t, queue, dead.Data.Source)
+	require.Zero(t, countPostgresMessages(t, sdb, queue))
+}
@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrMaxAttemptsExceeded is reported when a message has been nacked more times than the retry policy allows
+var ErrMaxAttemptsExceeded = errors.New("message exceeded the maximum number of delivery attempts")
+
+// RetryPolicy controls how many times a message, or a failing subscription, is retried and how long to back off between attempts
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, 0 retries forever
+	MaxAttempts int `env:"QUEUE_MAX_ATTEMPTS" env-default:"5"`
+	// InitialBackoff is the backoff after the first failed attempt
+	InitialBackoff time.Duration `env:"QUEUE_INITIAL_BACKOFF" env-default:"1s"`
+	// MaxBackoff caps the exponential backoff
+	MaxBackoff time.Duration `env:"QUEUE_MAX_BACKOFF" env-default:"1m"`
+	// Multiplier is applied to the backoff after each failed attempt
+	Multiplier float64 `env:"QUEUE_BACKOFF_MULTIPLIER" env-default:"2"`
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Multiplier:     2,
+	}
+}
+
+// ShouldRetry returns true if another attempt is allowed after the given number of attempts
+func (rp RetryPolicy) ShouldRetry(attempts int) bool {
+	return rp.MaxAttempts <= 0 || attempts < rp.MaxAttempts
+}
+
+// Backoff returns how long to wait after the given number of failed attempts
+func (rp RetryPolicy) Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	multiplier := rp.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(rp.InitialBackoff) * math.Pow(multiplier, float64(attempts-1))
+	if rp.MaxBackoff > 0 && backoff > float64(rp.MaxBackoff) {
+		return rp.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// DeadLetter is published to the dead-letter queue for messages which could not be processed
+type DeadLetter struct {
+	// Source is the subscription or queue the message was received from
+	Source string `json:"source"`
+	// Data is the raw payload of the message
+	Data []byte `json:"data"`
+	// Error is the reason the message was dead-lettered, such as the parse error
+	Error string `json:"error"`
+	// Attempts is the number of delivery attempts, 0 if unknown
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// SubscriberOptions configures retries, dead-lettering, error reporting and decoding for a QueueSubscriber
+type SubscriberOptions struct {
+	Retry RetryPolicy
+	// DeadLetter receives poison messages, and messages which exceeded Retry.MaxAttempts. If nil, those messages are
+	// reported to OnError and dropped, poison messages straight away as retrying cannot fix them.
+	DeadLetter QueuePublisher[DeadLetter]
+	// OnError is called with errors which cannot be returned to the caller, such as parse errors and subscription
+	// failures. If nil, errors are logged.
+	OnError func(err error)
+	// Codecs are used to decode messages according to their content-type attribute, defaults to DefaultCodecs
+	Codecs []Codec
+}
+
+func (opts SubscriberOptions) withDefaults() SubscriberOptions {
+	if opts.Retry == (RetryPolicy{}) {
+		opts.Retry = DefaultRetryPolicy()
+	}
+	if len(opts.Codecs) == 0 {
+		opts.Codecs = DefaultCodecs()
+	}
+	return opts
+}
+
+func (opts SubscriberOptions) reportError(log logrus.Ext1FieldLogger, err error) {
+	if opts.OnError != nil {
+		opts.OnError(err)
+		return
+	}
+	log.WithError(err).Error("queue subscriber error")
+}
+
+// giveUp decides what happens to a message which failed after the given number of attempts. A non-nil parseErr marks
+// a poison message, which can never be processed, so it is given up on straight away, and dropped if there is no
+// dead-letter queue. It returns true if the message was dead-lettered or dropped and should be acked, and false if it should be nacked so
+// that it is retried.
+func (opts SubscriberOptions) giveUp(ctx context.Context, log logrus.Ext1FieldLogger, source string, data []byte,
+	attempts int, parseErr error) bool {
+	canRetry := opts.Retry.ShouldRetry(attempts)
+	if parseErr == nil && canRetry {
+		return false
+	}
+	cause := parseErr
+	if cause == nil {
+		cause = errors.Wrapf(ErrMaxAttemptsExceeded, "%d attempts", attempts)
+	}
+	if opts.DeadLetter == nil {
+		opts.reportError(log, cause)
+		return true
+	}
+
+	err := opts.DeadLetter.Publish(ctx, DeadLetter{
+		Source:   source,
+		Data:     data,
+		Error:    cause.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		opts.reportError(log, errors.WithSecondaryError(errors.Wrapf(err, "failed to dead-letter message from %s", source), cause))
+		return false
+	}
+	log.WithError(cause).WithField("source", source).Warn("dead-lettered queue message")
+	return true
+}
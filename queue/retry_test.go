@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/cockroachdb/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RetryPolicy(t *testing.T) {
+	rp := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+	require.Equal(t, time.Second, rp.Backoff(0))
+	require.Equal(t, time.Second, rp.Backoff(1))
+	require.Equal(t, 2*time.Second, rp.Backoff(2))
+	require.Equal(t, 4*time.Second, rp.Backoff(3))
+	require.Equal(t, 5*time.Second, rp.Backoff(4))
+
+	require.True(t, rp.ShouldRetry(2))
+	require.False(t, rp.ShouldRetry(3))
+	require.True(t, RetryPolicy{}.ShouldRetry(100))
+}
+
+func Test_giveUp(t *testing.T) {
+	ctx := context.Background()
+	log := logrus.New()
+	parseErr := errors.New("bad json")
+
+	t.Run("dead letter", func(t *testing.T) {
+		dlq := NewMemoryQueue[DeadLetter](10)
+		opts := SubscriberOptions{DeadLetter: dlq}.withDefaults()
+
+		require.False(t, opts.giveUp(ctx, log, "q", []byte("{}"), 1, nil))
+		require.Equal(t, 0, dlq.Len())
+
+		require.True(t, opts.giveUp(ctx, log, "q", []byte("{"), 1, parseErr))
+		require.True(t, opts.giveUp(ctx, log, "q", []byte("{}"), 5, nil))
+		require.Equal(t, 2, dlq.Len())
+
+		msg := (<-dlq.ch).msg.Data
+		require.Equal(t, DeadLetter{Source: "q", Data: []byte("{"), Error: "bad json", Attempts: 1, FailedAt: msg.FailedAt}, msg)
+		msg = (<-dlq.ch).msg.Data
+		require.Contains(t, msg.Error, ErrMaxAttemptsExceeded.Error())
+	})
+
+	t.Run("no dead letter", func(t *testing.T) {
+		var reported []error
+		opts := SubscriberOptions{OnError: func(err error) { reported = append(reported, err) }}.withDefaults()
+
+		require.False(t, opts.giveUp(ctx, log, "q", []byte("{}"), 1, nil))
+		// Poison messages are dropped even when the attempts are unknown, rather than nacked forever
+		require.True(t, opts.giveUp(ctx, log, "q", []byte("{"), 0, parseErr))
+		require.True(t, opts.giveUp(ctx, log, "q", []byte("{}"), 5, nil))
+		require.Len(t, reported, 2)
+		require.ErrorIs(t, reported[0], parseErr)
+		require.ErrorIs(t, reported[1], ErrMaxAttemptsExceeded)
+	})
+
+	t.Run("dead letter failure", func(t *testing.T) {
+		var reported []error
+		dlq := NewMemoryQueue[DeadLetter](0)
+		opts := SubscriberOptions{DeadLetter: dlq, OnError: func(err error) { reported = append(reported, err) }}.withDefaults()
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		require.False(t, opts.giveUp(cancelled, log, "q", []byte("{"), 1, parseErr))
+		require.Len(t, reported, 1)
+		require.ErrorIs(t, reported[0], context.Canceled)
+	})
+}
+
+func Test_GooglePubSubSubscriber_DeadLetter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, topic := newTestPubSub(ctx, t, pubsub.SubscriptionConfig{})
+
+	dlq := NewMemoryQueue[DeadLetter](10)
+	sub, err := NewGooglePubSubSubscriberWithOptions[int](ctx, logrus.New(), client, "sub", SubscriberOptions{DeadLetter: dlq})
+	require.NoError(t, err)
+	pub, err := NewGooglePubSubPublisher[int](ctx, logrus.New(), client, "topic")
+	require.NoError(t, err)
+
+	_, err = topic.Publish(ctx, &pubsub.Message{Data: []byte("not json")}).Get(ctx)
+	require.NoError(t, err)
+	require.NoError(t, pub.Publish(ctx, 42))
+
+	recvCtx, recvCancel := context.WithCancel(ctx)
+	ch := sub.ReceiveCh(recvCtx)
+	msg := <-ch
+	require.Equal(t, 42, msg.Data)
+	msg.Ack()
+
+	dead := (<-dlq.ch).msg.Data
+	require.Equal(t, "sub", dead.Source)
+	require.Equal(t, []byte("not json"), dead.Data)
+	require.Contains(t, dead.Error, "failed to decode")
+
+	recvCancel()
+	for range ch {
+	}
+}
@@ -1,9 +1,71 @@
 package queue
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OutgoingMessage is a message to publish along with its attributes and ordering key
+type OutgoingMessage[T any] struct {
+	Data T
+	// Attributes are sent alongside the message body, and can be used for filtering without decoding it
+	Attributes map[string]string
+	// OrderingKey makes messages with the same key get delivered in the order they were published, such as a user address
+	OrderingKey string
+}
+
+// PublisherOptions configures how a QueuePublisher encodes messages
+type PublisherOptions struct {
+	// Codec encodes the message payloads, defaults to JSONCodec
+	Codec Codec
+	// EnableMessageOrdering enables message ordering on Google Pub/Sub topics, which is required to publish messages
+	// with an ordering key. Publishes of each key are serialized, and are paused after a failure until it is resumed.
+	EnableMessageOrdering bool
+}
+
+func (opts PublisherOptions) withDefaults() PublisherOptions {
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec
+	}
+	return opts
+}
 
 type QueuePublisher[T any] interface {
 	Publish(ctx context.Context, data T) error
+	// PublishMessage publishes a message with attributes and an ordering key
+	PublishMessage(ctx context.Context, msg OutgoingMessage[T]) error
+	// PublishBatch publishes many messages at once, it returns a *PublishBatchError if only some of them failed
+	PublishBatch(ctx context.Context, msgs []OutgoingMessage[T]) error
+}
+
+// PublishBatchError is returned by PublishBatch when some of the messages failed to publish,
+// so that only those can be retried
+type PublishBatchError struct {
+	// Errors maps the index of each failed message to the reason it failed
+	Errors map[int]error
+}
+
+func (pbe *PublishBatchError) Error() string {
+	indexes := make([]int, 0, len(pbe.Errors))
+	for i := range pbe.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	msgs := make([]string, len(indexes))
+	for j, i := range indexes {
+		msgs[j] = fmt.Sprintf("message %d: %s", i, pbe.Errors[i])
+	}
+	return fmt.Sprintf("failed to publish %d messages: %s", len(indexes), strings.Join(msgs, "; "))
+}
+
+func (pbe *PublishBatchError) Unwrap() []error {
+	out := make([]error, 0, len(pbe.Errors))
+	for _, err := range pbe.Errors {
+		out = append(out, err)
+	}
+	return out
 }
 
 type UnderlyingMessage interface {
@@ -12,8 +74,14 @@ type UnderlyingMessage interface {
 }
 
 type Message[T any] struct {
-	Data T
-	Msg  UnderlyingMessage
+	// ID is the identifier assigned to the message by the backend, unique within the queue
+	ID          string
+	Data        T
+	Attributes  map[string]string
+	OrderingKey string
+	// DeliveryAttempt is the number of times the message has been delivered, including this one, 0 if unknown
+	DeliveryAttempt int
+	Msg             UnderlyingMessage
 }
 
 func (m *Message[T]) Ack() {
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"strings"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -12,11 +14,83 @@ type Chain struct {
 	RPCMaxConcurrency int64         `env:"RPC_MAX_CONCURRENCY" env-default:"5"`
 	RPCRateLimit      int           `env:"RPC_RATE_LIMIT" env-default:"10"`
 	RPCRateDur        time.Duration `env:"RPC_RATE_DUR" env-default:"1s"`
-	RPCMaxRetries     int           `env:"RPC_MAX_RETRY" env-default:"5"`
+	RPCMaxRetries     int           `env:"RPC_MAX_RETRY" env-default:"5"` // Attempts of a request, including the first
 	RPCRetryDelay     time.Duration `env:"RPC_RETRY_DELAY" env-default:"1s"`
 	RPCURL            string        `env:"RPC_URL" env-default:""`
 	CornRPCURL        string        `env:"CORN_RPC_URL" env-default:""`
 	LagBlocks         uint64        `env:"LAG_BLOCKS" env-default:"9"`
+	// RPCFallbackURLs and CornRPCFallbackURLs are comma separated endpoints which are used, in order,
+	// when RPCURL and CornRPCURL fail
+	RPCFallbackURLs     []string `env:"RPC_FALLBACK_URLS" env-default:""`
+	CornRPCFallbackURLs []string `env:"CORN_RPC_FALLBACK_URLS" env-default:""`
+	// RPCMaxRetryDelay caps the exponential backoff between retries which starts at RPCRetryDelay
+	RPCMaxRetryDelay time.Duration `env:"RPC_MAX_RETRY_DELAY" env-default:"30s"`
+	// RPCUnhealthyThreshold is the number of consecutive failures after which an endpoint is skipped
+	// for RPCUnhealthyCooldown, while other endpoints are healthy
+	RPCUnhealthyThreshold int           `env:"RPC_UNHEALTHY_THRESHOLD" env-default:"3"`
+	RPCUnhealthyCooldown  time.Duration `env:"RPC_UNHEALTHY_COOLDOWN" env-default:"30s"`
+	// MaxLogRange is the largest block range requested from eth_getLogs at once, 0 is unlimited.
+	// Ranges the RPC provider rejects are split further.
+	MaxLogRange uint64 `env:"MAX_LOG_RANGE" env-default:"0"`
+	// NetworkMaxLogRanges overrides MaxLogRange for networks, such as "corn-mainnet:10000,ethereum:2000"
+	NetworkMaxLogRanges map[string]uint64 `env:"NETWORK_MAX_LOG_RANGES" env-default:""`
+	// NetworkRPCURLs maps network names to their RPC URLs, the primary URL followed by the fallbacks.
+	// It is not read from the environment directly, see LoadNetworkRPCURLs.
+	NetworkRPCURLs map[string][]string
+}
+
+// GetMaxLogRange returns the largest block range requested from eth_getLogs at once on network, 0 is unlimited
+func (c Chain) GetMaxLogRange(network string) uint64 {
+	if maxRange, ok := c.NetworkMaxLogRanges[network]; ok {
+		return maxRange
+	}
+	return c.MaxLogRange
+}
+
+// LoadNetworkRPCURLs resolves the primary RPC URL of network and its comma separated fallback URLs into
+// NetworkRPCURLs, so that GetRPCURLs never reads the environment. The environment variables urlEnv and fallbacksEnv
+// are only read when c has no field loaded from them, such as RPCURL for RPC_URL, or that field is empty.
+// Empty URLs are skipped.
+func (c *Chain) LoadNetworkRPCURLs(network, urlEnv, fallbacksEnv string) {
+	urls := []string{c.loadedValue(urlEnv)}
+	if fallbacksEnv != "" {
+		urls = append(urls, strings.Split(c.loadedValue(fallbacksEnv), ",")...)
+	}
+	out := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if url = strings.TrimSpace(url); url != "" {
+			out = append(out, url)
+		}
+	}
+	if c.NetworkRPCURLs == nil {
+		c.NetworkRPCURLs = make(map[string][]string)
+	}
+	c.NetworkRPCURLs[network] = out
+}
+
+// loadedValue returns the value of the field of c loaded from the environment variable env, with lists joined by
+// commas, or the environment variable itself when there is no such field or it is empty
+func (c Chain) loadedValue(env string) string {
+	var val string
+	switch env {
+	case "RPC_URL":
+		val = c.RPCURL
+	case "CORN_RPC_URL":
+		val = c.CornRPCURL
+	case "RPC_FALLBACK_URLS":
+		val = strings.Join(c.RPCFallbackURLs, ",")
+	case "CORN_RPC_FALLBACK_URLS":
+		val = strings.Join(c.CornRPCFallbackURLs, ",")
+	}
+	if val == "" && env != "" {
+		val = os.Getenv(env)
+	}
+	return val
+}
+
+// GetRPCURLs returns the RPC URLs of network resolved by LoadNetworkRPCURLs, the primary URL followed by the fallbacks
+func (c Chain) GetRPCURLs(network string) []string {
+	return c.NetworkRPCURLs[network]
 }
 
 func (c Chain) GetRateLimiter() *rate.Limiter {
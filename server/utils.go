@@ -159,6 +159,22 @@ func GetPagenation(c *gin.Context, maxPageSize, defaultPageSize int64) (int64, i
 	return page, pageSize, nil
 }
 
+// GetCursorPagination takes the query parameters "cursor" and "pageSize" from the gin context, for keyset pagination
+// with dbutils.SelectKeysetPage. The cursor is empty for the first page, and pageSize defaults to defaultPageSize if not set.
+// Returns cursor, pageSize, error
+func GetCursorPagination(c *gin.Context, maxPageSize, defaultPageSize int64) (string, int64, error) {
+	pageSize, err := GetInt64Query(c, "pageSize", maxPageSize, defaultPageSize)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if pageSize <= 0 {
+		return "", 0, errors.New("pageSize must be greater than 0")
+	}
+
+	return c.Query("cursor"), pageSize, nil
+}
+
 // SafeMetricsInc increments the given metric with the given label values, and logs an error if the increment fails.
 // All parameters can safely be nil, if metric is nil, this function does nothing.
 func SafeMetricsInc(log logrus.Ext1FieldLogger, metric *ginmetrics.Metric, labelValues []string) {
@@ -0,0 +1,245 @@
+package siwe
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/usecorn/common-lib/validate"
+)
+
+// Based on https://eips.ethereum.org/EIPS/eip-4361
+
+const (
+	// Version is the only version of EIP-4361 messages
+	Version = "1"
+
+	headerSuffix      = " wants you to sign in with your Ethereum account:"
+	uriTag            = "URI: "
+	versionTag        = "Version: "
+	chainIDTag        = "Chain ID: "
+	nonceTag          = "Nonce: "
+	issuedAtTag       = "Issued At: "
+	expirationTimeTag = "Expiration Time: "
+	notBeforeTag      = "Not Before: "
+	requestIDTag      = "Request ID: "
+	resourcesTag      = "Resources:"
+	resourcePrefix    = "- "
+)
+
+var ErrInvalidMessage = errors.New("invalid sign-in with ethereum message")
+
+// Message is a Sign-In With Ethereum (EIP-4361) message
+type Message struct {
+	// Scheme is the optional URI scheme of the origin of the request, e.g. "https"
+	Scheme string
+	// Domain is the RFC 3986 authority that is requesting the signing
+	Domain string
+	// Address is the address performing the signing
+	Address common.Address
+	// Statement is an optional human-readable assertion that the user will sign, must not contain newlines
+	Statement string
+	// URI is the RFC 3986 URI referring to the resource that is the subject of the signing
+	URI string
+	// Version is the EIP-4361 version of the message, must be "1"
+	Version string
+	// ChainID is the EIP-155 chain ID to which the session is bound
+	ChainID int64
+	// Nonce is a randomized token used to prevent replay attacks, at least 8 alphanumeric characters
+	Nonce string
+	// IssuedAt is the time when the message was generated
+	IssuedAt time.Time
+	// ExpirationTime is the optional time when the signed authentication message is no longer valid
+	ExpirationTime *time.Time
+	// NotBefore is the optional time when the signed authentication message will become valid
+	NotBefore *time.Time
+	// RequestID is an optional system-specific identifier that may be used to uniquely refer to the sign-in request
+	RequestID string
+	// Resources is an optional list of URIs the user wishes to have resolved as part of authentication
+	Resources []string
+}
+
+// String builds the EIP-4361 text of the message, which is what the user signs.
+func (m Message) String() string {
+	var sb strings.Builder
+	if len(m.Scheme) != 0 {
+		sb.WriteString(m.Scheme + "://")
+	}
+	sb.WriteString(m.Domain + headerSuffix + "\n")
+	sb.WriteString(m.Address.Hex() + "\n\n")
+	if len(m.Statement) != 0 {
+		sb.WriteString(m.Statement + "\n")
+	}
+	sb.WriteString("\n")
+	sb.WriteString(uriTag + m.URI + "\n")
+	sb.WriteString(versionTag + m.Version + "\n")
+	sb.WriteString(chainIDTag + strconv.FormatInt(m.ChainID, 10) + "\n")
+	sb.WriteString(nonceTag + m.Nonce + "\n")
+	sb.WriteString(issuedAtTag + m.IssuedAt.UTC().Format(time.RFC3339))
+	if m.ExpirationTime != nil {
+		sb.WriteString("\n" + expirationTimeTag + m.ExpirationTime.UTC().Format(time.RFC3339))
+	}
+	if m.NotBefore != nil {
+		sb.WriteString("\n" + notBeforeTag + m.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if len(m.RequestID) != 0 {
+		sb.WriteString("\n" + requestIDTag + m.RequestID)
+	}
+	if len(m.Resources) != 0 {
+		sb.WriteString("\n" + resourcesTag)
+		for _, resource := range m.Resources {
+			sb.WriteString("\n" + resourcePrefix + resource)
+		}
+	}
+	return sb.String()
+}
+
+// Validate checks that all of the required fields of the message are present and well formed.
+func (m Message) Validate() error {
+	if len(m.Domain) == 0 {
+		return errors.Wrap(ErrInvalidMessage, "domain is required")
+	}
+	if (m.Address == common.Address{}) {
+		return errors.Wrap(ErrInvalidMessage, "address is required")
+	}
+	if strings.Contains(m.Statement, "\n") {
+		return errors.Wrap(ErrInvalidMessage, "statement must not contain newlines")
+	}
+	if len(m.URI) == 0 {
+		return errors.Wrap(ErrInvalidMessage, "uri is required")
+	}
+	if m.Version != Version {
+		return errors.Wrapf(ErrInvalidMessage, "unsupported version \"%s\"", m.Version)
+	}
+	if m.ChainID <= 0 {
+		return errors.Wrap(ErrInvalidMessage, "chain id must be positive")
+	}
+	if !isValidNonce(m.Nonce) {
+		return errors.Wrap(ErrInvalidMessage, "nonce must be at least 8 alphanumeric characters")
+	}
+	if m.IssuedAt.IsZero() {
+		return errors.Wrap(ErrInvalidMessage, "issued at is required")
+	}
+	return nil
+}
+
+// ParseMessage parses the EIP-4361 text of a message.
+func ParseMessage(raw string) (Message, error) {
+	lines := strings.Split(raw, "\n")
+	out := Message{}
+
+	// Header: [scheme://]domain wants you to sign in with your Ethereum account:
+	if len(lines) < 4 || !strings.HasSuffix(lines[0], headerSuffix) {
+		return Message{}, errors.Wrap(ErrInvalidMessage, "missing header")
+	}
+	out.Domain = strings.TrimSuffix(lines[0], headerSuffix)
+	if scheme, domain, found := strings.Cut(out.Domain, "://"); found {
+		out.Scheme = scheme
+		out.Domain = domain
+	}
+
+	// Address, which must be EIP-55 checksummed
+	_, checksummed, err := validate.NormalizeEthAddr(lines[1])
+	if err != nil || checksummed != lines[1] {
+		return Message{}, errors.Wrapf(ErrInvalidMessage, "address \"%s\" must be an EIP-55 checksummed address", lines[1])
+	}
+	out.Address = common.HexToAddress(checksummed)
+
+	if len(lines[2]) != 0 {
+		return Message{}, errors.Wrap(ErrInvalidMessage, "expected an empty line after the address")
+	}
+
+	// Optional statement, followed by an empty line
+	i := 3
+	if len(lines[i]) != 0 {
+		out.Statement = lines[i]
+		i++
+		if i >= len(lines) || len(lines[i]) != 0 {
+			return Message{}, errors.Wrap(ErrInvalidMessage, "expected an empty line after the statement")
+		}
+	}
+	i++
+
+	var (
+		chainID, issuedAt, expirationTime, notBefore string
+		fields                                       = []struct {
+			tag      string
+			dest     *string
+			required bool
+		}{
+			{uriTag, &out.URI, true},
+			{versionTag, &out.Version, true},
+			{chainIDTag, &chainID, true},
+			{nonceTag, &out.Nonce, true},
+			{issuedAtTag, &issuedAt, true},
+			{expirationTimeTag, &expirationTime, false},
+			{notBeforeTag, &notBefore, false},
+			{requestIDTag, &out.RequestID, false},
+		}
+	)
+	for _, field := range fields {
+		if i < len(lines) && strings.HasPrefix(lines[i], field.tag) {
+			*field.dest = strings.TrimPrefix(lines[i], field.tag)
+			i++
+		} else if field.required {
+			return Message{}, errors.Wrapf(ErrInvalidMessage, "missing \"%s\" field", strings.TrimSuffix(field.tag, ": "))
+		}
+	}
+
+	if i < len(lines) && lines[i] == resourcesTag {
+		i++
+		for ; i < len(lines) && strings.HasPrefix(lines[i], resourcePrefix); i++ {
+			out.Resources = append(out.Resources, strings.TrimPrefix(lines[i], resourcePrefix))
+		}
+	}
+	if i != len(lines) {
+		return Message{}, errors.Wrapf(ErrInvalidMessage, "unexpected line \"%s\"", lines[i])
+	}
+
+	out.ChainID, err = strconv.ParseInt(chainID, 10, 64)
+	if err != nil {
+		return Message{}, errors.Wrapf(ErrInvalidMessage, "invalid chain id \"%s\"", chainID)
+	}
+	if out.IssuedAt, err = parseTime(issuedAtTag, issuedAt); err != nil {
+		return Message{}, err
+	}
+	if len(expirationTime) != 0 {
+		t, err := parseTime(expirationTimeTag, expirationTime)
+		if err != nil {
+			return Message{}, err
+		}
+		out.ExpirationTime = &t
+	}
+	if len(notBefore) != 0 {
+		t, err := parseTime(notBeforeTag, notBefore)
+		if err != nil {
+			return Message{}, err
+		}
+		out.NotBefore = &t
+	}
+
+	return out, out.Validate()
+}
+
+func parseTime(tag, val string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(ErrInvalidMessage, "invalid %s\"%s\"", strings.ToLower(tag), val)
+	}
+	return t, nil
+}
+
+func isValidNonce(nonce string) bool {
+	if len(nonce) < 8 {
+		return false
+	}
+	for _, c := range nonce {
+		if !strings.ContainsRune(nonceChars, c) {
+			return false
+		}
+	}
+	return true
+}
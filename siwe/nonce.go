@@ -0,0 +1,79 @@
+package siwe
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"time"
+
+	"github.com/cockroachdb/errors"
+
+	"github.com/usecorn/common-lib/dbutils"
+)
+
+const (
+	nonceChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// NonceLength is the length of the nonces generated by NewNonce
+	NonceLength = 17
+)
+
+var ErrInvalidNonce = errors.New("nonce is unknown, expired or already used")
+
+// NewNonce generates a new random alphanumeric nonce.
+func NewNonce() (string, error) {
+	out := make([]byte, NonceLength)
+	charCount := big.NewInt(int64(len(nonceChars)))
+	for i := range out {
+		n, err := rand.Int(rand.Reader, charCount)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to generate nonce")
+		}
+		out[i] = nonceChars[n.Int64()]
+	}
+	return string(out), nil
+}
+
+// NonceStore keeps track of issued nonces, so that each one can only be used once.
+type NonceStore interface {
+	// Put records a newly issued nonce, which may be used until expiresAt.
+	Put(ctx context.Context, nonce string, expiresAt time.Time) error
+	// Consume marks the nonce as used, returns ErrInvalidNonce if the nonce was never issued, has expired or was already used.
+	Consume(ctx context.Context, nonce string) error
+}
+
+type metaNonceStore struct {
+	meta dbutils.MetaDB
+	now  func() time.Time
+}
+
+// NewMetaNonceStore creates a NonceStore which stores the nonces in a MetaDB.
+func NewMetaNonceStore(meta dbutils.MetaDB) NonceStore {
+	return &metaNonceStore{meta: meta, now: time.Now}
+}
+
+func nonceKey(nonce string) string {
+	return "siwe::nonce::" + nonce
+}
+
+// Put stores the nonce with a TTL, so that it is deleted from the MetaDB once it can no longer be used
+func (mns *metaNonceStore) Put(ctx context.Context, nonce string, expiresAt time.Time) error {
+	return mns.meta.SetWithTTL(ctx, nonceKey(nonce), expiresAt.Unix(), expiresAt.Sub(mns.now()))
+}
+
+func (mns *metaNonceStore) Consume(ctx context.Context, nonce string) error {
+	expiresAt, err := mns.meta.GetInt64(ctx, nonceKey(nonce))
+	if err != nil {
+		return errors.WithSecondaryError(ErrInvalidNonce, err)
+	}
+	if expiresAt == 0 || mns.now().Unix() > expiresAt { // 0 marks a consumed nonce
+		return ErrInvalidNonce
+	}
+	consumed, err := mns.meta.CompareAndSet(ctx, nonceKey(nonce), expiresAt, int64(0))
+	if err != nil {
+		return err
+	}
+	if !consumed { // Consumed concurrently by another request
+		return ErrInvalidNonce
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package siwe
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/samber/lo"
+
+	"github.com/usecorn/common-lib/eth"
+)
+
+var (
+	ErrDomainMismatch  = errors.New("message domain does not match")
+	ErrChainIDMismatch = errors.New("message chain id is not allowed")
+	ErrExpired         = errors.New("message has expired")
+	ErrNotYetValid     = errors.New("message is not yet valid")
+)
+
+// Config configures a Verifier
+type Config struct {
+	// Domain is the domain users sign in to, messages for any other domain are rejected
+	Domain string `env:"SIWE_DOMAIN" env-default:""`
+	// URI is the URI put into new messages
+	URI string `env:"SIWE_URI" env-default:""`
+	// Statement is the statement put into new messages
+	Statement string `env:"SIWE_STATEMENT" env-default:""`
+	// ChainIDs are the chain IDs users may sign in with, defaults to those of eth.Networks
+	ChainIDs []int64 `env:"SIWE_CHAIN_IDS"`
+	// MessageTTL is how long a new message (and its nonce) is valid for
+	MessageTTL time.Duration `env:"SIWE_MESSAGE_TTL" env-default:"10m"`
+	// ClockSkew is the tolerance applied to issued at, expiration and not before times
+	ClockSkew time.Duration `env:"SIWE_CLOCK_SKEW" env-default:"1m"`
+}
+
+// Verifier builds and verifies Sign-In With Ethereum messages
+type Verifier interface {
+	// NewMessage builds a new message for the address to sign, issuing a new nonce.
+	NewMessage(ctx context.Context, address common.Address, chainID int64) (Message, error)
+	// Verify parses the raw message, validates it, consumes its nonce and verifies the signature.
+	// Returns the parsed message if the sign in is valid.
+	Verify(ctx context.Context, rawMessage string, signature []byte) (Message, error)
+}
+
+type verifier struct {
+	conf   Config
+	nonces NonceStore
+	sigs   eth.SignatureVerifier
+	now    func() time.Time
+}
+
+// NewVerifier creates a new Verifier. sigs is used to verify the message signatures, and so determines if contract wallets are supported.
+func NewVerifier(conf Config, nonces NonceStore, sigs eth.SignatureVerifier) (Verifier, error) {
+	if len(conf.Domain) == 0 {
+		return nil, errors.New("siwe domain is required")
+	}
+	if len(conf.ChainIDs) == 0 {
+		conf.ChainIDs = eth.Networks.ChainIDs()
+	}
+	if conf.MessageTTL <= 0 {
+		return nil, errors.New("siwe message ttl must be positive")
+	}
+	return &verifier{conf: conf, nonces: nonces, sigs: sigs, now: time.Now}, nil
+}
+
+func (v *verifier) NewMessage(ctx context.Context, address common.Address, chainID int64) (Message, error) {
+	if !lo.Contains(v.conf.ChainIDs, chainID) {
+		return Message{}, errors.Wrapf(ErrChainIDMismatch, "chain id %d", chainID)
+	}
+	nonce, err := NewNonce()
+	if err != nil {
+		return Message{}, err
+	}
+	issuedAt := v.now().UTC().Truncate(time.Second)
+	expiresAt := issuedAt.Add(v.conf.MessageTTL)
+
+	if err := v.nonces.Put(ctx, nonce, expiresAt); err != nil {
+		return Message{}, errors.Wrap(err, "failed to store nonce")
+	}
+
+	msg := Message{
+		Domain:         v.conf.Domain,
+		Address:        address,
+		Statement:      v.conf.Statement,
+		URI:            v.conf.URI,
+		Version:        Version,
+		ChainID:        chainID,
+		Nonce:          nonce,
+		IssuedAt:       issuedAt,
+		ExpirationTime: &expiresAt,
+	}
+	return msg, msg.Validate()
+}
+
+func (v *verifier) Verify(ctx context.Context, rawMessage string, signature []byte) (Message, error) {
+	msg, err := ParseMessage(rawMessage)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if msg.Domain != v.conf.Domain {
+		return Message{}, errors.Wrapf(ErrDomainMismatch, "expected \"%s\", got \"%s\"", v.conf.Domain, msg.Domain)
+	}
+	if !lo.Contains(v.conf.ChainIDs, msg.ChainID) {
+		return Message{}, errors.Wrapf(ErrChainIDMismatch, "chain id %d", msg.ChainID)
+	}
+
+	now := v.now()
+	if msg.IssuedAt.After(now.Add(v.conf.ClockSkew)) {
+		return Message{}, errors.Wrap(ErrNotYetValid, "issued in the future")
+	}
+	if msg.ExpirationTime != nil && now.Add(-v.conf.ClockSkew).After(*msg.ExpirationTime) {
+		return Message{}, ErrExpired
+	}
+	if msg.NotBefore != nil && now.Add(v.conf.ClockSkew).Before(*msg.NotBefore) {
+		return Message{}, ErrNotYetValid
+	}
+
+	// The signature is checked before the nonce is consumed, so a bad signature cannot burn someone else's nonce
+	if err := v.sigs.VerifyPersonalSign(ctx, msg.Address, []byte(rawMessage), signature); err != nil {
+		return Message{}, err
+	}
+	if err := v.nonces.Consume(ctx, msg.Nonce); err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,133 @@
+package siwe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/usecorn/common-lib/dbutils"
+	"github.com/usecorn/common-lib/eth"
+)
+
+func Test_ParseMessage(t *testing.T) {
+	raw := "https://example.com wants you to sign in with your Ethereum account:\n" +
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed\n\n" +
+		"I accept the ExampleOrg Terms of Service: https://example.com/tos\n\n" +
+		"URI: https://example.com/login\n" +
+		"Version: 1\n" +
+		"Chain ID: 1\n" +
+		"Nonce: 32891756abcdefgh\n" +
+		"Issued At: 2021-09-30T16:25:24Z\n" +
+		"Expiration Time: 2021-09-30T16:35:24Z\n" +
+		"Resources:\n" +
+		"- ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/\n" +
+		"- https://example.com/my-web2-claim.json"
+
+	msg, err := ParseMessage(raw)
+	require.NoError(t, err)
+	require.Equal(t, "https", msg.Scheme)
+	require.Equal(t, "example.com", msg.Domain)
+	require.Equal(t, common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"), msg.Address)
+	require.Equal(t, "I accept the ExampleOrg Terms of Service: https://example.com/tos", msg.Statement)
+	require.Equal(t, int64(eth.EthereumChainID), msg.ChainID)
+	require.Equal(t, "32891756abcdefgh", msg.Nonce)
+	require.NotNil(t, msg.ExpirationTime)
+	require.Nil(t, msg.NotBefore)
+	require.Len(t, msg.Resources, 2)
+	require.Equal(t, raw, msg.String())
+
+	noStatement := Message{
+		Domain:   "app.usecorn.com",
+		Address:  common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"),
+		URI:      "https://app.usecorn.com",
+		Version:  Version,
+		ChainID:  eth.CornMainnetChainID,
+		Nonce:    "abcdefgh12345678",
+		IssuedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	parsed, err := ParseMessage(noStatement.String())
+	require.NoError(t, err)
+	require.Equal(t, noStatement, parsed)
+
+	_, err = ParseMessage(noStatement.String() + "\nsomething else")
+	require.ErrorIs(t, err, ErrInvalidMessage)
+
+	lowercaseAddr := "example.com wants you to sign in with your Ethereum account:\n0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed\n\n\nURI: https://example.com\nVersion: 1\nChain ID: 1\nNonce: 32891756abcdefgh\nIssued At: 2021-09-30T16:25:24Z"
+	_, err = ParseMessage(lowercaseAddr)
+	require.ErrorIs(t, err, ErrInvalidMessage)
+}
+
+func Test_Verifier(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+
+	meta, err := dbutils.NewMetaDBMemory()
+	require.NoError(t, err)
+
+	v, err := NewVerifier(Config{Domain: "app.usecorn.com", URI: "https://app.usecorn.com", Statement: "Sign in to Corn", MessageTTL: 10 * time.Minute},
+		NewMetaNonceStore(meta), eth.NewSignatureVerifier(nil))
+	require.NoError(t, err)
+
+	sign := func(msg Message) []byte {
+		sig, err := crypto.Sign(eth.PersonalSignHash([]byte(msg.String())).Bytes(), key)
+		require.NoError(t, err)
+		return sig
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		msg, err := v.NewMessage(ctx, addr, eth.CornMainnetChainID)
+		require.NoError(t, err)
+
+		verified, err := v.Verify(ctx, msg.String(), sign(msg))
+		require.NoError(t, err)
+		require.Equal(t, addr, verified.Address)
+
+		// Nonces can only be used once
+		_, err = v.Verify(ctx, msg.String(), sign(msg))
+		require.ErrorIs(t, err, ErrInvalidNonce)
+	})
+
+	t.Run("unknown chain", func(t *testing.T) {
+		_, err := v.NewMessage(ctx, addr, 5)
+		require.ErrorIs(t, err, ErrChainIDMismatch)
+	})
+
+	t.Run("wrong domain", func(t *testing.T) {
+		msg, err := v.NewMessage(ctx, addr, eth.EthereumChainID)
+		require.NoError(t, err)
+		msg.Domain = "evil.com"
+		_, err = v.Verify(ctx, msg.String(), sign(msg))
+		require.ErrorIs(t, err, ErrDomainMismatch)
+	})
+
+	t.Run("unknown nonce", func(t *testing.T) {
+		msg, err := v.NewMessage(ctx, addr, eth.EthereumChainID)
+		require.NoError(t, err)
+		msg.Nonce = "neverissued1234"
+		_, err = v.Verify(ctx, msg.String(), sign(msg))
+		require.ErrorIs(t, err, ErrInvalidNonce)
+	})
+
+	t.Run("wrong signer", func(t *testing.T) {
+		msg, err := v.NewMessage(ctx, common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"), eth.EthereumChainID)
+		require.NoError(t, err)
+		_, err = v.Verify(ctx, msg.String(), sign(msg))
+		require.ErrorIs(t, err, eth.ErrSignerMismatch)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		msg, err := v.NewMessage(ctx, addr, eth.EthereumChainID)
+		require.NoError(t, err)
+		v.(*verifier).now = func() time.Time { return time.Now().Add(time.Hour) }
+		defer func() { v.(*verifier).now = time.Now }()
+
+		_, err = v.Verify(ctx, msg.String(), sign(msg))
+		require.ErrorIs(t, err, ErrExpired)
+	})
+}
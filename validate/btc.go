@@ -1,47 +1,113 @@
 package validate
 
 import (
-	"regexp"
 	"strings"
 
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/cockroachdb/errors"
 
 	"github.com/usecorn/common-lib/bitcoin"
 )
 
+// Deprecated: the regexes only check the shape of an address, use ParseBtcAddress which verifies checksums.
 const (
 	BtcAddrRegex        = `^(bc1|[13]|tb1|[2mn])[a-zA-HJ-NP-Z0-9]{25,64}$`
 	BtcTestnetAddrRegex = `^(tb1|[2mn])[a-zA-HJ-NP-Z0-9]{25,64}$`
 	BtcMainnetRegex     = `^(bc1|[13])[a-zA-HJ-NP-Z0-9]{25,64}$`
 )
 
-var (
-	btcAddrExp        = regexp.MustCompile(BtcAddrRegex)
-	btcTestnetAddrExp = regexp.MustCompile(BtcTestnetAddrRegex)
-	btcMainnetExp     = regexp.MustCompile(BtcMainnetRegex)
-)
+var ErrInvalidBtcAddr = errors.New("invalid bitcoin address")
+
+// BtcAddress is a fully decoded Bitcoin address
+type BtcAddress struct {
+	// Address is the canonical encoding of the address, bech32 addresses are lowercased
+	Address string
+	// Network is the name of the network the address belongs to, e.g. "mainnet", "testnet3" or "regtest"
+	Network string
+	// Type is the type of script the address pays to
+	Type bitcoin.AddressType
+	// WitnessVersion is the segwit version of the address, 0 for P2WPKH/P2WSH, 1 for taproot and -1 for non segwit addresses
+	WitnessVersion int
+	// WitnessProgram is the witness program of a segwit address, nil for non segwit addresses
+	WitnessProgram []byte
+}
+
+// IsMainnet returns true if the address is a Bitcoin mainnet address
+func (ba BtcAddress) IsMainnet() bool {
+	return ba.Network == chaincfg.MainNetParams.Name
+}
+
+// IsTestnet returns true if the address belongs to any of the test networks
+func (ba BtcAddress) IsTestnet() bool {
+	return !ba.IsMainnet()
+}
+
+// IsSegwit returns true if the address is a native segwit address (any witness version)
+func (ba BtcAddress) IsSegwit() bool {
+	return ba.WitnessVersion >= 0
+}
+
+// IsTapRoot returns true if the address is a taproot (witness v1) address
+func (ba BtcAddress) IsTapRoot() bool {
+	return ba.Type == bitcoin.AddressTypeP2TR
+}
 
-// IsTapRoot checks if a BTC address is a taproot address
+// ParseBtcAddress fully decodes a Bitcoin address, verifying its Base58Check or bech32/bech32m checksum
+// and the witness version, and returns the decoded address.
+func ParseBtcAddress(addr string) (BtcAddress, error) {
+	decoded, params, err := bitcoin.DecodeAddress(strings.TrimSpace(addr))
+	if err != nil {
+		return BtcAddress{}, errors.WithSecondaryError(ErrInvalidBtcAddr, err)
+	}
+
+	out := BtcAddress{
+		Address:        decoded.EncodeAddress(),
+		Network:        params.Name,
+		Type:           bitcoin.GetAddressType(decoded),
+		WitnessVersion: -1,
+	}
+
+	switch a := decoded.(type) {
+	case *btcutil.AddressWitnessPubKeyHash:
+		out.WitnessVersion = int(a.WitnessVersion())
+		out.WitnessProgram = a.WitnessProgram()
+	case *btcutil.AddressWitnessScriptHash:
+		out.WitnessVersion = int(a.WitnessVersion())
+		out.WitnessProgram = a.WitnessProgram()
+	case *btcutil.AddressTaproot:
+		out.WitnessVersion = int(a.WitnessVersion())
+		out.WitnessProgram = a.WitnessProgram()
+	}
+
+	return out, nil
+}
+
+// IsTapRoot checks if a BTC address is a valid taproot address
 func IsTapRoot(address string) bool {
-	return strings.HasPrefix(strings.ToLower(address), "bc1p") || strings.HasPrefix(strings.ToLower(address), "tb1p")
+	addr, err := ParseBtcAddress(address)
+	return err == nil && addr.IsTapRoot()
 }
 
-// IsBitcoinTestnet checks if a BTC address is a testnet address
+// IsBitcoinTestnet checks if a BTC address is a valid testnet address
 func IsBitcoinTestnet(address string) bool {
-	return btcTestnetAddrExp.MatchString(strings.ToLower(address))
+	addr, err := ParseBtcAddress(address)
+	return err == nil && addr.IsTestnet()
 }
 
-// IsBitcoinMainnet checks if a BTC address is a mainnet address
+// IsBitcoinMainnet checks if a BTC address is a valid mainnet address
 func IsBitcoinMainnet(address string) bool {
-	return btcMainnetExp.MatchString(strings.ToLower(address))
+	addr, err := ParseBtcAddress(address)
+	return err == nil && addr.IsMainnet()
 }
 
-// GetValidBtcAddr returns a valid Bitcoin address or an error if the address is invalid.
+// GetValidBtcAddr returns a valid Bitcoin address in its canonical encoding or an error if the address is invalid.
 func GetValidBtcAddr(addr string) (string, error) {
-	if !btcAddrExp.MatchString(addr) {
-		return "", errors.New("invalid bitcoin address")
+	parsed, err := ParseBtcAddress(addr)
+	if err != nil {
+		return "", err
 	}
-	return addr, nil
+	return parsed.Address, nil
 }
 
 // CheckValidSecp256k1PubKey checks if a given public key hex is a valid secp256k1 public key
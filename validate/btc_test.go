@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/usecorn/common-lib/bitcoin"
 )
 
 func Test_GetValidBtcAddr(t *testing.T) {
@@ -39,3 +41,58 @@ func Test_CheckValidSecp256k1PubKey(t *testing.T) {
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "not on the secp256k1 curve")
 }
+
+func Test_GetValidBtcAddr_Invalid(t *testing.T) {
+	invalidAddress := []string{
+		"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3",                                      // bad base58 checksum
+		"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLz",                                      // bad base58 checksum
+		"bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdp",                              // bad bech32 checksum
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",                              // witness v0 with bech32m checksum
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd",          // witness v1 with bech32 checksum
+		"bc1qW508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",                              // mixed case
+		"02" + "87176beec39cbbd2f1999209894684e1620bc39ebc2a704add0edb23d0207d7e", // raw public key
+		"",
+	}
+
+	for _, addr := range invalidAddress {
+		_, err := GetValidBtcAddr(addr)
+		require.Errorf(t, err, "GetValidBtcAddr(%s) should have returned an error", addr)
+		require.ErrorIs(t, err, ErrInvalidBtcAddr)
+	}
+}
+
+func Test_ParseBtcAddress(t *testing.T) {
+	tests := []struct {
+		addr           string
+		network        string
+		addrType       bitcoin.AddressType
+		witnessVersion int
+		programLen     int
+	}{
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", "mainnet", bitcoin.AddressTypeP2PKH, -1, 0},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", "mainnet", bitcoin.AddressTypeP2SH, -1, 0},
+		{"bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", "mainnet", bitcoin.AddressTypeP2WPKH, 0, 20},
+		{"bc1qeklep85ntjz4605drds6aww9u0qr46qzrv5xswd35uhjuj8ahfcqgf6hak", "mainnet", bitcoin.AddressTypeP2WSH, 0, 32},
+		{"bc1pxwww0ct9ue7e8tdnlmug5m2tamfn7q06sahstg39ys4c9f3340qqxrdu9k", "mainnet", bitcoin.AddressTypeP2TR, 1, 32},
+		{"tb1q9n5enzz67y7xqeqdvqj2ep072t5rvm05rfvque", "testnet3", bitcoin.AddressTypeP2WPKH, 0, 20},
+		{"2N2zczwTXFEDdme2onpyuaH4uyzsnbr7foR", "testnet3", bitcoin.AddressTypeP2SH, -1, 0},
+	}
+
+	for _, test := range tests {
+		parsed, err := ParseBtcAddress(test.addr)
+		require.NoError(t, err)
+		require.Equal(t, test.addr, parsed.Address)
+		require.Equal(t, test.network, parsed.Network)
+		require.Equal(t, test.addrType, parsed.Type)
+		require.Equal(t, test.witnessVersion, parsed.WitnessVersion)
+		require.Len(t, parsed.WitnessProgram, test.programLen)
+
+		require.Equal(t, test.network == "mainnet", IsBitcoinMainnet(test.addr))
+		require.Equal(t, test.network == "testnet3", IsBitcoinTestnet(test.addr))
+		require.Equal(t, test.addrType == bitcoin.AddressTypeP2TR, IsTapRoot(test.addr))
+	}
+
+	upper, err := GetValidBtcAddr("BC1QAR0SRRR7XFKVY5L643LYDNW9RE59GTZZWF5MDQ")
+	require.NoError(t, err)
+	require.Equal(t, "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", upper)
+}
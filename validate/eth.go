@@ -1,10 +1,13 @@
 package validate
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"regexp"
 	"strings"
 
 	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 const (
@@ -12,8 +15,10 @@ const (
 )
 
 var (
-	EthAddrExp        = regexp.MustCompile(EthAddrRegex)
-	ErrInvalidEthAddr = errors.New("invalid ethereum address")
+	EthAddrExp              = regexp.MustCompile(EthAddrRegex)
+	ErrInvalidEthAddr       = errors.New("invalid ethereum address")
+	ErrInvalidEthChecksum   = errors.New("invalid ethereum address checksum")
+	ErrMissingEthAddrPrefix = errors.New("ethereum address is missing the 0x prefix")
 )
 
 // GetValidEthAddr returns a valid Ethereum address or an error if the address is invalid.
@@ -31,3 +36,145 @@ func GetValidEthAddr(addr string) (string, error) {
 	}
 	return out, nil
 }
+
+// GetValidEthAddrStrict returns the lowercase form of a valid Ethereum address, or an error if the address is invalid.
+// Unlike GetValidEthAddr, the 0x prefix is required and mixed-case addresses must have a valid EIP-55 checksum.
+// All lowercase and all uppercase addresses carry no checksum, and are accepted.
+func GetValidEthAddrStrict(addr string) (string, error) {
+	if !strings.HasPrefix(addr, "0x") {
+		return "", ErrMissingEthAddrPrefix
+	}
+	if !common.IsHexAddress(addr) || len(addr) != 42 {
+		return "", ErrInvalidEthAddr
+	}
+	if !hasValidChecksum(addr) {
+		return "", ErrInvalidEthChecksum
+	}
+	return strings.ToLower(addr), nil
+}
+
+// NormalizeEthAddr validates an Ethereum address and returns both its canonical lowercase form and its EIP-55 checksummed form.
+// The 0x prefix is optional, but if the address is mixed-case its EIP-55 checksum must be valid.
+func NormalizeEthAddr(addr string) (lower string, checksummed string, err error) {
+	if len(addr) == 40 {
+		addr = "0x" + addr
+	}
+	lower, err = GetValidEthAddrStrict(addr)
+	if err != nil {
+		return "", "", err
+	}
+	return lower, common.HexToAddress(lower).Hex(), nil
+}
+
+// hasValidChecksum checks the EIP-55 checksum of a 0x prefixed hex address, single case addresses have no checksum.
+func hasValidChecksum(addr string) bool {
+	hexPart := addr[2:]
+	if hexPart == strings.ToLower(hexPart) || hexPart == strings.ToUpper(hexPart) {
+		return true
+	}
+	return common.HexToAddress(addr).Hex() == addr
+}
+
+// EthAddress is an Ethereum address in its canonical lowercase form.
+// It is validated with ParseEthAddress both when decoded from JSON and when scanned from SQL, so mixed-case addresses
+// must have a valid EIP-55 checksum in either. It can be used directly as a SQL column value.
+// Plain conversions such as EthAddress(s) skip the validation, use ParseEthAddress instead or check the result with Validate.
+type EthAddress string
+
+// ParseEthAddress validates and normalizes an Ethereum address, see NormalizeEthAddr.
+func ParseEthAddress(addr string) (EthAddress, error) {
+	lower, _, err := NormalizeEthAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	return EthAddress(lower), nil
+}
+
+// MustParseEthAddress is like ParseEthAddress but panics on error.
+func MustParseEthAddress(addr string) EthAddress {
+	out, err := ParseEthAddress(addr)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// Validate checks that the address is a valid Ethereum address in its canonical lowercase form
+func (ea EthAddress) Validate() error {
+	parsed, err := ParseEthAddress(string(ea))
+	if err != nil {
+		return err
+	}
+	if parsed != ea {
+		return errors.Wrapf(ErrInvalidEthAddr, "address \"%s\" is not lowercase", string(ea))
+	}
+	return nil
+}
+
+func (ea EthAddress) String() string {
+	return string(ea)
+}
+
+// Checksummed returns the EIP-55 checksummed form of the address
+func (ea EthAddress) Checksummed() string {
+	return common.HexToAddress(string(ea)).Hex()
+}
+
+// Address returns the address as a go-ethereum common.Address
+func (ea EthAddress) Address() common.Address {
+	return common.HexToAddress(string(ea))
+}
+
+// IsZero returns true if the address is empty or the zero address
+func (ea EthAddress) IsZero() bool {
+	return len(ea) == 0 || ea.Address() == common.Address{}
+}
+
+// UnmarshalJSON decodes and validates an address, an empty string decodes to an empty EthAddress.
+func (ea *EthAddress) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) == 0 {
+		*ea = ""
+		return nil
+	}
+	parsed, err := ParseEthAddress(raw)
+	if err != nil {
+		return errors.Wrapf(err, "invalid address \"%s\"", raw)
+	}
+	*ea = parsed
+	return nil
+}
+
+// Value implements driver.Valuer, the address is stored in its lowercase form.
+func (ea EthAddress) Value() (driver.Value, error) {
+	return string(ea), nil
+}
+
+// Scan implements sql.Scanner, with the same validation as UnmarshalJSON.
+func (ea *EthAddress) Scan(src any) error {
+	var raw string
+	switch v := src.(type) {
+	case nil:
+		*ea = ""
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return errors.Errorf("cannot scan %T into EthAddress", src)
+	}
+	if len(raw) == 0 {
+		*ea = ""
+		return nil
+	}
+	parsed, err := ParseEthAddress(raw)
+	if err != nil {
+		return errors.Wrapf(err, "invalid address \"%s\"", raw)
+	}
+	*ea = parsed
+	return nil
+}
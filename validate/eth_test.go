@@ -1,6 +1,7 @@
 package validate
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 
@@ -33,3 +34,69 @@ func Test_GetValidEthAddr(t *testing.T) {
 	_, err = GetValidEthAddr(invalidAddr)
 	require.Error(t, err)
 }
+
+func Test_GetValidEthAddrStrict(t *testing.T) {
+	// Test vectors from https://eips.ethereum.org/EIPS/eip-55
+	validChecksummed := []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	}
+	for _, addr := range validChecksummed {
+		parsed, err := GetValidEthAddrStrict(addr)
+		require.NoError(t, err)
+		require.Equal(t, strings.ToLower(addr), parsed)
+
+		lower, checksummed, err := NormalizeEthAddr(strings.ToLower(addr))
+		require.NoError(t, err)
+		require.Equal(t, strings.ToLower(addr), lower)
+		require.Equal(t, addr, checksummed)
+	}
+
+	_, err := GetValidEthAddrStrict("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD")
+	require.ErrorIs(t, err, ErrInvalidEthChecksum)
+
+	_, err = GetValidEthAddrStrict("5aaeb6053f3e94c9b9a09f33669435e7ef1beaed")
+	require.ErrorIs(t, err, ErrMissingEthAddrPrefix)
+
+	_, err = GetValidEthAddrStrict("0x" + strings.Repeat("|", 40))
+	require.ErrorIs(t, err, ErrInvalidEthAddr)
+
+	_, err = GetValidEthAddrStrict("0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED")
+	require.NoError(t, err) // all uppercase has no checksum
+
+	_, _, err = NormalizeEthAddr("5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD")
+	require.ErrorIs(t, err, ErrInvalidEthChecksum)
+}
+
+func Test_EthAddress(t *testing.T) {
+	var req struct {
+		Addr EthAddress `json:"addr"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"addr":"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}`), &req))
+	require.Equal(t, EthAddress("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"), req.Addr)
+	require.Equal(t, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", req.Addr.Checksummed())
+
+	out, err := json.Marshal(req)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"addr":"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"}`, string(out))
+
+	err = json.Unmarshal([]byte(`{"addr":"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD"}`), &req)
+	require.ErrorIs(t, err, ErrInvalidEthChecksum)
+
+	val, err := req.Addr.Value()
+	require.NoError(t, err)
+	require.Equal(t, "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", val)
+
+	var scanned EthAddress
+	require.NoError(t, scanned.Scan([]byte("0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED")))
+	require.Equal(t, req.Addr, scanned)
+	require.Error(t, scanned.Scan(int64(5)))
+	// The same checksum policy as JSON
+	require.ErrorIs(t, scanned.Scan("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD"), ErrInvalidEthChecksum)
+
+	require.NoError(t, req.Addr.Validate())
+	require.ErrorIs(t, EthAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed").Validate(), ErrInvalidEthAddr)
+	require.ErrorIs(t, EthAddress("0x1234").Validate(), ErrInvalidEthAddr)
+}